@@ -141,6 +141,9 @@ func UnmarshaInitSlice(reader io.Reader, data interface{}, endianness Endianness
 	if slice.Len() == 0 {
 		return fmt.Errorf("not initialized slice")
 	}
+	if s, ok := reader.(Slicer); ok && unmarshalBulk(s, slice, endianness) {
+		return nil
+	}
 	for k := 0; k < slice.Len(); k++ {
 		err := Unmarshal(reader, slice.Index(k).Addr().Interface(), endianness)
 		if err != nil {
@@ -159,6 +162,9 @@ func unmarshalArray(reader io.Reader, data interface{}, endianness Endianness) e
 	if array.Kind() != reflect.Array {
 		return fmt.Errorf("not an Array structure")
 	}
+	if s, ok := reader.(Slicer); ok && unmarshalBulk(s, array, endianness) {
+		return nil
+	}
 	for k := 0; k < array.Len(); k++ {
 		err := Unmarshal(reader, array.Index(k).Addr().Interface(), endianness)
 		if err != nil {
@@ -196,6 +202,12 @@ func unmarshalSlice(reader io.Reader, data interface{}, endianness Endianness) e
 }
 
 func Unmarshal(reader io.Reader, data interface{}, endianness Endianness) error {
+	s, slicer := reader.(Slicer)
+	if slicer {
+		if ok, err := unmarshalFast(s, data, endianness); ok {
+			return err
+		}
+	}
 	val := reflect.ValueOf(data)
 	if val.IsNil() {
 		return ErrInvalidNilPointer
@@ -242,6 +254,11 @@ func Unmarshal(reader io.Reader, data interface{}, endianness Endianness) error
 		}
 
 	default:
+		if slicer {
+			if ok, err := unmarshalKind(s, elem, endianness); ok {
+				return err
+			}
+		}
 		if err := binary.Read(reader, endianness, data); err != nil {
 			return err
 		}
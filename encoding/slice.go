@@ -0,0 +1,268 @@
+package encoding
+
+import (
+	"errors"
+	"io"
+	"math"
+	"reflect"
+)
+
+// Slicer is implemented by in-memory readers which hand out their bytes
+// without copying them, Unmarshal then decodes without binary.Read
+type Slicer interface {
+	// Next returns the n next bytes with the errors of io.ReadFull
+	Next(n int) ([]byte, error)
+	// Remaining is the number of bytes left to read
+	Remaining() int
+}
+
+// FixedUnmarshaler is implemented by fixed size structures decoding themselves
+// from a buffer, it saves the reflection over their fields
+type FixedUnmarshaler interface {
+	FixedSize() int
+	UnmarshalFixed(b []byte, endianness Endianness)
+}
+
+var (
+	errSliceReaderNegative = errors.New("encoding.SliceReader.Seek: negative position")
+	errSliceReaderWhence   = errors.New("encoding.SliceReader.Seek: invalid whence")
+)
+
+// SliceReader is a bytes.Reader implementing Slicer
+type SliceReader struct {
+	data []byte
+	off  int64
+}
+
+func NewSliceReader(data []byte) *SliceReader {
+	return &SliceReader{data: data}
+}
+
+func (r *SliceReader) Reset(data []byte) {
+	r.data = data
+	r.off = 0
+}
+
+func (r *SliceReader) Bytes() []byte {
+	return r.data
+}
+
+func (r *SliceReader) Remaining() int {
+	if r.off >= int64(len(r.data)) {
+		return 0
+	}
+	return int(int64(len(r.data)) - r.off)
+}
+
+func (r *SliceReader) Read(p []byte) (n int, err error) {
+	if r.off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n = copy(p, r.data[r.off:])
+	r.off += int64(n)
+	return
+}
+
+func (r *SliceReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errSliceReaderNegative
+	}
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n = copy(p, r.data[off:])
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+func (r *SliceReader) Next(n int) ([]byte, error) {
+	remaining := r.Remaining()
+	switch {
+	case n == 0:
+		return nil, nil
+	case remaining == 0:
+		return nil, io.EOF
+	case remaining < n:
+		r.off = int64(len(r.data))
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.off : r.off+int64(n)]
+	r.off += int64(n)
+	return b, nil
+}
+
+func (r *SliceReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.off + offset
+	case io.SeekEnd:
+		abs = int64(len(r.data)) + offset
+	default:
+		return 0, errSliceReaderWhence
+	}
+	if abs < 0 {
+		return 0, errSliceReaderNegative
+	}
+	r.off = abs
+	return abs, nil
+}
+
+// unmarshalFast decodes the common types straight from the slicer, it returns
+// false when data has to go through reflection
+func unmarshalFast(s Slicer, data interface{}, endianness Endianness) (bool, error) {
+	if fu, ok := data.(FixedUnmarshaler); ok {
+		// a partial structure is left to the field by field decoding
+		if s.Remaining() < fu.FixedSize() {
+			return false, nil
+		}
+		b, _ := s.Next(fu.FixedSize())
+		fu.UnmarshalFixed(b, endianness)
+		return true, nil
+	}
+
+	var size int
+	switch data.(type) {
+	case *int8, *uint8, *bool:
+		size = 1
+	case *int16, *uint16:
+		size = 2
+	case *int32, *uint32, *float32:
+		size = 4
+	case *int64, *uint64, *float64:
+		size = 8
+	case *[4]byte:
+		size = 4
+	case *[8]byte:
+		size = 8
+	case *[16]byte:
+		size = 16
+	default:
+		return false, nil
+	}
+	b, err := s.Next(size)
+	if err != nil {
+		return true, err
+	}
+	switch v := data.(type) {
+	case *int8:
+		*v = int8(b[0])
+	case *uint8:
+		*v = b[0]
+	case *bool:
+		*v = b[0] != 0
+	case *int16:
+		*v = int16(endianness.Uint16(b))
+	case *uint16:
+		*v = endianness.Uint16(b)
+	case *int32:
+		*v = int32(endianness.Uint32(b))
+	case *uint32:
+		*v = endianness.Uint32(b)
+	case *float32:
+		*v = math.Float32frombits(endianness.Uint32(b))
+	case *int64:
+		*v = int64(endianness.Uint64(b))
+	case *uint64:
+		*v = endianness.Uint64(b)
+	case *float64:
+		*v = math.Float64frombits(endianness.Uint64(b))
+	case *[4]byte:
+		copy(v[:], b)
+	case *[8]byte:
+		copy(v[:], b)
+	case *[16]byte:
+		copy(v[:], b)
+	}
+	return true, nil
+}
+
+// unmarshalKind decodes the named scalar types, returns false for the kinds
+// it does not handle
+func unmarshalKind(s Slicer, elem reflect.Value, endianness Endianness) (bool, error) {
+	kind := elem.Kind()
+	var size int
+	switch kind {
+	case reflect.Int8, reflect.Uint8, reflect.Bool:
+		size = 1
+	case reflect.Int16, reflect.Uint16:
+		size = 2
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		size = 4
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		size = 8
+	default:
+		return false, nil
+	}
+	b, err := s.Next(size)
+	if err != nil {
+		return true, err
+	}
+	switch kind {
+	case reflect.Bool:
+		elem.SetBool(b[0] != 0)
+	case reflect.Int8:
+		elem.SetInt(int64(int8(b[0])))
+	case reflect.Uint8:
+		elem.SetUint(uint64(b[0]))
+	case reflect.Int16:
+		elem.SetInt(int64(int16(endianness.Uint16(b))))
+	case reflect.Uint16:
+		elem.SetUint(uint64(endianness.Uint16(b)))
+	case reflect.Int32:
+		elem.SetInt(int64(int32(endianness.Uint32(b))))
+	case reflect.Uint32:
+		elem.SetUint(uint64(endianness.Uint32(b)))
+	case reflect.Float32:
+		elem.SetFloat(float64(math.Float32frombits(endianness.Uint32(b))))
+	case reflect.Int64:
+		elem.SetInt(int64(endianness.Uint64(b)))
+	case reflect.Uint64:
+		elem.SetUint(endianness.Uint64(b))
+	case reflect.Float64:
+		elem.SetFloat(math.Float64frombits(endianness.Uint64(b)))
+	}
+	return true, nil
+}
+
+var (
+	uint8Type  = reflect.TypeOf(uint8(0))
+	uint16Type = reflect.TypeOf(uint16(0))
+)
+
+// unmarshalBulk fills slices and arrays of bytes or uint16 at once, it returns
+// false for other element types or when the input is too short, the element
+// by element decoding then keeps what could be read
+func unmarshalBulk(s Slicer, seq reflect.Value, endianness Endianness) bool {
+	n := seq.Len()
+	switch seq.Type().Elem() {
+	case uint8Type:
+		if s.Remaining() < n {
+			return false
+		}
+		b, _ := s.Next(n)
+		reflect.Copy(seq, reflect.ValueOf(b))
+	case uint16Type:
+		if s.Remaining() < 2*n {
+			return false
+		}
+		b, _ := s.Next(2 * n)
+		if seq.Kind() == reflect.Slice {
+			u := seq.Convert(reflect.SliceOf(uint16Type)).Interface().([]uint16)
+			for k := range u {
+				u[k] = endianness.Uint16(b[2*k:])
+			}
+			return true
+		}
+		for k := 0; k < n; k++ {
+			seq.Index(k).SetUint(uint64(endianness.Uint16(b[2*k:])))
+		}
+	default:
+		return false
+	}
+	return true
+}
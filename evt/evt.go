@@ -0,0 +1,248 @@
+package evt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"rawsec-evtx/encoding"
+	"rawsec-evtx/evtx"
+)
+
+const (
+	Magic           = "LfLe"
+	HeaderSize      = 0x30
+	RecordHeaderLen = 0x38
+	EOFRecordSize   = 0x28
+)
+
+const (
+	FlagDirty          = 0x1
+	FlagWrapped        = 0x2
+	FlagLogFullWritten = 0x4
+	FlagArchiveSet     = 0x8
+)
+
+var (
+	Endianness = binary.LittleEndian
+
+	ErrBadMagic     = errors.New("bad evt magic")
+	ErrBadHeader    = errors.New("bad evt header")
+	ErrBadRecord    = errors.New("bad evt record")
+	eofRecordMarker = []byte{
+		0x11, 0x11, 0x11, 0x11,
+		0x22, 0x22, 0x22, 0x22,
+		0x33, 0x33, 0x33, 0x33,
+		0x44, 0x44, 0x44, 0x44,
+	}
+)
+
+type Header struct {
+	HeaderSize          uint32
+	Signature           [4]byte
+	MajorVersion        uint32
+	MinorVersion        uint32
+	StartOffset         uint32
+	EndOffset           uint32
+	CurrentRecordNumber uint32
+	OldestRecordNumber  uint32
+	MaxSize             uint32
+	Flags               uint32
+	Retention           uint32
+	EndHeaderSize       uint32
+}
+
+func (h *Header) Verify() error {
+	if string(h.Signature[:]) != Magic {
+		return ErrBadMagic
+	}
+	if h.HeaderSize != HeaderSize || h.EndHeaderSize != HeaderSize {
+		return ErrBadHeader
+	}
+	return nil
+}
+
+func (h *Header) IsDirty() bool {
+	return h.Flags&FlagDirty == FlagDirty
+}
+
+func (h *Header) IsWrapped() bool {
+	return h.Flags&FlagWrapped == FlagWrapped
+}
+
+type EOFRecord struct {
+	RecordSizeBeginning uint32
+	One                 uint32
+	Two                 uint32
+	Three               uint32
+	Four                uint32
+	BeginRecord         uint32
+	EndRecord           uint32
+	CurrentRecordNumber uint32
+	OldestRecordNumber  uint32
+	RecordSizeEnd       uint32
+}
+
+func IsEvt(magic []byte) bool {
+	return len(magic) >= 8 && string(magic[4:8]) == Magic
+}
+
+type File struct {
+	Header Header
+	EOF    *EOFRecord
+	data   []byte
+}
+
+func New(r io.Reader) (ef File, err error) {
+	if ef.data, err = io.ReadAll(r); err != nil {
+		return
+	}
+	if len(ef.data) < HeaderSize {
+		return ef, ErrBadHeader
+	}
+	if err = encoding.Unmarshal(bytes.NewReader(ef.data), &ef.Header, Endianness); err != nil {
+		return
+	}
+	if err = ef.Header.Verify(); err != nil {
+		return
+	}
+	ef.EOF = ef.findEOFRecord()
+	return
+}
+
+func Open(path string) (ef File, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return New(f)
+}
+
+func (ef *File) findEOFRecord() *EOFRecord {
+	for i := HeaderSize + 4; i < len(ef.data); {
+		j := bytes.Index(ef.data[i:], eofRecordMarker)
+		if j < 0 {
+			return nil
+		}
+		start := i + j - 4
+		eof := EOFRecord{}
+		if start+EOFRecordSize <= len(ef.data) &&
+			encoding.Unmarshal(bytes.NewReader(ef.data[start:]), &eof, Endianness) == nil &&
+			eof.RecordSizeBeginning == EOFRecordSize && eof.RecordSizeEnd == EOFRecordSize {
+			return &eof
+		}
+		i += j + 1
+	}
+	return nil
+}
+
+// bounds returns the offsets of the oldest record and of the EOF record,
+// the latter is more reliable than the header when the file is dirty
+func (ef *File) bounds() (begin, end int) {
+	begin, end = int(ef.Header.StartOffset), int(ef.Header.EndOffset)
+	if ef.EOF != nil {
+		begin, end = int(ef.EOF.BeginRecord), int(ef.EOF.EndRecord)
+	}
+	if begin < HeaderSize || begin > len(ef.data) {
+		begin = HeaderSize
+	}
+	if end < HeaderSize || end > len(ef.data) {
+		end = len(ef.data)
+	}
+	return
+}
+
+// linear unwraps the circular buffer of records
+func (ef *File) linear() (buf []byte, fileOffset func(int) int64) {
+	begin, end := ef.bounds()
+	if begin <= end {
+		return ef.data[begin:end], func(pos int) int64 { return int64(begin + pos) }
+	}
+	tail := len(ef.data) - begin
+	buf = make([]byte, 0, tail+end-HeaderSize)
+	buf = append(buf, ef.data[begin:]...)
+	buf = append(buf, ef.data[HeaderSize:end]...)
+	return buf, func(pos int) int64 {
+		if pos < tail {
+			return int64(begin + pos)
+		}
+		return int64(HeaderSize + pos - tail)
+	}
+}
+
+func (ef *File) Records() (cr chan *Record) {
+	return ef.RecordsContext(context.Background())
+}
+
+func (ef *File) RecordsContext(ctx context.Context) (cr chan *Record) {
+	cr = make(chan *Record, 42)
+	go func() {
+		defer close(cr)
+		buf, fileOffset := ef.linear()
+		for pos := 0; pos+RecordHeaderLen <= len(buf); {
+			r, err := ParseRecord(buf[pos:])
+			if err != nil {
+				// resynchronize on the next record signature
+				next := bytes.Index(buf[pos+5:], []byte(Magic))
+				if next < 0 {
+					return
+				}
+				pos += 5 + next - 4
+				continue
+			}
+			r.Offset = fileOffset(pos)
+			select {
+			case cr <- r:
+			case <-ctx.Done():
+				return
+			}
+			pos += int(r.Header.Length)
+		}
+	}()
+	return
+}
+
+func (ef *File) Events() (cgem chan *evtx.GoEvtxMap) {
+	return ef.EventsContext(context.Background())
+}
+
+func (ef *File) EventsContext(ctx context.Context) (cgem chan *evtx.GoEvtxMap) {
+	cgem = make(chan *evtx.GoEvtxMap, 42)
+	go func() {
+		defer close(cgem)
+		for r := range ef.RecordsContext(ctx) {
+			select {
+			case cgem <- r.GoEvtxMap():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return
+}
+
+func (h Header) String() string {
+	return fmt.Sprintf(
+		"Signature: %q\n"+
+			"Version: %d.%d\n"+
+			"StartOffset: 0x%08x\n"+
+			"EndOffset: 0x%08x\n"+
+			"CurrentRecordNumber: %d\n"+
+			"OldestRecordNumber: %d\n"+
+			"MaxSize: %d\n"+
+			"Flags: 0x%08x\n",
+		h.Signature,
+		h.MajorVersion,
+		h.MinorVersion,
+		h.StartOffset,
+		h.EndOffset,
+		h.CurrentRecordNumber,
+		h.OldestRecordNumber,
+		h.MaxSize,
+		h.Flags)
+}
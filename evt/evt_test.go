@@ -0,0 +1,314 @@
+package evt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"rawsec-evtx/encoding"
+	"rawsec-evtx/evtx"
+)
+
+var fxGenerated = time.Date(2008, 5, 17, 9, 30, 0, 0, time.UTC)
+
+func fxUTF16z(s string) []byte {
+	b := new(bytes.Buffer)
+	for _, c := range utf16.Encode([]rune(s + "\x00")) {
+		b.WriteByte(byte(c))
+		b.WriteByte(byte(c >> 8))
+	}
+	return b.Bytes()
+}
+
+func fxMarshal(t *testing.T, data interface{}) []byte {
+	t.Helper()
+	b, err := encoding.Marshal(data, Endianness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func fxPad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// fxSID is S-1-5-18
+var fxSID = []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}
+
+type fxRecord struct {
+	number  uint32
+	eventID uint32
+	strings []string
+	sid     []byte
+	data    []byte
+}
+
+func (r fxRecord) encode(t *testing.T) []byte {
+	t.Helper()
+	body := make([]byte, RecordHeaderLen)
+	body = append(body, fxUTF16z("Fixture-Source")...)
+	body = fxPad(append(body, fxUTF16z("HOST")...))
+	h := RecordHeader{
+		RecordNumber:  r.number,
+		TimeGenerated: uint32(fxGenerated.Add(time.Duration(r.number) * time.Second).Unix()),
+		TimeWritten:   uint32(fxGenerated.Add(time.Duration(r.number)*time.Second + time.Minute).Unix()),
+		EventID:       r.eventID,
+		EventType:     EventTypeInformation,
+		NumStrings:    uint16(len(r.strings)),
+		EventCategory: 3,
+	}
+	copy(h.Signature[:], Magic)
+	h.UserSidOffset, h.UserSidLength = uint32(len(body)), uint32(len(r.sid))
+	body = append(body, r.sid...)
+	h.StringOffset = uint32(len(body))
+	for _, s := range r.strings {
+		body = append(body, fxUTF16z(s)...)
+	}
+	h.DataOffset, h.DataLength = uint32(len(body)), uint32(len(r.data))
+	body = fxPad(append(body, r.data...))
+	h.Length = uint32(len(body) + 4)
+	copy(body, fxMarshal(t, &h))
+	return append(body, fxMarshal(t, &h.Length)...)
+}
+
+func fxEOFRecord(t *testing.T, begin, end int, current, oldest uint32) []byte {
+	return fxMarshal(t, &EOFRecord{
+		RecordSizeBeginning: EOFRecordSize,
+		One:                 0x11111111,
+		Two:                 0x22222222,
+		Three:               0x33333333,
+		Four:                0x44444444,
+		BeginRecord:         uint32(begin),
+		EndRecord:           uint32(end),
+		CurrentRecordNumber: current,
+		OldestRecordNumber:  oldest,
+		RecordSizeEnd:       EOFRecordSize,
+	})
+}
+
+func fxHeader(t *testing.T, data []byte, begin, end int, current, oldest, flags uint32) {
+	h := Header{
+		HeaderSize:          HeaderSize,
+		MajorVersion:        1,
+		MinorVersion:        1,
+		StartOffset:         uint32(begin),
+		EndOffset:           uint32(end),
+		CurrentRecordNumber: current,
+		OldestRecordNumber:  oldest,
+		MaxSize:             uint32(len(data)),
+		Flags:               flags,
+		EndHeaderSize:       HeaderSize,
+	}
+	copy(h.Signature[:], Magic)
+	copy(data, fxMarshal(t, &h))
+}
+
+func fxRecords(t *testing.T, first, last uint32) (records [][]byte) {
+	for n := first; n <= last; n++ {
+		records = append(records, fxRecord{n, 7036, []string{fmt.Sprintf("Service %d", n), "running"}, nil, nil}.encode(t))
+	}
+	return
+}
+
+// fxLinearFile lays the records out one after the other, header is called
+// with the offsets of the first record and of the EOF record
+func fxLinearFile(t *testing.T, records [][]byte, header func(data []byte, begin, end int)) []byte {
+	data := make([]byte, HeaderSize)
+	for _, r := range records {
+		data = append(data, r...)
+	}
+	end := len(data)
+	data = append(data, fxEOFRecord(t, HeaderSize, end, uint32(len(records)+1), 1)...)
+	header(data, HeaderSize, end)
+	return data
+}
+
+func open(t *testing.T, data []byte) *File {
+	t.Helper()
+	ef, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ef
+}
+
+func numbers(ef *File) (n []uint32) {
+	for r := range ef.Records() {
+		n = append(n, r.Header.RecordNumber)
+	}
+	return
+}
+
+func TestRecords(t *testing.T) {
+	data := fxLinearFile(t, fxRecords(t, 1, 4), func(data []byte, begin, end int) {
+		fxHeader(t, data, begin, end, 5, 1, 0)
+	})
+	ef := open(t, data)
+	if fmt.Sprint(numbers(ef)) != "[1 2 3 4]" {
+		t.Errorf("unexpected records %v", numbers(ef))
+	}
+	offset := int64(HeaderSize)
+	for r := range ef.Records() {
+		if r.Offset != offset {
+			t.Errorf("record %d @ %d, expected %d", r.Header.RecordNumber, r.Offset, offset)
+		}
+		offset += int64(r.Header.Length)
+	}
+}
+
+func TestRecordsWrapped(t *testing.T) {
+	records := fxRecords(t, 1, 4)
+	// the second record is split by the end of the file
+	split := len(records[1]) / 2
+	data := make([]byte, HeaderSize)
+	data = append(data, records[1][split:]...)
+	data = append(data, records[2]...)
+	data = append(data, records[3]...)
+	end := len(data)
+	data = append(data, make([]byte, EOFRecordSize)...)
+	data = append(data, make([]byte, 64)...)
+	begin := len(data)
+	data = append(data, records[0]...)
+	data = append(data, records[1][:split]...)
+	copy(data[end:], fxEOFRecord(t, begin, end, 5, 1))
+	fxHeader(t, data, begin, end, 5, 1, FlagWrapped)
+
+	ef := open(t, data)
+	if !ef.Header.IsWrapped() {
+		t.Error("header not flagged wrapped")
+	}
+	if fmt.Sprint(numbers(ef)) != "[1 2 3 4]" {
+		t.Errorf("unexpected records %v", numbers(ef))
+	}
+	for r := range ef.Records() {
+		if r.Header.RecordNumber == 2 && r.Offset != int64(begin+len(records[0])) {
+			t.Errorf("record 2 @ %d, expected %d", r.Offset, begin+len(records[0]))
+		}
+		if r.Header.RecordNumber == 3 && r.Offset != int64(HeaderSize+len(records[1])-split) {
+			t.Errorf("record 3 @ %d, expected %d", r.Offset, HeaderSize+len(records[1])-split)
+		}
+	}
+}
+
+func TestFindEOFRecord(t *testing.T) {
+	records := fxRecords(t, 1, 3)
+	// the EOF marker in the data of a record is not an EOF record
+	marker := fxRecord{4, 1, nil, nil, eofRecordMarker}.encode(t)
+	records = append(records, marker)
+	data := fxLinearFile(t, records, func(data []byte, begin, end int) {
+		// a dirty header is left behind the records written since
+		fxHeader(t, data, begin, begin+len(records[0]), 2, 1, FlagDirty)
+	})
+
+	ef := open(t, data)
+	if !ef.Header.IsDirty() {
+		t.Error("header not flagged dirty")
+	}
+	if ef.EOF == nil {
+		t.Fatal("EOF record not found")
+	}
+	if end := len(data) - EOFRecordSize; ef.EOF.EndRecord != uint32(end) || ef.EOF.CurrentRecordNumber != 5 {
+		t.Errorf("unexpected EOF record %+v", *ef.EOF)
+	}
+	if fmt.Sprint(numbers(ef)) != "[1 2 3 4]" {
+		t.Errorf("unexpected records %v", numbers(ef))
+	}
+
+	// without EOF record the header is trusted
+	ef.EOF = nil
+	if fmt.Sprint(numbers(ef)) != "[1]" {
+		t.Errorf("unexpected records %v without EOF record", numbers(ef))
+	}
+	copy(data[len(data)-EOFRecordSize:], make([]byte, EOFRecordSize))
+	if ef := open(t, data); ef.EOF != nil {
+		t.Errorf("unexpected EOF record %+v", *ef.EOF)
+	}
+}
+
+func TestRecordsResync(t *testing.T) {
+	records := fxRecords(t, 1, 4)
+	// trailing size of the second record and signature of the third
+	records[1][len(records[1])-1] ^= 0xff
+	copy(records[2][4:], "XXXX")
+	data := fxLinearFile(t, records, func(data []byte, begin, end int) {
+		fxHeader(t, data, begin, end, 5, 1, 0)
+	})
+	if n := numbers(open(t, data)); fmt.Sprint(n) != "[1 4]" {
+		t.Errorf("unexpected records %v", n)
+	}
+}
+
+func TestRecordsContext(t *testing.T) {
+	data := fxLinearFile(t, fxRecords(t, 1, 1000), func(data []byte, begin, end int) {
+		fxHeader(t, data, begin, end, 1001, 1, 0)
+	})
+	ef := open(t, data)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	for range ef.EventsContext(ctx) {
+		if n++; n == 5 {
+			cancel()
+		}
+	}
+	if n >= 1000 {
+		t.Errorf("%d events, expected the iteration to stop", n)
+	}
+}
+
+func TestGoEvtxMap(t *testing.T) {
+	r, err := ParseRecord(fxRecord{42, 0x40001b58, []string{"first", "", "third"}, fxSID, []byte{0xde, 0xad, 0xbe, 0xef}}.encode(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.SourceName != "Fixture-Source" || r.Computer != "HOST" || r.UserSID != "S-1-5-18" {
+		t.Errorf("unexpected record %+v", r)
+	}
+
+	e := r.GoEvtxMap()
+	for path, want := range map[string]interface{}{
+		"/Event/System/Provider/Name":            "Fixture-Source",
+		"/Event/System/Provider/EventSourceName": "Fixture-Source",
+		"/Event/System/EventID":                  "7000",
+		"/Event/System/Qualifiers":               "16384",
+		"/Event/System/Level":                    "4",
+		"/Event/System/Task":                     "3",
+		"/Event/System/Keywords":                 "0x80000000000000",
+		"/Event/System/EventRecordID":            "42",
+		"/Event/System/Computer":                 "HOST",
+		"/Event/System/Security/UserID":          "S-1-5-18",
+		"/Event/EventData/Data":                  "first",
+		"/Event/EventData/Data1":                 "",
+		"/Event/EventData/Data2":                 "third",
+		"/Event/EventData/Binary":                "DEADBEEF",
+	} {
+		p := evtx.Path(path)
+		if got, err := e.Get(&p); err != nil || *got != want {
+			t.Errorf("%s: got %v (%v), expected %q", path, got, err, want)
+		}
+	}
+	p := evtx.Path("/Event/System/TimeCreated/SystemTime")
+	if got, err := e.Get(&p); err != nil || !time.Time((*got).(evtx.UTCTime)).Equal(fxGenerated.Add(42*time.Second)) {
+		t.Errorf("unexpected time created %v (%v)", got, err)
+	}
+
+	// no SID, no strings and no data
+	r, err = ParseRecord(fxRecord{1, 1, nil, nil, nil}.encode(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = r.GoEvtxMap()
+	for _, path := range []string{"/Event/System/Qualifiers", "/Event/System/Security/UserID", "/Event/EventData/Data", "/Event/EventData/Binary"} {
+		p := evtx.Path(path)
+		if got, err := e.Get(&p); err == nil {
+			t.Errorf("%s: unexpected %v", path, *got)
+		}
+	}
+}
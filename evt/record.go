@@ -0,0 +1,240 @@
+package evt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"rawsec-evtx/encoding"
+	"rawsec-evtx/evtx"
+)
+
+const (
+	EventTypeError        = 0x0001
+	EventTypeWarning      = 0x0002
+	EventTypeInformation  = 0x0004
+	EventTypeAuditSuccess = 0x0008
+	EventTypeAuditFailure = 0x0010
+)
+
+type RecordHeader struct {
+	Length              uint32
+	Signature           [4]byte
+	RecordNumber        uint32
+	TimeGenerated       uint32
+	TimeWritten         uint32
+	EventID             uint32
+	EventType           uint16
+	NumStrings          uint16
+	EventCategory       uint16
+	ReservedFlags       uint16
+	ClosingRecordNumber uint32
+	StringOffset        uint32
+	UserSidLength       uint32
+	UserSidOffset       uint32
+	DataLength          uint32
+	DataOffset          uint32
+}
+
+type Record struct {
+	Offset     int64
+	Header     RecordHeader
+	SourceName string
+	Computer   string
+	UserSID    string
+	Strings    []string
+	Data       []byte
+}
+
+func ParseRecord(b []byte) (*Record, error) {
+	r := &Record{}
+	if err := encoding.Unmarshal(bytes.NewReader(b), &r.Header, Endianness); err != nil {
+		return nil, err
+	}
+	h := &r.Header
+	if string(h.Signature[:]) != Magic || h.Length < RecordHeaderLen+4 || int(h.Length) > len(b) {
+		return nil, ErrBadRecord
+	}
+	b = b[:h.Length]
+	if Endianness.Uint32(b[len(b)-4:]) != h.Length {
+		return nil, ErrBadRecord
+	}
+
+	var n int
+	body := b[:len(b)-4]
+	r.SourceName, n = utf16z(body[RecordHeaderLen:])
+	r.Computer, _ = utf16z(body[RecordHeaderLen+n:])
+
+	if h.UserSidLength > 0 {
+		if !inside(body, h.UserSidOffset, h.UserSidLength) {
+			return nil, ErrBadRecord
+		}
+		r.UserSID = sidString(body[h.UserSidOffset : h.UserSidOffset+h.UserSidLength])
+	}
+
+	if h.NumStrings > 0 {
+		if !inside(body, h.StringOffset, 0) {
+			return nil, ErrBadRecord
+		}
+		s := body[h.StringOffset:]
+		if h.DataLength > 0 && h.DataOffset > h.StringOffset && int(h.DataOffset) <= len(body) {
+			s = body[h.StringOffset:h.DataOffset]
+		}
+		r.Strings = make([]string, 0, h.NumStrings)
+		for i := 0; i < int(h.NumStrings) && len(s) > 0; i++ {
+			str, n := utf16z(s)
+			r.Strings = append(r.Strings, str)
+			s = s[n:]
+		}
+	}
+
+	if h.DataLength > 0 {
+		if !inside(body, h.DataOffset, h.DataLength) {
+			return nil, ErrBadRecord
+		}
+		r.Data = body[h.DataOffset : h.DataOffset+h.DataLength]
+	}
+	return r, nil
+}
+
+func inside(b []byte, offset, length uint32) bool {
+	return offset >= RecordHeaderLen && uint64(offset)+uint64(length) <= uint64(len(b))
+}
+
+// utf16z decodes a NUL terminated UTF-16 string and returns the number of
+// bytes consumed, terminator included
+func utf16z(b []byte) (string, int) {
+	u := make([]uint16, 0, len(b)/2)
+	i := 0
+	for ; i+1 < len(b); i += 2 {
+		c := Endianness.Uint16(b[i:])
+		if c == 0 {
+			return string(utf16.Decode(u)), i + 2
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u)), i
+}
+
+func sidString(b []byte) string {
+	if len(b) < 8 {
+		return ""
+	}
+	count := int(b[1])
+	if len(b) < 8+4*count {
+		return ""
+	}
+	authority := uint64(0)
+	for _, a := range b[2:8] {
+		authority = authority<<8 | uint64(a)
+	}
+	s := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 0; i < count; i++ {
+		s += fmt.Sprintf("-%d", Endianness.Uint32(b[8+4*i:]))
+	}
+	return s
+}
+
+func (r *Record) TimeGenerated() evtx.UTCTime {
+	return evtx.UTCTime(time.Unix(int64(r.Header.TimeGenerated), 0))
+}
+
+func (r *Record) TimeWritten() evtx.UTCTime {
+	return evtx.UTCTime(time.Unix(int64(r.Header.TimeWritten), 0))
+}
+
+func (r *Record) Level() uint8 {
+	switch r.Header.EventType {
+	case EventTypeError:
+		return 2
+	case EventTypeWarning:
+		return 3
+	case EventTypeInformation:
+		return 4
+	}
+	return 0
+}
+
+func (r *Record) Keywords() uint64 {
+	switch r.Header.EventType {
+	case EventTypeAuditSuccess:
+		return 0x8020000000000000
+	case EventTypeAuditFailure:
+		return 0x8010000000000000
+	}
+	return 0x80000000000000
+}
+
+func (r *Record) Metadata() evtx.RecordMetadata {
+	return evtx.RecordMetadata{
+		ID:          int64(r.Header.RecordNumber),
+		Timestamp:   r.TimeWritten(),
+		ChunkIndex:  -1,
+		ChunkOffset: -1,
+		Offset:      r.Offset,
+		Size:        int32(r.Header.Length),
+	}
+}
+
+// System returns the System element of the record as typed by the evtx
+// package, the qualifiers being the high bits of the event ID
+func (r *Record) System() *evtx.EventSystem {
+	return &evtx.EventSystem{
+		Provider:      evtx.EventProvider{Name: r.SourceName, EventSourceName: r.SourceName},
+		EventID:       uint16(r.Header.EventID),
+		Qualifiers:    uint16(r.Header.EventID >> 16),
+		Level:         r.Level(),
+		Task:          r.Header.EventCategory,
+		Keywords:      r.Keywords(),
+		TimeCreated:   time.Time(r.TimeGenerated()),
+		EventRecordID: uint64(r.Header.RecordNumber),
+		Computer:      r.Computer,
+		Security:      evtx.EventSecurity{UserID: r.UserSID},
+	}
+}
+
+// GoEvtxMap lays the record out the way the evtx package does for classic
+// events forwarded to a Vista+ log
+func (r *Record) GoEvtxMap() *evtx.GoEvtxMap {
+	system := evtx.GoEvtxMap{
+		"Provider": evtx.GoEvtxMap{
+			"Name":            r.SourceName,
+			"EventSourceName": r.SourceName,
+		},
+		"EventID":       fmt.Sprintf("%d", r.Header.EventID&0xffff),
+		"Level":         fmt.Sprintf("%d", r.Level()),
+		"Task":          fmt.Sprintf("%d", r.Header.EventCategory),
+		"Keywords":      fmt.Sprintf("0x%x", r.Keywords()),
+		"TimeCreated":   evtx.GoEvtxMap{"SystemTime": r.TimeGenerated()},
+		"EventRecordID": fmt.Sprintf("%d", r.Header.RecordNumber),
+		"Computer":      r.Computer,
+		"Security":      evtx.GoEvtxMap{},
+	}
+	if q := r.Header.EventID >> 16; q != 0 {
+		system["Qualifiers"] = fmt.Sprintf("%d", q)
+	}
+	if r.UserSID != "" {
+		system["Security"] = evtx.GoEvtxMap{"UserID": r.UserSID}
+	}
+
+	data := evtx.GoEvtxMap{}
+	for i, s := range r.Strings {
+		name := "Data"
+		if i > 0 {
+			name = fmt.Sprintf("Data%d", i)
+		}
+		data[name] = s
+	}
+	if len(r.Data) > 0 {
+		data["Binary"] = strings.ToUpper(fmt.Sprintf("%x", r.Data))
+	}
+
+	return &evtx.GoEvtxMap{
+		"Event": evtx.GoEvtxMap{
+			"System":    system,
+			"EventData": data,
+		},
+	}
+}
@@ -0,0 +1,425 @@
+// Package audit looks for the traces of log tampering in EVTX files: record
+// IDs missing or duplicated, timestamps going backwards, chunks overlapping or
+// disagreeing with the file header, checksums not matching and the events
+// telling that a log was cleared.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"rawsec-evtx/evtx"
+	"rawsec-evtx/evtx/filter"
+)
+
+type Kind string
+
+const (
+	// record IDs missing between two records or before the next record ID of
+	// the file header
+	RecordGap Kind = "record_gap"
+	// several records having the same ID
+	DuplicateRecord Kind = "duplicate_record"
+	// record written before the record preceding it by ID
+	TimeBackwards Kind = "time_backwards"
+	// chunks whose record ID ranges overlap
+	ChunkOverlap Kind = "chunk_overlap"
+	// file header disagreeing with the chunks
+	HeaderMismatch Kind = "header_mismatch"
+	// file header flagged dirty, the file was not closed properly
+	DirtyFile Kind = "dirty_file"
+	// chunk header disagreeing with itself or with its records
+	ChunkMismatch Kind = "chunk_mismatch"
+	// record ID of the event different from the one of its record
+	RecordMismatch Kind = "record_mismatch"
+	// checksum of the file header or of a chunk not matching its data
+	CheckSumMismatch Kind = "checksum_mismatch"
+	// event 1102 or 104 of the Eventlog provider
+	LogCleared Kind = "log_cleared"
+)
+
+type Finding struct {
+	Kind    Kind
+	Message string
+	// chunk concerned, no chunk offset for the file header
+	ChunkIndex  int   `json:",omitempty"`
+	ChunkOffset int64 `json:",omitempty"`
+	// record concerned
+	RecordID int64 `json:",omitempty"`
+	Offset   int64 `json:",omitempty"`
+	// range of record IDs concerned
+	First int64 `json:",omitempty"`
+	Last  int64 `json:",omitempty"`
+	// event of log clearing
+	Event *evtx.GoEvtxMap `json:",omitempty"`
+}
+
+type Summary struct {
+	// chunks of the file header and found beyond
+	HeaderChunks int
+	Chunks       int
+	Records      int
+	// records which could not be decoded, or their System element
+	Errors         int
+	FirstRecordID  int64
+	LastRecordID   int64
+	NextRecordID   uint64
+	FirstTimestamp time.Time
+	LastTimestamp  time.Time
+}
+
+type Report struct {
+	Summary
+	Findings []Finding
+}
+
+// Count returns the number of findings of a kind
+func (r *Report) Count(kind Kind) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+type Options struct {
+	// backward jumps of the record timestamps tolerated
+	TimeTolerance time.Duration
+}
+
+// chunk is what the audit keeps of a chunk header
+type chunk struct {
+	index       int
+	offset      int64
+	first, last int64
+}
+
+// record is what the audit keeps of a record
+type record struct {
+	id          int64
+	timestamp   time.Time
+	chunkIndex  int
+	chunkOffset int64
+	offset      int64
+}
+
+type auditor struct {
+	ef     *evtx.File
+	opts   Options
+	report Report
+	chunks []chunk
+	// chunks by offset, those of the file header only
+	ranges  map[int64]chunk
+	records []record
+	// records found out of the ID range of their chunk, by chunk offset
+	outOfRange map[int64]int
+	// records holding another event record ID, by chunk offset
+	mismatches map[int64]*mismatch
+}
+
+// mismatch counts the records of a chunk holding another event record ID and
+// keeps the one with the lowest ID as an example
+type mismatch struct {
+	count    int
+	id       int64
+	offset   int64
+	recordID uint64
+}
+
+// Run audits a file, it reads the chunks beyond those of the file header
+// but only decodes the records of the latter. The file header is the one on
+// disk, a dirty file must not be repaired before.
+func Run(ctx context.Context, ef *evtx.File, opts Options) (*Report, error) {
+	a := &auditor{
+		ef:         ef,
+		opts:       opts,
+		ranges:     make(map[int64]chunk),
+		outOfRange: make(map[int64]int),
+		mismatches: make(map[int64]*mismatch),
+	}
+	a.report.HeaderChunks = int(ef.Header.ChunkCount)
+	a.report.NextRecordID = ef.Header.NextRecordID
+	if err := a.auditChunks(ctx); err != nil {
+		return nil, err
+	}
+	a.auditHeader()
+	a.auditOverlaps()
+	if err := a.auditRecords(ctx); err != nil {
+		return nil, err
+	}
+	return &a.report, nil
+}
+
+func (a *auditor) add(f Finding) {
+	a.report.Findings = append(a.report.Findings, f)
+}
+
+func (a *auditor) auditChunks(ctx context.Context) error {
+	header := int(a.ef.Header.ChunkCount)
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		offset := int64(a.ef.Header.ChunkDataOffset) + int64(evtx.ChunkSize)*int64(i)
+		c, err := a.ef.FetchChunk(offset)
+		beyond := i >= header
+		switch {
+		case beyond && err != nil:
+			return nil
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			a.add(Finding{Kind: HeaderMismatch, ChunkIndex: i, ChunkOffset: offset,
+				Message: fmt.Sprintf("file ends at chunk %d of the %d chunks of the file header", i, header)})
+			return nil
+		case c.Header.Verify() != nil:
+			// unused space is expected after the chunks of the header
+			if !beyond {
+				a.add(Finding{Kind: ChunkMismatch, ChunkIndex: i, ChunkOffset: offset,
+					Message: fmt.Sprintf("chunk %d has no chunk signature", i)})
+			}
+			continue
+		case err != nil && !evtx.IsCheckSumError(err):
+			a.add(Finding{Kind: ChunkMismatch, ChunkIndex: i, ChunkOffset: offset, Message: fmt.Sprintf("chunk %d: %s", i, err)})
+		}
+
+		ch := chunk{i, offset, c.Header.FirstEventRecID, c.Header.LastEventRecID}
+		a.chunks = append(a.chunks, ch)
+		a.report.Chunks++
+		if !beyond {
+			a.ranges[offset] = ch
+		} else {
+			a.add(Finding{Kind: HeaderMismatch, ChunkIndex: i, ChunkOffset: offset, First: ch.first, Last: ch.last,
+				Message: fmt.Sprintf("chunk %d with records %d-%d is beyond the %d chunks of the file header", i, ch.first, ch.last, header)})
+		}
+		if err := c.VerifyHeaderCheckSum(); err != nil {
+			a.add(Finding{Kind: CheckSumMismatch, ChunkIndex: i, ChunkOffset: offset, Message: err.Error()})
+		} else if err := c.VerifyDataCheckSum(); err != nil {
+			a.add(Finding{Kind: CheckSumMismatch, ChunkIndex: i, ChunkOffset: offset, Message: err.Error()})
+		}
+		h := c.Header
+		switch {
+		case h.FirstEventRecID > h.LastEventRecID:
+			a.add(Finding{Kind: ChunkMismatch, ChunkIndex: i, ChunkOffset: offset, First: ch.first, Last: ch.last,
+				Message: fmt.Sprintf("chunk %d first record ID %d is after its last one %d", i, ch.first, ch.last)})
+		case h.NumFirstRecLog != h.FirstEventRecID || h.NumLastRecLog != h.LastEventRecID:
+			a.add(Finding{Kind: ChunkMismatch, ChunkIndex: i, ChunkOffset: offset, First: ch.first, Last: ch.last,
+				Message: fmt.Sprintf("chunk %d record numbers %d-%d differ from its record IDs %d-%d",
+					i, h.NumFirstRecLog, h.NumLastRecLog, ch.first, ch.last)})
+		}
+	}
+}
+
+func (a *auditor) auditHeader() {
+	h := a.ef.Header
+	if err := h.VerifyCheckSum(); err != nil {
+		a.add(Finding{Kind: CheckSumMismatch, Message: err.Error()})
+	}
+	if h.Verify() == evtx.ErrDirtyFile {
+		a.add(Finding{Kind: DirtyFile, Message: "file header is flagged dirty, the file was not closed properly"})
+	}
+
+	var lowest, highest *chunk
+	for i := range a.chunks {
+		c := &a.chunks[i]
+		if c.index >= int(h.ChunkCount) || c.first > c.last {
+			continue
+		}
+		if lowest == nil || c.first < lowest.first {
+			lowest = c
+		}
+		if highest == nil || c.last > highest.last {
+			highest = c
+		}
+	}
+	if highest == nil {
+		return
+	}
+	if h.NextRecordID != uint64(highest.last+1) {
+		a.add(Finding{Kind: HeaderMismatch,
+			Message: fmt.Sprintf("file header next record ID is %d but the last record of the chunks is %d", h.NextRecordID, highest.last)})
+	}
+	if uint64(lowest.index) != h.FirstChunkNum {
+		a.add(Finding{Kind: HeaderMismatch, ChunkIndex: lowest.index, ChunkOffset: lowest.offset,
+			Message: fmt.Sprintf("file header first chunk is %d but the oldest records are in chunk %d", h.FirstChunkNum, lowest.index)})
+	}
+	if uint64(highest.index) != h.LastChunkNum {
+		a.add(Finding{Kind: HeaderMismatch, ChunkIndex: highest.index, ChunkOffset: highest.offset,
+			Message: fmt.Sprintf("file header last chunk is %d but the newest records are in chunk %d", h.LastChunkNum, highest.index)})
+	}
+}
+
+func (a *auditor) auditOverlaps() {
+	chunks := make([]chunk, 0, len(a.chunks))
+	for _, c := range a.chunks {
+		if c.first <= c.last {
+			chunks = append(chunks, c)
+		}
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].first < chunks[j].first
+	})
+	// the chunks whose range is not over yet, by last record: those ending
+	// before the first record of a chunk cannot overlap the following ones
+	var open []chunk
+	for _, c := range chunks {
+		over := sort.Search(len(open), func(i int) bool { return open[i].last >= c.first })
+		open = open[over:]
+		// compared to all the chunks overlapping, not only the previous one
+		for _, p := range open {
+			last := c.last
+			if p.last < last {
+				last = p.last
+			}
+			a.add(Finding{Kind: ChunkOverlap, ChunkIndex: c.index, ChunkOffset: c.offset, First: c.first, Last: last,
+				Message: fmt.Sprintf("records %d-%d of chunk %d are also in the range of chunk %d", c.first, last, c.index, p.index)})
+		}
+		i := sort.Search(len(open), func(i int) bool { return open[i].last > c.last })
+		open = append(open, chunk{})
+		copy(open[i+1:], open[i:])
+		open[i] = c
+	}
+}
+
+var (
+	clearedUser    = filter.NewField("UserData/LogFileCleared/SubjectUserName")
+	clearedDomain  = filter.NewField("UserData/LogFileCleared/SubjectDomainName")
+	clearedChannel = filter.NewField("UserData/LogFileCleared/Channel")
+)
+
+// logCleared returns the message of the events logged when a log is cleared
+func logCleared(r *evtx.Record) (string, bool) {
+	if r.System == nil || !strings.EqualFold(r.System.Provider.Name, "Microsoft-Windows-Eventlog") {
+		return "", false
+	}
+	var channel string
+	switch r.System.EventID {
+	case 1102:
+		channel = "Security"
+	case 104:
+		channel = "System"
+		if values, ok := clearedChannel.Values(r.Event); ok && len(values) > 0 {
+			channel = values[0]
+		}
+	default:
+		return "", false
+	}
+	msg := fmt.Sprintf("%s log cleared", channel)
+	if user, ok := clearedUser.Values(r.Event); ok && len(user) > 0 {
+		if domain, ok := clearedDomain.Values(r.Event); ok && len(domain) > 0 && domain[0] != "" {
+			user[0] = domain[0] + `\` + user[0]
+		}
+		msg += " by " + user[0]
+	}
+	return msg, true
+}
+
+func (a *auditor) auditRecords(ctx context.Context) error {
+	for r := range a.ef.UnorderedRecordsContext(ctx) {
+		// records without System cannot be checked for log clearing
+		if r.Err != nil || r.SystemErr != nil {
+			a.report.Errors++
+		}
+		if r.ID < 0 {
+			continue
+		}
+		a.report.Records++
+		a.records = append(a.records, record{r.ID, time.Time(r.Timestamp), r.ChunkIndex, r.ChunkOffset, r.Offset})
+
+		if c, ok := a.ranges[r.ChunkOffset]; ok && (r.ID < c.first || r.ID > c.last) {
+			a.outOfRange[r.ChunkOffset]++
+		}
+		if r.System != nil && r.System.EventRecordID != 0 && int64(r.System.EventRecordID) != r.ID {
+			m, ok := a.mismatches[r.ChunkOffset]
+			if !ok || r.ID < m.id {
+				n := 0
+				if ok {
+					n = m.count
+				}
+				m = &mismatch{n, r.ID, r.Offset, r.System.EventRecordID}
+				a.mismatches[r.ChunkOffset] = m
+			}
+			m.count++
+		}
+		if msg, ok := logCleared(r); ok {
+			a.add(Finding{Kind: LogCleared, ChunkIndex: r.ChunkIndex, ChunkOffset: r.ChunkOffset, RecordID: r.ID, Offset: r.Offset,
+				Message: fmt.Sprintf("record %d @ %s: %s", r.ID, time.Time(r.Timestamp).UTC().Format(time.RFC3339), msg), Event: r.Event})
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range a.chunks {
+		if n := a.outOfRange[c.offset]; n > 0 {
+			a.add(Finding{Kind: ChunkMismatch, ChunkIndex: c.index, ChunkOffset: c.offset, First: c.first, Last: c.last,
+				Message: fmt.Sprintf("chunk %d has %d records out of its range %d-%d", c.index, n, c.first, c.last)})
+		}
+		if m, ok := a.mismatches[c.offset]; ok {
+			a.add(Finding{Kind: RecordMismatch, ChunkIndex: c.index, ChunkOffset: c.offset, RecordID: m.id, Offset: m.offset,
+				Message: fmt.Sprintf("chunk %d has %d records holding another event record ID, record %d holds %d", c.index, m.count, m.id, m.recordID)})
+		}
+	}
+	a.auditSequence()
+	return nil
+}
+
+// auditSequence looks at the records in the order of their IDs
+func (a *auditor) auditSequence() {
+	records := a.records
+	if len(records) == 0 {
+		return
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].id != records[j].id {
+			return records[i].id < records[j].id
+		}
+		return records[i].offset < records[j].offset
+	})
+	a.report.FirstRecordID = records[0].id
+	a.report.LastRecordID = records[len(records)-1].id
+	a.report.FirstTimestamp = records[0].timestamp
+	a.report.LastTimestamp = records[0].timestamp
+
+	for i := 1; i < len(records); i++ {
+		p, r := records[i-1], records[i]
+		if r.timestamp.After(a.report.LastTimestamp) {
+			a.report.LastTimestamp = r.timestamp
+		}
+		if r.timestamp.Before(a.report.FirstTimestamp) {
+			a.report.FirstTimestamp = r.timestamp
+		}
+		switch {
+		case r.id == p.id:
+			a.add(Finding{Kind: DuplicateRecord, ChunkIndex: r.chunkIndex, ChunkOffset: r.chunkOffset, RecordID: r.id, Offset: r.offset,
+				Message: fmt.Sprintf("record %d @ 0x%x is also @ 0x%x", r.id, r.offset, p.offset)})
+			continue
+		case r.id > p.id+1:
+			a.add(Finding{Kind: RecordGap, ChunkIndex: r.chunkIndex, ChunkOffset: r.chunkOffset, First: p.id + 1, Last: r.id - 1,
+				Message: gapMessage(p.id+1, r.id-1)})
+		}
+		if p.timestamp.Sub(r.timestamp) > a.opts.TimeTolerance {
+			a.add(Finding{Kind: TimeBackwards, ChunkIndex: r.chunkIndex, ChunkOffset: r.chunkOffset, RecordID: r.id, Offset: r.offset,
+				Message: fmt.Sprintf("record %d @ %s was written %s before record %d @ %s", r.id, r.timestamp.UTC().Format(time.RFC3339Nano),
+					p.timestamp.Sub(r.timestamp), p.id, p.timestamp.UTC().Format(time.RFC3339Nano))})
+		}
+	}
+
+	// records lost at the end of the file
+	last := records[len(records)-1].id
+	if next := int64(a.ef.Header.NextRecordID); next > last+1 {
+		a.add(Finding{Kind: RecordGap, First: last + 1, Last: next - 1,
+			Message: gapMessage(last+1, next-1) + " before the next record ID of the file header"})
+	}
+}
+
+func gapMessage(first, last int64) string {
+	if first == last {
+		return fmt.Sprintf("record %d missing", first)
+	}
+	return fmt.Sprintf("records %d-%d missing (%d)", first, last, last-first+1)
+}
@@ -0,0 +1,299 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"rawsec-evtx/encoding"
+	"rawsec-evtx/evtx"
+)
+
+var start = time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+
+func eventXML(id int64, created time.Time) string {
+	return fmt.Sprintf(`<Event><System><Provider Name="Microsoft-Windows-Security-Auditing"/><EventID>4624</EventID>`+
+		`<TimeCreated SystemTime="%s"/><EventRecordID>%d</EventRecordID><Channel>Security</Channel><Computer>HOST</Computer></System>`+
+		`<EventData><Data Name="TargetUserName">user%d</Data></EventData></Event>`, created.Format(time.RFC3339Nano), id, id)
+}
+
+func clearedXML(id int64, created time.Time) string {
+	return fmt.Sprintf(`<Event><System><Provider Name="Microsoft-Windows-Eventlog"/><EventID>1102</EventID>`+
+		`<TimeCreated SystemTime="%s"/><EventRecordID>%d</EventRecordID><Channel>Security</Channel><Computer>HOST</Computer></System>`+
+		`<UserData><LogFileCleared><SubjectUserName>admin</SubjectUserName><SubjectDomainName>CORP</SubjectDomainName></LogFileCleared></UserData></Event>`,
+		created.Format(time.RFC3339Nano), id)
+}
+
+// sequence returns the events of the record IDs first to last, a second apart
+func sequence(first, last int64) []string {
+	var events []string
+	for id := first; id <= last; id++ {
+		events = append(events, eventXML(id, start.Add(time.Duration(id)*time.Second)))
+	}
+	return events
+}
+
+func writeFile(t *testing.T, events []string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.evtx")
+	w, err := evtx.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteXML(strings.NewReader(strings.Join(events, "\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// open opens a file without repairing its header
+func open(t *testing.T, path string) *evtx.File {
+	t.Helper()
+	ef, err := evtx.Open(path)
+	if err != nil && err != evtx.ErrDirtyFile {
+		t.Fatal(err)
+	}
+	return &ef
+}
+
+// rewriteHeader modifies the file header, its checksum is recomputed unless
+// modify changes it
+func rewriteHeader(t *testing.T, path string, modify func(h *evtx.FileHeader)) {
+	t.Helper()
+	ef := open(t, path)
+	ef.Close()
+	h := ef.Header
+	modify(&h)
+	if h.CheckSum == ef.Header.CheckSum {
+		var err error
+		if h.CheckSum, err = h.ComputeCheckSum(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := encoding.Marshal(&h, evtx.Endianness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data, b)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func audit(t *testing.T, path string) *Report {
+	t.Helper()
+	ef := open(t, path)
+	defer ef.Close()
+	r, err := Run(context.Background(), ef, Options{TimeTolerance: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func checkCounts(t *testing.T, r *Report, counts map[Kind]int) {
+	t.Helper()
+	total := 0
+	for kind, n := range counts {
+		if got := r.Count(kind); got != n {
+			t.Errorf("%d %s findings, expected %d", got, kind, n)
+		}
+		total += n
+	}
+	if len(r.Findings) != total {
+		for _, f := range r.Findings {
+			t.Logf("%s: %s", f.Kind, f.Message)
+		}
+		t.Errorf("%d findings, expected %d", len(r.Findings), total)
+	}
+}
+
+func TestClean(t *testing.T) {
+	r := audit(t, writeFile(t, sequence(1, 600)))
+	checkCounts(t, r, nil)
+	if r.Chunks < 2 {
+		t.Fatalf("%d chunks, expected several", r.Chunks)
+	}
+	if r.Records != 600 || r.FirstRecordID != 1 || r.LastRecordID != 600 || r.NextRecordID != 601 {
+		t.Errorf("unexpected summary %+v", r.Summary)
+	}
+	if !r.FirstTimestamp.Equal(start.Add(time.Second)) || !r.LastTimestamp.Equal(start.Add(600*time.Second)) {
+		t.Errorf("unexpected time range %s - %s", r.FirstTimestamp, r.LastTimestamp)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	events := sequence(1, 10)
+	events = append(events, sequence(20, 30)...)
+	// written before its predecessor, within and beyond the tolerance
+	events = append(events, eventXML(31, start.Add(30*time.Second-500*time.Millisecond)))
+	events = append(events, eventXML(32, start))
+	events = append(events, clearedXML(33, start.Add(33*time.Second)))
+
+	r := audit(t, writeFile(t, events))
+	checkCounts(t, r, map[Kind]int{RecordGap: 1, TimeBackwards: 1, LogCleared: 1})
+	for _, f := range r.Findings {
+		switch f.Kind {
+		case RecordGap:
+			if f.First != 11 || f.Last != 19 {
+				t.Errorf("gap %d-%d, expected 11-19", f.First, f.Last)
+			}
+		case TimeBackwards:
+			if f.RecordID != 32 {
+				t.Errorf("record %d written backwards, expected 32", f.RecordID)
+			}
+		case LogCleared:
+			if f.RecordID != 33 || f.Event == nil || !strings.Contains(f.Message, `Security log cleared by CORP\admin`) {
+				t.Errorf("unexpected log clearing finding %+v", f)
+			}
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	chunkOffset := func(i int) int64 {
+		return evtx.DefaultChunkOffset + int64(i)*evtx.ChunkSize
+	}
+
+	t.Run("dropped chunks", func(t *testing.T) {
+		path := writeFile(t, sequence(1, 600))
+		chunks := audit(t, path).Chunks
+		// the last chunk is left beyond the header as if it was dropped
+		rewriteHeader(t, path, func(h *evtx.FileHeader) {
+			h.ChunkCount--
+			h.LastChunkNum--
+		})
+		r := audit(t, path)
+		// beyond the header, next record ID and records missing at the end
+		checkCounts(t, r, map[Kind]int{HeaderMismatch: 2, RecordGap: 1})
+		if r.Chunks != chunks || r.HeaderChunks != chunks-1 {
+			t.Errorf("%d chunks, %d in header, expected %d and %d", r.Chunks, r.HeaderChunks, chunks, chunks-1)
+		}
+	})
+
+	t.Run("dirty file", func(t *testing.T) {
+		path := writeFile(t, sequence(1, 600))
+		chunks := audit(t, path).Chunks
+		// a repair of the header would count the chunk left beyond it
+		rewriteHeader(t, path, func(h *evtx.FileHeader) {
+			h.Flags = 1
+			h.ChunkCount--
+			h.LastChunkNum--
+		})
+		r := audit(t, path)
+		checkCounts(t, r, map[Kind]int{DirtyFile: 1, HeaderMismatch: 2, RecordGap: 1})
+		if r.Chunks != chunks || r.HeaderChunks != chunks-1 {
+			t.Errorf("%d chunks, %d in header, expected %d and %d", r.Chunks, r.HeaderChunks, chunks, chunks-1)
+		}
+	})
+
+	t.Run("copied chunk", func(t *testing.T) {
+		path := writeFile(t, sequence(1, 600))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks := (int64(len(data)) - evtx.DefaultChunkOffset) / evtx.ChunkSize
+		data = append(data, data[chunkOffset(0):chunkOffset(1)]...)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		rewriteHeader(t, path, func(h *evtx.FileHeader) {
+			h.ChunkCount++
+		})
+		r := audit(t, path)
+		// every record of the first chunk is duplicated
+		ef := open(t, path)
+		defer ef.Close()
+		c, err := ef.FetchChunk(chunkOffset(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		duplicated := int(c.Header.LastEventRecID - c.Header.FirstEventRecID + 1)
+		checkCounts(t, r, map[Kind]int{DuplicateRecord: duplicated, ChunkOverlap: 1})
+		for _, f := range r.Findings {
+			if f.Kind == ChunkOverlap && f.ChunkIndex != int(chunks) {
+				t.Errorf("overlap reported on chunk %d, expected %d", f.ChunkIndex, chunks)
+			}
+		}
+	})
+
+	t.Run("modified chunk", func(t *testing.T) {
+		path := writeFile(t, sequence(1, 600))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// first digit of a user name
+		i := strings.Index(string(data[chunkOffset(1):]), "u\x00s\x00e\x00r\x00")
+		if i < 0 {
+			t.Fatal("user name not found")
+		}
+		data[chunkOffset(1)+int64(i)+8] ^= 1
+		// record ID range no longer matching the record numbers
+		data[chunkOffset(1)+8]++
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		rewriteHeader(t, path, func(h *evtx.FileHeader) {
+			h.CheckSum++
+		})
+		r := audit(t, path)
+		// the records checksum is not verified once the chunk header one fails
+		checkCounts(t, r, map[Kind]int{CheckSumMismatch: 2, ChunkMismatch: 1})
+	})
+}
+
+func TestAuditOverlaps(t *testing.T) {
+	a := &auditor{chunks: []chunk{
+		{0, 0, 1, 100},
+		{1, 1, 10, 20},
+		{2, 2, 15, 30},
+		{3, 3, 50, 60},
+		{4, 4, 101, 200},
+		{5, 5, 150, 150},
+		{6, 6, 300, 250},
+		{7, 7, 201, 300},
+		{8, 8, 199, 201},
+	}}
+	a.auditOverlaps()
+
+	// every pair of chunks whose ranges intersect
+	var want []string
+	for _, c := range a.chunks {
+		for _, p := range a.chunks {
+			if p.index != c.index && c.first <= c.last && p.first <= p.last && (p.first < c.first || p.first == c.first && p.index < c.index) && c.first <= p.last {
+				last := c.last
+				if p.last < last {
+					last = p.last
+				}
+				want = append(want, fmt.Sprintf("%d/%d %d-%d", c.index, p.index, c.first, last))
+			}
+		}
+	}
+	var got []string
+	for _, f := range a.report.Findings {
+		var p int
+		if _, err := fmt.Sscanf(f.Message[strings.LastIndex(f.Message, " ")+1:], "%d", &p); err != nil || f.Kind != ChunkOverlap {
+			t.Fatalf("unexpected finding %+v", f)
+		}
+		got = append(got, fmt.Sprintf("%d/%d %d-%d", f.ChunkIndex, p, f.First, f.Last))
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("overlaps %v, expected %v", got, want)
+	}
+}
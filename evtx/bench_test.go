@@ -0,0 +1,109 @@
+package evtx
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// fxBulkFile fills chunks with the fixture events, a Security log like mix of
+// few templates and many events
+func fxBulkFile(t testing.TB, chunks int) (data []byte, events int) {
+	t.Helper()
+	records := make([][]fxRecord, 0, chunks)
+	id := int64(1)
+	for i := 0; i < chunks; i++ {
+		cb := newChunkBuilder()
+		var chunk []fxRecord
+		for {
+			inst := fxTextInstance(t, id)
+			if id%2 == 0 {
+				inst = fxValuesInstance(t, id, id%4 == 0)
+			}
+			if !cb.record(id, fxCreated, func() { inst.encode(cb, true) }) {
+				break
+			}
+			chunk = append(chunk, fxRecord{id, fxCreated.Add(time.Duration(id) * time.Millisecond), inst})
+			id++
+		}
+		records = append(records, chunk)
+		events += len(chunk)
+	}
+	return fxFile(t, nil, records...), events
+}
+
+func benchmarkRecords(b *testing.B, render func(*Record)) {
+	data, events := fxBulkFile(b, 16)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ef, err := NewWithOptions(bytes.NewReader(data), Options{Workers: 1})
+		if err != nil {
+			b.Fatal(err)
+		}
+		n := 0
+		for r := range ef.Records() {
+			if r.Err != nil {
+				b.Fatal(r.Err)
+			}
+			if render != nil {
+				render(r)
+			}
+			n++
+		}
+		if n != events {
+			b.Fatalf("expected %d events, got %d", events, n)
+		}
+	}
+	b.ReportMetric(float64(events*b.N)/b.Elapsed().Seconds(), "events/s")
+}
+
+func BenchmarkRecords(b *testing.B) {
+	benchmarkRecords(b, nil)
+}
+
+func BenchmarkRecordsXML(b *testing.B) {
+	benchmarkRecords(b, func(r *Record) {
+		if _, err := r.XML(); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkParseChunk(b *testing.B) {
+	data, _ := fxBulkFile(b, 1)
+	chunk := data[DefaultChunkOffset : DefaultChunkOffset+ChunkSize]
+	b.SetBytes(ChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseChunk(chunk, DefaultChunkOffset, CheckSumStrict); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFragment(b *testing.B) {
+	data, _ := fxBulkFile(b, 1)
+	c, err := ParseChunk(data[DefaultChunkOffset:DefaultChunkOffset+ChunkSize], DefaultChunkOffset, CheckSumIgnore)
+	if err != nil {
+		b.Fatal(err)
+	}
+	events := make([]Event, 0, len(c.EventOffsets))
+	for _, eo := range c.EventOffsets {
+		if e, err := c.ParseEvent(int64(eo)); err == nil {
+			events = append(events, e)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range events {
+			if _, err := e.Fragment(&c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(len(events)*b.N)/b.Elapsed().Seconds(), "events/s")
+}
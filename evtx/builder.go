@@ -0,0 +1,273 @@
+package evtx
+
+import (
+	"bytes"
+	"sort"
+	"time"
+	"unicode/utf16"
+
+	"rawsec-evtx/encoding"
+)
+
+type chunkBuilder struct {
+	data      []byte
+	pos       int
+	overflow  bool
+	names     map[string]int32
+	nameList  []int32
+	templates map[GUID]int32
+	tmplList  []int32
+	first     int64
+	last      int64
+	lastRec   int32
+	count     int
+}
+
+func newChunkBuilder() *chunkBuilder {
+	return &chunkBuilder{
+		data:      make([]byte, ChunkSize),
+		pos:       chunkTablesEnd,
+		names:     make(map[string]int32),
+		templates: make(map[GUID]int32),
+	}
+}
+
+func (cb *chunkBuilder) put(b ...byte) {
+	if cb.overflow || cb.pos+len(b) > len(cb.data) {
+		cb.overflow = true
+		return
+	}
+	copy(cb.data[cb.pos:], b)
+	cb.pos += len(b)
+}
+
+func (cb *chunkBuilder) putUint16(v uint16) {
+	cb.put(byte(v), byte(v>>8))
+}
+
+func (cb *chunkBuilder) putUint32(v uint32) {
+	cb.put(byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (cb *chunkBuilder) putUint64(v uint64) {
+	cb.putUint32(uint32(v))
+	cb.putUint32(uint32(v >> 32))
+}
+
+// reserve skips a 32 bits field to be patched once its value is known
+func (cb *chunkBuilder) reserve() int {
+	pos := cb.pos
+	cb.putUint32(0)
+	return pos
+}
+
+func (cb *chunkBuilder) patch(pos int, v uint32) {
+	if !cb.overflow {
+		Endianness.PutUint32(cb.data[pos:], v)
+	}
+}
+
+func (cb *chunkBuilder) addRecord(id int64, created time.Time, t *template) bool {
+	return cb.record(id, created, func() { cb.templateInstance(t) })
+}
+
+// record frames the BinXML written by body into an event record
+func (cb *chunkBuilder) record(id int64, created time.Time, body func()) bool {
+	pos, nNames, nTmpls := cb.pos, len(cb.nameList), len(cb.tmplList)
+
+	cb.put([]byte(EventMagic)...)
+	sizePos := cb.reserve()
+	cb.putUint64(uint64(id))
+	cb.putUint64(uint64(created.UnixNano()/100 + fileTimeEpochOffset))
+	cb.put(FragmentHeaderToken, 1, 1, 0)
+	body()
+	cb.put(TokenEOF)
+	size := uint32(cb.pos + 4 - pos)
+	cb.putUint32(size)
+	cb.patch(sizePos, size)
+
+	if cb.overflow {
+		// roll back what the record added to the chunk
+		for _, off := range cb.nameList[nNames:] {
+			for name, o := range cb.names {
+				if o == off {
+					delete(cb.names, name)
+				}
+			}
+		}
+		for _, off := range cb.tmplList[nTmpls:] {
+			for guid, o := range cb.templates {
+				if o == off {
+					delete(cb.templates, guid)
+				}
+			}
+		}
+		cb.nameList, cb.tmplList = cb.nameList[:nNames], cb.tmplList[:nTmpls]
+		for i := pos; i < len(cb.data); i++ {
+			cb.data[i] = 0
+		}
+		cb.pos, cb.overflow = pos, false
+		return false
+	}
+
+	if cb.count == 0 {
+		cb.first = id
+	}
+	cb.last = id
+	cb.lastRec = int32(pos)
+	cb.count++
+	return true
+}
+
+func (cb *chunkBuilder) templateInstance(t *template) {
+	cb.put(TokenTemplateInstance, 1)
+	cb.put(t.guid[:4]...)
+	if offset, ok := cb.templates[t.guid]; ok {
+		cb.putUint32(uint32(offset))
+	} else {
+		offset := int32(cb.pos + 4)
+		cb.putUint32(uint32(offset))
+		cb.templates[t.guid] = offset
+		cb.tmplList = append(cb.tmplList, offset)
+		// next template in the bucket, set on finalize
+		cb.putUint32(0)
+		cb.put(t.guid[:]...)
+		sizePos := cb.reserve()
+		start := cb.pos
+		cb.put(FragmentHeaderToken, 1, 1, 0)
+		cb.element(t, t.root)
+		cb.put(TokenEOF)
+		cb.patch(sizePos, uint32(cb.pos-start))
+	}
+
+	cb.putUint32(uint32(len(t.values)))
+	for _, v := range t.values {
+		cb.putUint16(uint16(len(v.data)))
+		cb.put(uint8(v.typ), 0)
+	}
+	for _, v := range t.values {
+		cb.put(v.data...)
+	}
+}
+
+func (cb *chunkBuilder) element(t *template, n *xmlNode) {
+	token := byte(TokenOpenStartElementTag1)
+	if len(n.attrs) > 0 {
+		token = TokenOpenStartElementTag2
+	}
+	cb.put(token)
+	cb.putUint16(0xffff)
+	sizePos := cb.reserve()
+	cb.name(n.name)
+
+	if len(n.attrs) > 0 {
+		listPos := cb.reserve()
+		start := cb.pos
+		for i, attr := range n.attrs {
+			if i == len(n.attrs)-1 {
+				cb.put(TokenAttribute1)
+			} else {
+				cb.put(TokenAttribute2)
+			}
+			cb.name(xmlAttrName(attr))
+			cb.substitution(t, t.attrSub[n][i])
+		}
+		cb.patch(listPos, uint32(cb.pos-start))
+	}
+
+	if n.text == "" && len(n.children) == 0 {
+		cb.put(TokenCloseEmptyElementTag)
+	} else {
+		cb.put(TokenCloseStartElementTag)
+		if n.text != "" {
+			cb.substitution(t, t.textSub[n])
+		}
+		for _, c := range n.children {
+			cb.element(t, c)
+		}
+		cb.put(TokenEndElementTag)
+	}
+	cb.patch(sizePos, uint32(cb.pos-sizePos-4))
+}
+
+func (cb *chunkBuilder) substitution(t *template, id int) {
+	cb.put(TokenOptionalSubstitution)
+	cb.putUint16(uint16(id))
+	cb.put(uint8(t.values[id].typ))
+}
+
+func (cb *chunkBuilder) name(name string) {
+	if offset, ok := cb.names[name]; ok {
+		cb.putUint32(uint32(offset))
+		return
+	}
+	offset := int32(cb.pos + 4)
+	cb.putUint32(uint32(offset))
+	cb.names[name] = offset
+	cb.nameList = append(cb.nameList, offset)
+	u := utf16.Encode([]rune(name))
+	// previous string in the bucket, set on finalize
+	cb.putUint32(0)
+	cb.putUint16(nameHash(u))
+	cb.putUint16(uint16(len(u)))
+	for _, c := range u {
+		cb.putUint16(c)
+	}
+	cb.putUint16(0)
+}
+
+func nameHash(u []uint16) uint16 {
+	h := uint32(0)
+	for _, c := range u {
+		h = h*65599 + uint32(c)
+	}
+	return uint16(h)
+}
+
+func (cb *chunkBuilder) finalize() ([]byte, error) {
+	sort.Slice(cb.nameList, func(i, j int) bool { return cb.nameList[i] < cb.nameList[j] })
+	for _, off := range cb.nameList {
+		bucket := ChunkHeaderSize + 4*int(Endianness.Uint16(cb.data[off+4:])%sizeStringBucket)
+		copy(cb.data[off:], cb.data[bucket:bucket+4])
+		Endianness.PutUint32(cb.data[bucket:], uint32(off))
+	}
+	for _, off := range cb.tmplList {
+		bucket := ChunkHeaderSize + 4*sizeStringBucket + 4*int(Endianness.Uint32(cb.data[off-4:])%sizeTemplateBucket)
+		copy(cb.data[off:], cb.data[bucket:bucket+4])
+		Endianness.PutUint32(cb.data[bucket:], uint32(off))
+	}
+
+	c := Chunk{Data: cb.data}
+	copy(c.Header.Magic[:], ChunkMagic)
+	c.Header.NumFirstRecLog = cb.first
+	c.Header.NumLastRecLog = cb.last
+	c.Header.FirstEventRecID = cb.first
+	c.Header.LastEventRecID = cb.last
+	c.Header.SizeHeader = ChunkHeaderSize
+	c.Header.OffsetLastRec = cb.lastRec
+	c.Header.Freespace = int32(cb.pos)
+
+	var err error
+	if c.Header.CheckSum, err = c.ComputeDataCheckSum(); err != nil {
+		return nil, err
+	}
+	if err = cb.writeHeader(&c.Header); err != nil {
+		return nil, err
+	}
+	if c.Header.HeaderCheckSum, err = c.ComputeHeaderCheckSum(); err != nil {
+		return nil, err
+	}
+	return cb.data, cb.writeHeader(&c.Header)
+}
+
+func (cb *chunkBuilder) writeHeader(h *ChunkHeader) error {
+	b, err := encoding.Marshal(h, Endianness)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b[:len(ChunkMagic)], []byte(ChunkMagic)) || len(b) != ChunkHeaderSize {
+		return ErrBadChunkMagic
+	}
+	copy(cb.data, b)
+	return nil
+}
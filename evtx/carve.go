@@ -0,0 +1,234 @@
+package evtx
+
+import (
+	"bytes"
+	"io"
+	"sort"
+
+	"rawsec-evtx/encoding"
+)
+
+const (
+	carveWindowSize = 4 << 20
+	// offset of the template definition offset in a record starting with
+	// a template instance: event header, fragment header, token, unknown
+	// byte and template ID
+	recordTemplateDataOffset = EventHeaderSize + 4 + 1 + 1 + 4
+	// offset of an inline template definition in such a record
+	recordInlineTemplateOffset = recordTemplateDataOffset + 4
+	// next template offset, GUID and size preceding the template fragment
+	templateDefinitionHeaderSize = 4 + 16 + 4
+)
+
+var (
+	minCarvedTimestamp = FileTime{116444736000000000}
+	maxCarvedTimestamp = FileTime{159495840000000000}
+)
+
+type CarvedChunk struct {
+	Offset int64
+	Chunk  Chunk
+}
+
+type CarvedEvent struct {
+	Offset      int64
+	ChunkOffset int64
+	Header      EventHeader
+	Event       *GoEvtxMap
+	Recovered   bool
+	Err         error
+}
+
+type Carver struct {
+	CheckSumMode CheckSumMode
+	r            io.ReaderAt
+	size         int64
+}
+
+func NewCarver(r io.ReaderAt, size int64) *Carver {
+	return &Carver{CheckSumMode: CheckSumLenient, r: r, size: size}
+}
+
+func (cv *Carver) scan(magic []byte, found func(offset int64) bool) {
+	buf := make([]byte, carveWindowSize+len(magic)-1)
+	for base := int64(0); base < cv.size; base += carveWindowSize {
+		n, err := cv.r.ReadAt(buf, base)
+		if n <= 0 && err != nil {
+			return
+		}
+		for i := 0; i+len(magic) <= n; {
+			j := bytes.Index(buf[i:n], magic)
+			if j < 0 || i+j >= carveWindowSize {
+				break
+			}
+			if !found(base + int64(i+j)) {
+				return
+			}
+			i += j + 1
+		}
+	}
+}
+
+func (cv *Carver) read(offset int64, size int) []byte {
+	data := make([]byte, size)
+	n, _ := cv.r.ReadAt(data, offset)
+	return data[:n]
+}
+
+func (cv *Carver) CarveChunk(offset int64) (Chunk, error) {
+	data := cv.read(offset, ChunkSize)
+	if len(data) < chunkTablesEnd {
+		return Chunk{}, io.ErrUnexpectedEOF
+	}
+	if len(data) < ChunkSize {
+		// truncated chunk at the end of the image
+		data = append(data, make([]byte, ChunkSize-len(data))...)
+	}
+
+	c, err := ParseChunk(data, offset, CheckSumIgnore)
+	if err != nil {
+		return c, err
+	}
+	if err = c.Header.Verify(); err != nil {
+		return c, err
+	}
+	if c.Header.SizeHeader != ChunkHeaderSize ||
+		c.Header.OffsetLastRec < chunkTablesEnd || c.Header.OffsetLastRec >= ChunkSize ||
+		c.Header.FirstEventRecID > c.Header.LastEventRecID {
+		return c, ErrCorruptedHeader
+	}
+	if cv.CheckSumMode != CheckSumIgnore {
+		if err = c.VerifyCheckSums(); err != nil {
+			if cv.CheckSumMode == CheckSumStrict {
+				return c, err
+			}
+			c.CheckSumErr = err
+		}
+	}
+	return c, nil
+}
+
+func (cv *Carver) Chunks() (ccc chan CarvedChunk) {
+	ccc = make(chan CarvedChunk)
+	go func() {
+		defer close(ccc)
+		cv.scan([]byte(ChunkMagic), func(offset int64) bool {
+			if c, err := cv.CarveChunk(offset); err == nil {
+				ccc <- CarvedChunk{offset, c}
+			}
+			return true
+		})
+	}()
+	return
+}
+
+func (cv *Carver) CarveRecord(offset int64) (ce CarvedEvent, err error) {
+	ce.Offset = offset
+	ce.ChunkOffset = -1
+
+	data := cv.read(offset, EventHeaderSize)
+	if len(data) < EventHeaderSize {
+		return ce, io.ErrUnexpectedEOF
+	}
+	if err = encoding.Unmarshal(bytes.NewReader(data), &ce.Header, Endianness); err != nil {
+		return
+	}
+	if err = ce.Header.Validate(); err != nil {
+		return
+	}
+	if ce.Header.ID <= 0 ||
+		ce.Header.Timestamp.Nanoseconds < minCarvedTimestamp.Nanoseconds ||
+		ce.Header.Timestamp.Nanoseconds > maxCarvedTimestamp.Nanoseconds {
+		return ce, ErrInvalidEvent
+	}
+
+	record := cv.read(offset, int(ce.Header.Size))
+	if len(record) != int(ce.Header.Size) {
+		return ce, io.ErrUnexpectedEOF
+	}
+	// records end with a copy of their size
+	if int32(Endianness.Uint32(record[len(record)-4:])) != ce.Header.Size {
+		return ce, ErrInvalidEvent
+	}
+
+	// names and templates are referenced by their offset within the chunk
+	// so a record can only be decoded on its own if it carries its
+	// template, in which case that offset tells where the record was
+	if len(record) < recordInlineTemplateOffset+templateDefinitionHeaderSize+1 ||
+		record[EventHeaderSize] != FragmentHeaderToken ||
+		record[EventHeaderSize+4] != TokenTemplateInstance ||
+		record[recordInlineTemplateOffset+templateDefinitionHeaderSize] != FragmentHeaderToken {
+		return
+	}
+	chunkOffset := int64(int32(Endianness.Uint32(record[recordTemplateDataOffset:]))) - recordInlineTemplateOffset
+	if chunkOffset < chunkTablesEnd || chunkOffset+int64(len(record)) > ChunkSize {
+		return
+	}
+
+	c := NewChunk()
+	c.Data = make([]byte, ChunkSize)
+	copy(c.Data[chunkOffset:], record)
+	c.Header.OffsetLastRec = int32(chunkOffset)
+	e, err := c.ParseEvent(chunkOffset)
+	if err != nil {
+		ce.Err = err
+		return ce, nil
+	}
+	ce.Event, ce.Err = e.GoEvtxMap(&c)
+	return ce, nil
+}
+
+func (cv *Carver) Records() (cce chan CarvedEvent) {
+	cce = make(chan CarvedEvent)
+	go func() {
+		defer close(cce)
+		cv.scan([]byte(EventMagic), func(offset int64) bool {
+			if ce, err := cv.CarveRecord(offset); err == nil {
+				cce <- ce
+			}
+			return true
+		})
+	}()
+	return
+}
+
+func (cv *Carver) Events() (cce chan CarvedEvent) {
+	cce = make(chan CarvedEvent)
+	go func() {
+		defer close(cce)
+
+		carved := make([]int64, 0)
+		for cc := range cv.Chunks() {
+			carved = append(carved, cc.Offset)
+			c := cc.Chunk
+			for _, eo := range c.EventOffsets {
+				if int64(eo) > int64(c.Header.OffsetLastRec) {
+					continue
+				}
+				ce := CarvedEvent{Offset: cc.Offset + int64(eo), ChunkOffset: cc.Offset}
+				if e, err := c.ParseEvent(int64(eo)); err != nil {
+					ce.Err = err
+				} else {
+					ce.Header = e.Header
+					ce.Event, ce.Err = e.GoEvtxMap(&c)
+				}
+				if ce.Event == nil && ce.Header.Validate() != nil {
+					continue
+				}
+				cce <- ce
+			}
+		}
+
+		// records found outside of the carved chunks
+		inCarvedChunk := func(offset int64) bool {
+			i := sort.Search(len(carved), func(i int) bool { return carved[i]+ChunkSize > offset })
+			return i < len(carved) && carved[i] <= offset
+		}
+		for ce := range cv.Records() {
+			if !inCarvedChunk(ce.Offset) {
+				cce <- ce
+			}
+		}
+	}()
+	return
+}
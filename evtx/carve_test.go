@@ -0,0 +1,77 @@
+package evtx
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// fxEvents maps the record IDs of a file to their events as JSON
+func fxEvents(t *testing.T, data []byte) map[int64]string {
+	t.Helper()
+	events := make(map[int64]string)
+	for r := range fxOpen(t, data).Records() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		events[r.ID] = string(ToJSON(r.Event))
+	}
+	return events
+}
+
+func TestCarver(t *testing.T) {
+	data := fxCleanFile(t)
+	events := fxEvents(t, data)
+	first := data[DefaultChunkOffset : DefaultChunkOffset+ChunkSize]
+	second := data[DefaultChunkOffset+ChunkSize:]
+	c, err := ParseChunk(second, DefaultChunkOffset+ChunkSize, CheckSumStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first record of a chunk carries the definition of its template
+	record := second[c.EventOffsets[0]:c.EventOffsets[1]]
+
+	junk := func(n int) []byte {
+		return bytes.Repeat([]byte{0xcc}, n)
+	}
+	image := junk(1001)
+	chunkOffset := int64(len(image))
+	image = append(image, first...)
+	image = append(image, junk(333)...)
+	recordOffset := int64(len(image))
+	image = append(image, record...)
+	image = append(image, junk(77)...)
+
+	cv := NewCarver(bytes.NewReader(image), int64(len(image)))
+	var chunks []int64
+	for cc := range cv.Chunks() {
+		if cc.Chunk.CheckSumErr != nil {
+			t.Errorf("chunk @ %d: %v", cc.Offset, cc.Chunk.CheckSumErr)
+		}
+		chunks = append(chunks, cc.Offset)
+	}
+	if fmt.Sprint(chunks) != fmt.Sprint([]int64{chunkOffset}) {
+		t.Errorf("chunks carved at %v, expected %d", chunks, chunkOffset)
+	}
+
+	var ids []int64
+	for ce := range cv.Events() {
+		if ce.Err != nil {
+			t.Errorf("record %d: %v", ce.Header.ID, ce.Err)
+			continue
+		}
+		ids = append(ids, ce.Header.ID)
+		switch {
+		case ce.Header.ID == 4 && (ce.Offset != recordOffset || ce.ChunkOffset != -1):
+			t.Errorf("record 4 carved @ %d in chunk %d, expected @ %d on its own", ce.Offset, ce.ChunkOffset, recordOffset)
+		case ce.Header.ID != 4 && ce.ChunkOffset != chunkOffset:
+			t.Errorf("record %d carved in chunk %d, expected %d", ce.Header.ID, ce.ChunkOffset, chunkOffset)
+		}
+		if got := string(ToJSON(ce.Event)); got != events[ce.Header.ID] {
+			t.Errorf("unexpected event of record %d:\n%s\nexpected\n%s", ce.Header.ID, got, events[ce.Header.ID])
+		}
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4]" {
+		t.Errorf("records %v carved, expected [1 2 3 4]", ids)
+	}
+}
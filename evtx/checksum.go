@@ -0,0 +1,118 @@
+package evtx
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"rawsec-evtx/encoding"
+)
+
+type CheckSumMode int
+
+const (
+	CheckSumIgnore CheckSumMode = iota
+	CheckSumLenient
+	CheckSumStrict
+)
+
+const (
+	fileHeaderCheckSumSize   = 120
+	chunkHeaderCheckSumSize  = 120
+	chunkTablesEnd           = 0x200
+	SectionFileHeader        = "file header"
+	SectionChunkHeader       = "chunk header"
+	SectionChunkEventRecords = "event records"
+)
+
+var (
+	ErrChunkTooSmall = errors.New("chunk data too small to verify checksums")
+)
+
+type ErrBadCheckSum struct {
+	Offset   int64
+	Section  string
+	Stored   uint32
+	Computed uint32
+}
+
+func (e ErrBadCheckSum) Error() string {
+	return fmt.Sprintf("bad %s checksum @ 0x%08x: stored 0x%08x computed 0x%08x", e.Section, e.Offset, e.Stored, e.Computed)
+}
+
+func IsCheckSumError(err error) bool {
+	var e ErrBadCheckSum
+	return errors.As(err, &e)
+}
+
+func (f *FileHeader) ComputeCheckSum() (uint32, error) {
+	b, err := encoding.Marshal(f, Endianness)
+	if err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(b[:fileHeaderCheckSumSize]), nil
+}
+
+func (f *FileHeader) VerifyCheckSum() error {
+	crc, err := f.ComputeCheckSum()
+	if err != nil {
+		return err
+	}
+	if crc != f.CheckSum {
+		return ErrBadCheckSum{0, SectionFileHeader, f.CheckSum, crc}
+	}
+	return nil
+}
+
+func (c *Chunk) ComputeHeaderCheckSum() (uint32, error) {
+	if len(c.Data) < chunkTablesEnd {
+		return 0, ErrChunkTooSmall
+	}
+	if err := c.acquire(); err != nil {
+		return 0, err
+	}
+	defer c.release()
+	crc := crc32.ChecksumIEEE(c.Data[:chunkHeaderCheckSumSize])
+	return crc32.Update(crc, crc32.IEEETable, c.Data[ChunkHeaderSize:chunkTablesEnd]), nil
+}
+
+func (c *Chunk) ComputeDataCheckSum() (uint32, error) {
+	end := int(c.Header.Freespace)
+	if end < chunkTablesEnd || end > len(c.Data) {
+		return 0, ErrChunkTooSmall
+	}
+	if err := c.acquire(); err != nil {
+		return 0, err
+	}
+	defer c.release()
+	return crc32.ChecksumIEEE(c.Data[chunkTablesEnd:end]), nil
+}
+
+func (c *Chunk) VerifyHeaderCheckSum() error {
+	crc, err := c.ComputeHeaderCheckSum()
+	if err != nil {
+		return err
+	}
+	if crc != c.Header.HeaderCheckSum {
+		return ErrBadCheckSum{c.Offset, SectionChunkHeader, c.Header.HeaderCheckSum, crc}
+	}
+	return nil
+}
+
+func (c *Chunk) VerifyDataCheckSum() error {
+	crc, err := c.ComputeDataCheckSum()
+	if err != nil {
+		return err
+	}
+	if crc != c.Header.CheckSum {
+		return ErrBadCheckSum{c.Offset, SectionChunkEventRecords, c.Header.CheckSum, crc}
+	}
+	return nil
+}
+
+func (c *Chunk) VerifyCheckSums() error {
+	if err := c.VerifyHeaderCheckSum(); err != nil {
+		return err
+	}
+	return c.VerifyDataCheckSum()
+}
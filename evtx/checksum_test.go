@@ -0,0 +1,66 @@
+package evtx
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// fxCorruptFile flips a byte of the first chunk event records, in a string
+// value so that the events still parse
+func fxCorruptFile(t *testing.T) []byte {
+	t.Helper()
+	data := fxCleanFile(t)
+	chunk := data[DefaultChunkOffset : DefaultChunkOffset+ChunkSize]
+	i := bytes.Index(chunk[chunkTablesEnd:], utf16LE("normal substitution"))
+	if i < 0 {
+		t.Fatal("value not found in the first chunk")
+	}
+	chunk[chunkTablesEnd+i] ^= 1
+	return data
+}
+
+func TestCheckSumModes(t *testing.T) {
+	data := fxCorruptFile(t)
+	chunk := data[DefaultChunkOffset : DefaultChunkOffset+ChunkSize]
+
+	if _, err := ParseChunk(chunk, DefaultChunkOffset, CheckSumStrict); !IsCheckSumError(err) {
+		t.Errorf("expected a checksum error in strict mode, got %v", err)
+	}
+	c, err := ParseChunk(chunk, DefaultChunkOffset, CheckSumLenient)
+	if err != nil || !IsCheckSumError(c.CheckSumErr) {
+		t.Errorf("expected the checksum error on the chunk in lenient mode, got %v and %v", err, c.CheckSumErr)
+	}
+
+	for _, tc := range []struct {
+		mode CheckSumMode
+		ids  string
+	}{
+		{CheckSumIgnore, "[1 2 3 4]"},
+		// the corrupted chunk is reported before its records
+		{CheckSumLenient, "[1 2 3 4]"},
+		// the corrupted chunk is rejected, the next one still decoded
+		{CheckSumStrict, "[4]"},
+	} {
+		ef := fxOpen(t, data)
+		ef.CheckSumMode = tc.mode
+		var ids []int64
+		var errs []error
+		for r := range ef.Records() {
+			if r.Err != nil {
+				errs = append(errs, r.Err)
+				continue
+			}
+			ids = append(ids, r.ID)
+		}
+		if fmt.Sprint(ids) != tc.ids {
+			t.Errorf("mode %d: records %v, expected %s", tc.mode, ids, tc.ids)
+		}
+		switch {
+		case tc.mode == CheckSumIgnore && len(errs) != 0:
+			t.Errorf("mode %d: unexpected errors %v", tc.mode, errs)
+		case tc.mode != CheckSumIgnore && (len(errs) != 1 || !IsCheckSumError(errs[0])):
+			t.Errorf("mode %d: expected a single checksum error, got %v", tc.mode, errs)
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package evtx
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 
@@ -34,6 +34,16 @@ type ChunkHeader struct {
 	OffsetLastRec   int32
 	Freespace       int32
 	CheckSum        uint32
+	Unknown         [64]byte
+	Flags           uint32
+	HeaderCheckSum  uint32
+}
+
+func (ch *ChunkHeader) Verify() error {
+	if string(ch.Magic[:]) != ChunkMagic {
+		return ErrBadChunkMagic
+	}
+	return nil
 }
 
 func (ch ChunkHeader) String() string {
@@ -46,7 +56,9 @@ func (ch ChunkHeader) String() string {
 			"\tSizeHeader: %d\n"+
 			"\tOffsetLastRec: %d\n"+
 			"\tFreespace: %d\n"+
-			"\tCheckSum: 0x%08x\n",
+			"\tCheckSum: 0x%08x\n"+
+			"\tFlags: 0x%08x\n"+
+			"\tHeaderCheckSum: 0x%08x\n",
 		ch.Magic,
 		ch.NumFirstRecLog,
 		ch.NumLastRecLog,
@@ -55,42 +67,122 @@ func (ch ChunkHeader) String() string {
 		ch.SizeHeader,
 		ch.OffsetLastRec,
 		ch.Freespace,
-		ch.CheckSum)
+		ch.CheckSum,
+		ch.Flags,
+		ch.HeaderCheckSum)
 }
 
 type Chunk struct {
 	Offset        int64
+	Index         int
 	Header        ChunkHeader
+	CheckSumErr   error
 	StringTable   ChunkStringTable
 	TemplateTable TemplateTable
 	EventOffsets  []int32
+	SlackOffsets  []int32
 	Data          []byte
+	Limits        Limits
+	templates     *TemplateCache
+	// the mapped file Data points into, if any
+	mapping chunkSlicer
 }
 
 func NewChunk() Chunk {
-	return Chunk{StringTable: make(ChunkStringTable, 0), TemplateTable: make(TemplateTable, 0)}
+	return Chunk{
+		StringTable:   make(ChunkStringTable, 0),
+		TemplateTable: make(TemplateTable, 0),
+		Limits:        DefaultLimits,
+	}
 }
 
-func (c *Chunk) ParseChunkHeader(reader io.ReadSeeker) {
-	err := encoding.Unmarshal(reader, &c.Header, Endianness)
+func ParseChunk(data []byte, offset int64, mode CheckSumMode) (Chunk, error) {
+	return parseChunk(data, offset, mode, DefaultLimits, nil, nil)
+}
+
+func parseChunk(data []byte, offset int64, mode CheckSumMode, limits Limits, templates *TemplateCache, mapping chunkSlicer) (Chunk, error) {
+	c := NewChunk()
+	c.Offset = offset
+	c.Data = data
+	c.Limits = limits
+	c.templates = templates
+	c.mapping = mapping
+	reader, err := c.reader()
 	if err != nil {
-		panic(err)
+		return c, err
+	}
+	defer reader.release()
+	if err := c.ParseChunkHeader(reader); err != nil {
+		return c, err
+	}
+	if mode != CheckSumIgnore {
+		if err := c.VerifyCheckSums(); err != nil {
+			if mode == CheckSumStrict {
+				return c, err
+			}
+			c.CheckSumErr = err
+		}
+	}
+	GoToSeeker(reader, int64(c.Header.SizeHeader))
+	if err := c.ParseStringTable(reader); err != nil {
+		return c, err
+	}
+	if err := c.ParseTemplateTable(reader); err != nil {
+		return c, err
+	}
+	if err := c.ParseEventOffsets(reader); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// reader accounts what is parsed from the chunk against its limits and
+// shares the templates of the file, a mapped file is not unmapped until the
+// reader is released
+func (c *Chunk) reader() (*budgetReader, error) {
+	if err := c.acquire(); err != nil {
+		return nil, err
+	}
+	br := newBudgetReader(c.Data, c.Limits)
+	br.templates = c.templates
+	br.mapping = c.mapping
+	return br, nil
+}
+
+// acquire keeps the data of a chunk sliced out of a mapped file valid until
+// release is called, it fails once the file is closed
+func (c *Chunk) acquire() error {
+	if c.mapping != nil && !c.mapping.acquire() {
+		return ErrFileClosed
 	}
+	return nil
 }
 
-func (c *Chunk) ParseStringTable(reader io.ReadSeeker) {
+func (c *Chunk) release() {
+	if c.mapping != nil {
+		c.mapping.release()
+	}
+}
+
+func (c *Chunk) ParseChunkHeader(reader io.ReadSeeker) error {
+	return encoding.Unmarshal(reader, &c.Header, Endianness)
+}
+
+func (c *Chunk) ParseStringTable(reader io.ReadSeeker) error {
 	strOffset := int32(0)
 	for i := int64(0); i < sizeStringBucket*4; i += 4 {
-		_ = encoding.Unmarshal(reader, &strOffset, Endianness)
+		if err := encoding.Unmarshal(reader, &strOffset, Endianness); err != nil {
+			return err
+		}
 		if strOffset > 0 {
 			cs, err := StringAt(reader, int64(strOffset))
 			if err != nil {
-				panic(err)
+				return err
 			}
 			c.StringTable[strOffset] = cs
 		}
 	}
-	return
+	return nil
 }
 
 func (c *Chunk) ParseTemplateTable(reader io.ReadSeeker) error {
@@ -101,6 +193,10 @@ func (c *Chunk) ParseTemplateTable(reader io.ReadSeeker) error {
 			return err
 		}
 		if templateDataOffset > 0 {
+			if tdd, ok := cachedTemplate(reader, templateDataOffset); ok {
+				c.TemplateTable[templateDataOffset] = tdd
+				continue
+			}
 			backup := BackupSeeker(reader)
 			GoToSeeker(reader, int64(templateDataOffset))
 			tdd := TemplateDefinitionData{}
@@ -108,7 +204,7 @@ func (c *Chunk) ParseTemplateTable(reader io.ReadSeeker) error {
 			if err != nil {
 				return err
 			}
-			c.TemplateTable[templateDataOffset] = tdd
+			c.TemplateTable[templateDataOffset] = cacheTemplate(reader, tdd)
 			GoToSeeker(reader, backup)
 		}
 	}
@@ -134,35 +230,124 @@ func (c *Chunk) ParseEventOffsets(reader io.ReadSeeker) (err error) {
 	return nil
 }
 
-func (c *Chunk) ParseEvent(offset int64) (e Event) {
+func (c *Chunk) ParseAppendedEventOffsets() {
+	if len(c.EventOffsets) == 0 || c.acquire() != nil {
+		return
+	}
+	defer c.release()
+	reader := encoding.NewSliceReader(c.Data)
+	offsetEvent := c.EventOffsets[len(c.EventOffsets)-1]
+	for int(offsetEvent)+EventHeaderSize <= len(c.Data) {
+		eh := EventHeader{}
+		GoToSeeker(reader, int64(offsetEvent))
+		if err := encoding.Unmarshal(reader, &eh, Endianness); err != nil {
+			return
+		}
+		if eh.Validate() != nil || int(offsetEvent)+int(eh.Size) > len(c.Data) {
+			return
+		}
+		c.Header.OffsetLastRec = offsetEvent
+		c.Header.LastEventRecID = eh.ID
+		offsetEvent += eh.Size
+		c.EventOffsets = append(c.EventOffsets, offsetEvent)
+	}
+}
+
+func (c *Chunk) ParseEvent(offset int64) (e Event, err error) {
 	if int64(c.Header.OffsetLastRec) < offset {
+		return e, ErrInvalidEvent
+	}
+	return c.parseEventAt(offset)
+}
+
+func (c *Chunk) parseEventAt(offset int64) (e Event, err error) {
+	if err = c.acquire(); err != nil {
 		return
 	}
-	reader := bytes.NewReader(c.Data)
+	defer c.release()
+	reader := encoding.NewSliceReader(c.Data)
 	GoToSeeker(reader, offset)
 	e.Offset = offset
-	err := encoding.Unmarshal(reader, &e.Header, Endianness)
-	if err != nil {
-		panic(err)
-	}
-	return e
+	err = encoding.Unmarshal(reader, &e.Header, Endianness)
+	return
 }
 
-func (c *Chunk) Events() (cgem chan *GoEvtxMap) {
-	cgem = make(chan *GoEvtxMap, len(c.EventOffsets))
-	go func() {
-		defer close(cgem)
-		for _, eo := range c.EventOffsets {
-			event := c.ParseEvent(int64(eo))
-			gem, err := event.GoEvtxMap(c)
-			if err == nil {
-				cgem <- gem
+func (c *Chunk) records() (records []*Record) {
+	records = make([]*Record, 0, len(c.EventOffsets))
+	usage := make(map[GUID]int)
+	defer c.setTemplateUsage(usage)
+	if c.CheckSumErr != nil {
+		records = append(records, c.errorRecord(c.CheckSumErr))
+	}
+	for _, eo := range c.EventOffsets {
+		if int64(eo) > int64(c.Header.OffsetLastRec) {
+			break
+		}
+		r := &Record{
+			ChunkOffset: c.Offset,
+			ChunkIndex:  c.Index,
+			Offset:      c.Offset + int64(eo),
+			ID:          -1,
+			chunk:       c,
+			offset:      int64(eo),
+		}
+		event, err := c.ParseEvent(int64(eo))
+		var fragment *Fragment
+		if err == nil {
+			r.ID = event.Header.ID
+			r.Size = event.Header.Size
+			r.Timestamp = event.Header.Timestamp.Time()
+			fragment, err = event.Fragment(c)
+		}
+		if err == nil {
+			countTemplates(fragment, usage)
+			r.Event, err = fragment.GoEvtxMap()
+			if system, serr := fragment.System(); serr != nil {
+				r.SystemErr = &ParseError{c.Offset, int64(eo), r.ID, serr}
+			} else {
+				r.System = system
 			}
 		}
-	}()
+		if err != nil {
+			r.Err = &ParseError{c.Offset, int64(eo), r.ID, err}
+		}
+		records = append(records, r)
+	}
 	return
 }
 
+func (c *Chunk) errorRecord(err error) *Record {
+	return &Record{ChunkOffset: c.Offset, ChunkIndex: c.Index, Offset: c.Offset, ID: -1, Err: &ParseError{c.Offset, -1, -1, err}}
+}
+
+func (c *Chunk) recordsUntilError(err error) []*Record {
+	records := []*Record{c.errorRecord(err)}
+	// the last event offset is the one which failed to parse
+	if len(c.EventOffsets) > 1 {
+		c.EventOffsets = c.EventOffsets[:len(c.EventOffsets)-1]
+		records = append(records, c.records()...)
+	}
+	return records
+}
+
+func (c *Chunk) Records() (cr chan *Record) {
+	records := c.records()
+	cr = make(chan *Record, len(records))
+	for _, r := range records {
+		cr <- r
+	}
+	close(cr)
+	return
+}
+
+func (c *Chunk) Events() (cgem chan *GoEvtxMap) {
+	return c.EventsContext(context.Background())
+}
+
+func (c *Chunk) EventsContext(ctx context.Context) (cgem chan *GoEvtxMap) {
+	return eventsOf(ctx, c.Records())
+}
+
 func (c Chunk) String() string {
 	templateOffsets := make([]int32, len(c.TemplateTable))
 	i := 0
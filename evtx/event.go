@@ -1,11 +1,8 @@
 package evtx
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-
-	"rawsec-evtx/log"
 )
 
 type EventHeader struct {
@@ -37,22 +34,33 @@ func (e *Event) IsValid() bool {
 	return e.Header.Validate() == nil
 }
 
-func (e Event) GoEvtxMap(c *Chunk) (pge *GoEvtxMap, err error) {
+func (e Event) Fragment(c *Chunk) (*Fragment, error) {
 	if !e.IsValid() {
-		err = ErrInvalidEvent
-		return
+		return nil, ErrInvalidEvent
+	}
+	reader, err := c.reader()
+	if err != nil {
+		return nil, err
 	}
-	reader := bytes.NewReader(c.Data)
+	defer reader.release()
 	GoToSeeker(reader, e.Offset+EventHeaderSize)
 	element, err := Parse(reader, c, false)
 	if err != nil && err != io.EOF {
-		log.Error(err)
+		return nil, err
 	}
 	fragment, ok := element.(*Fragment)
 	if !ok {
-		_ = element.(*Fragment)
+		return nil, fmt.Errorf("event does not start with a fragment: %T", element)
+	}
+	return fragment, nil
+}
+
+func (e Event) GoEvtxMap(c *Chunk) (*GoEvtxMap, error) {
+	fragment, err := e.Fragment(c)
+	if err != nil {
+		return nil, err
 	}
-	return fragment.GoEvtxMap(), err
+	return fragment.GoEvtxMap()
 }
 
 func (e Event) String() string {
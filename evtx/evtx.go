@@ -3,12 +3,15 @@ package evtx
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"rawsec-evtx/encoding"
+	"rawsec-evtx/log"
 	"regexp"
 	"sync"
+	"time"
 )
 
 type ChunkSorter []Chunk
@@ -29,6 +32,7 @@ var (
 	ErrCorruptedHeader = fmt.Errorf("corrupted header")
 	ErrDirtyFile       = fmt.Errorf("file is flagged as dirty")
 	ErrRepairFailed    = fmt.Errorf("file header could not be repaired")
+	ErrFileClosed      = fmt.Errorf("file is closed")
 )
 
 type FileHeader struct {
@@ -68,25 +72,43 @@ func (f *FileHeader) Repair(r io.ReadSeeker) error {
 		return ErrRepairFailed
 	}
 
+	validCheckSum := f.VerifyCheckSum() == nil
 	f.ChunkCount = cc
 	f.LastChunkNum = uint64(f.ChunkCount - 1)
 	f.Flags = 0
+	if validCheckSum {
+		f.CheckSum, _ = f.ComputeCheckSum()
+	}
 	return nil
 }
 
 type File struct {
 	sync.Mutex
 	Header          FileHeader
+	CheckSumMode    CheckSumMode
+	FollowInterval  time.Duration
+	Workers         int
+	PreserveOrder   bool
+	Limits          Limits
 	file            io.ReadSeeker
+	templates       *TemplateCache
 	monitorExisting bool
 }
 
 func New(r io.ReadSeeker) (ef File, err error) {
 	ef.file = r
-	ef.ParseFileHeader()
+	ef.Limits = DefaultLimits
+	ef.templates = NewTemplateCache()
+	err = ef.ParseFileHeader()
 	return
 }
 
+// NewReaderAt reads chunks concurrently through r, without the lock and the
+// seek needed by the io.ReadSeeker of New
+func NewReaderAt(r io.ReaderAt, size int64) (File, error) {
+	return New(io.NewSectionReader(r, 0, size))
+}
+
 func Open(filepath string) (ef File, err error) {
 	file, err := os.Open(filepath)
 	if err != nil {
@@ -95,6 +117,7 @@ func Open(filepath string) (ef File, err error) {
 
 	ef, err = New(file)
 	if err != nil {
+		_ = file.Close()
 		return
 	}
 
@@ -110,15 +133,12 @@ func OpenDirty(filepath string) (ef File, err error) {
 	return
 }
 
-func (ef *File) ParseFileHeader() {
+func (ef *File) ParseFileHeader() error {
 	ef.Lock()
 	defer ef.Unlock()
 
 	GoToSeeker(ef.file, 0)
-	err := encoding.Unmarshal(ef.file, &ef.Header, Endianness)
-	if err != nil {
-		panic(err)
-	}
+	return encoding.Unmarshal(ef.file, &ef.Header, Endianness)
 }
 
 func (f FileHeader) String() string {
@@ -149,53 +169,62 @@ func (f FileHeader) String() string {
 }
 
 func (ef *File) FetchRawChunk(offset int64) (Chunk, error) {
-	ef.Lock()
-	defer ef.Unlock()
 	c := NewChunk()
-	GoToSeeker(ef.file, offset)
 	c.Offset = offset
+	c.Index = ef.chunkIndex(offset)
 	c.Data = make([]byte, ChunkHeaderSize)
-	if _, err := ef.file.Read(c.Data); err != nil {
+	if _, err := ef.readAt(c.Data, offset); err != nil {
 		return c, err
 	}
 	reader := bytes.NewReader(c.Data)
-	c.ParseChunkHeader(reader)
-	return c, nil
+	err := c.ParseChunkHeader(reader)
+	return c, err
+}
+
+// chunkSlicer is implemented by the files held in memory, what is sliced out
+// of them is valid from acquire to release
+type chunkSlicer interface {
+	slice(offset, n int64) ([]byte, bool)
+	acquire() bool
+	release()
 }
 
 func (ef *File) FetchChunk(offset int64) (Chunk, error) {
-	ef.Lock()
-	defer ef.Unlock()
-	c := NewChunk()
-	GoToSeeker(ef.file, offset)
-	c.Offset = offset
-	c.Data = make([]byte, ChunkSize)
-	if _, err := ef.file.Read(c.Data); err != nil {
-		return c, err
-	}
-	reader := bytes.NewReader(c.Data)
-	c.ParseChunkHeader(reader)
-	GoToSeeker(reader, int64(c.Header.SizeHeader))
-	c.ParseStringTable(reader)
-	if err := c.ParseTemplateTable(reader); err != nil {
-		return c, err
+	if cs, ok := ef.file.(chunkSlicer); ok && cs.acquire() {
+		defer cs.release()
+		if data, ok := cs.slice(offset, ChunkSize); ok {
+			c, err := parseChunk(data, offset, ef.CheckSumMode, ef.Limits, ef.templates, cs)
+			c.Index = ef.chunkIndex(offset)
+			return c, err
+		}
 	}
-	if err := c.ParseEventOffsets(reader); err != nil {
+	data := make([]byte, ChunkSize)
+	if _, err := ef.readAt(data, offset); err != nil {
+		c := NewChunk()
+		c.Offset = offset
+		c.Index = ef.chunkIndex(offset)
+		c.Data = data
+		c.Limits = ef.Limits
 		return c, err
 	}
-	return c, nil
+	c, err := parseChunk(data, offset, ef.CheckSumMode, ef.Limits, ef.templates, nil)
+	c.Index = ef.chunkIndex(offset)
+	return c, err
+}
+
+func (ef *File) chunkIndex(offset int64) int {
+	return int((offset - int64(ef.Header.ChunkDataOffset)) / ChunkSize)
 }
 
 func (ef *File) UnorderedChunks() (cc chan Chunk) {
 	cc = make(chan Chunk)
 	go func() {
 		defer close(cc)
-		for i := uint16(0); i < ef.Header.ChunkCount; i++ {
-			offsetChunk := int64(ef.Header.ChunkDataOffset) + int64(ChunkSize)*int64(i)
+		for _, offsetChunk := range ef.chunkOffsets() {
 			chunk, err := ef.FetchRawChunk(offsetChunk)
 			switch {
 			case err != nil && err != io.EOF:
-				panic(err)
+				log.Error(&ParseError{offsetChunk, -1, -1, err})
 			case err == nil:
 				cc <- chunk
 			}
@@ -204,33 +233,69 @@ func (ef *File) UnorderedChunks() (cc chan Chunk) {
 	return
 }
 
-func (ef *File) UnorderedEvents() (cgem chan *GoEvtxMap) {
-	cgem = make(chan *GoEvtxMap, 42)
+// chunkOffsets returns the offsets of the chunks of the header, but those
+// beyond the end of the file
+func (ef *File) chunkOffsets() []int64 {
+	count := int64(ef.Header.ChunkCount)
+	ef.Lock()
+	size, err := ef.file.Seek(0, io.SeekEnd)
+	ef.Unlock()
+	if n := (size - int64(ef.Header.ChunkDataOffset) + ChunkSize - 1) / ChunkSize; err == nil && n < count {
+		count = n
+	}
+	if count < 0 {
+		count = 0
+	}
+	offsets := make([]int64, count)
+	for i := range offsets {
+		offsets[i] = int64(ef.Header.ChunkDataOffset) + int64(ChunkSize)*int64(i)
+	}
+	return offsets
+}
+
+func (ef *File) fetchChunkRecords(offset int64) []*Record {
+	c, err := ef.FetchChunk(offset)
+	switch {
+	case err == io.EOF:
+		return nil
+	case err != nil:
+		return c.recordsUntilError(err)
+	}
+	return c.records()
+}
+
+func (ef *File) UnorderedRecords() (cr chan *Record) {
+	return ef.UnorderedRecordsContext(context.Background())
+}
+
+func (ef *File) UnorderedRecordsContext(ctx context.Context) (cr chan *Record) {
+	cr = make(chan *Record, 42)
 	go func() {
-		defer close(cgem)
-		chanQueue := make(chan (chan *GoEvtxMap), MaxJobs)
-		go func() {
-			defer close(chanQueue)
-			for pc := range ef.UnorderedChunks() {
-				cpc, err := ef.FetchChunk(pc.Offset)
-				switch {
-				case err != nil && err != io.EOF:
-					panic(err)
-				case err == nil:
-					ev := cpc.Events()
-					chanQueue <- ev
+		defer close(cr)
+		ctx, cancel := context.WithCancel(ctx)
+		batches := ef.decodeChunks(ctx, ef.chunkOffsets(), ef.PreserveOrder, ef.fetchChunkRecords)
+		defer drainBatches(cancel, batches)
+		for records := range batches {
+			for _, r := range records {
+				select {
+				case cr <- r:
+				case <-ctx.Done():
+					return
 				}
 			}
-		}()
-		for ec := range chanQueue {
-			for event := range ec {
-				cgem <- event
-			}
 		}
 	}()
 	return
 }
 
+func (ef *File) UnorderedEvents() (cgem chan *GoEvtxMap) {
+	return ef.UnorderedEventsContext(context.Background())
+}
+
+func (ef *File) UnorderedEventsContext(ctx context.Context) (cgem chan *GoEvtxMap) {
+	return eventsOf(ctx, ef.UnorderedRecordsContext(ctx))
+}
+
 func (ef *File) Close() error {
 	if f, ok := ef.file.(io.Closer); ok {
 		return f.Close()
@@ -0,0 +1,260 @@
+// Package filter implements the event filter expressions shared by evtxdump
+// and the library, for instance:
+//
+//	EventID in (4624, 4625) and EventData/LogonType == 10
+//	Channel == Security and not exists EventData/SubjectUserSid
+//	TimeCreated >= 2024-01-01 and TimeCreated < 2024-01-02T12:00:00Z
+//	EventData/CommandLine =~ '(?i)powershell.+-enc'
+//
+// Paths are GoEvtxPath separated by /. Absolute paths start at the root of the
+// event, relative ones are looked up from the Event root, under /Event,
+// /Event/System and /Event/EventData in that order. Elements having attributes, like EventID
+// with its Qualifiers, compare through their Value, and TimeCreated through
+// its SystemTime.
+//
+// Equality is not case sensitive and numeric when both sides are numbers.
+// Ordering compares numbers, timestamps (RFC3339 or 2006-01-02) or strings
+// depending on the literal. Arrays match when any of their items does. A
+// missing element never matches a comparison, != included.
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"rawsec-evtx/evtx"
+)
+
+type SyntaxError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("filter: %s at position %d in %q", e.Msg, e.Pos, e.Expr)
+}
+
+// Filter is a compiled filter expression, safe for concurrent use
+type Filter struct {
+	expr string
+	root node
+}
+
+func Compile(expr string) (*Filter, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{expr: expr, tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, p.errorf("empty filter")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", t)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+func MustCompile(expr string) *Filter {
+	f, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func (f *Filter) Match(e *evtx.GoEvtxMap) bool {
+	if e == nil {
+		return false
+	}
+	return f.root.match(e)
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type parser struct {
+	expr   string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Expr: p.expr, Pos: p.peek().pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	if p.peek().kind != kind {
+		return token{}, p.errorf("expected %s, got %s", what, p.peek())
+	}
+	return p.next(), nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.is("or") || (t.kind == tokOp && t.text == "||"); t = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.is("and") || (t.kind == tokOp && t.text == "&&"); t = p.peek() {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if t := p.peek(); t.is("not") || (t.kind == tokOp && t.text == "!") {
+		p.next()
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokLParen:
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case t.is("exists"):
+		p.next()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		return &existsNode{path}, nil
+	}
+
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	t = p.peek()
+	switch {
+	case t.is("in"):
+		p.next()
+		return p.parseIn(path)
+	case t.is("not") && p.tokens[p.pos+1].is("in"):
+		p.next()
+		p.next()
+		in, err := p.parseIn(path)
+		if err != nil {
+			return nil, err
+		}
+		// the element has to exist like for !=
+		return &andNode{&existsNode{path}, &notNode{in}}, nil
+	case t.kind != tokOp:
+		return nil, p.errorf("expected an operator after %s, got %s", path, t)
+	}
+
+	ot := p.next()
+	op, ok := cmpOps[ot.text]
+	if !ok && ot.text != "=~" && ot.text != "!~" {
+		return nil, &SyntaxError{Expr: p.expr, Pos: ot.pos, Msg: fmt.Sprintf("expected an operator after %s, got %s", path, ot)}
+	}
+	lt := p.peek()
+	lit, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return &cmpNode{path: path, op: op, lit: lit}, nil
+	}
+	re, err := regexp.Compile(lit.text)
+	if err != nil {
+		return nil, &SyntaxError{Expr: p.expr, Pos: lt.pos, Msg: err.Error()}
+	}
+	return &regexpNode{path: path, re: re, negate: ot.text == "!~"}, nil
+}
+
+func (p *parser) parsePath() (*path, error) {
+	t, err := p.expect(tokWord, "an element path")
+	if err != nil {
+		return nil, err
+	}
+	if strings.Trim(t.text, evtx.PathSeparator) == "" {
+		return nil, &SyntaxError{Expr: p.expr, Pos: t.pos, Msg: "empty element path"}
+	}
+	return newPath(t.text), nil
+}
+
+func (p *parser) parseIn(path *path) (node, error) {
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+	in := &inNode{path: path}
+	for {
+		lit, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		in.lits = append(in.lits, lit)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func (p *parser) parseLiteral() (literal, error) {
+	switch t := p.peek(); t.kind {
+	case tokWord, tokString:
+		p.next()
+		return newLiteral(t.text), nil
+	}
+	return literal{}, p.errorf("expected a value, got %s", p.peek())
+}
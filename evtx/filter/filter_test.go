@@ -0,0 +1,228 @@
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"rawsec-evtx/evtx"
+)
+
+// event as decoded by the evtx package
+const testEvent = `{"Event":{
+	"EventData":{"LogonType":"10","TargetUserName":"Administrator","IpAddress":"10.0.0.7",
+		"CommandLine":"powershell.exe -nop -enc SQBFAFgA","Empty":null,
+		"Names":["one","two","three"],"Ports":[80,443]},
+	"System":{"Channel":"Security","Computer":"dc01.example.org",
+		"EventID":{"Qualifiers":"16384","Value":"4624"},"EventRecordID":"1",
+		"Keywords":"0x8020000000000000","Level":"0","Version":"2",
+		"Provider":{"Guid":"54849625-5478-4994-A5BA-3E3B0328C30D","Name":"Microsoft-Windows-Security-Auditing"},
+		"TimeCreated":{"SystemTime":"2024-02-29T12:34:57.1234567Z"}}}}`
+
+func testMap(t *testing.T) *evtx.GoEvtxMap {
+	t.Helper()
+	var e evtx.GoEvtxMap
+	if err := json.Unmarshal([]byte(testEvent), &e); err != nil {
+		t.Fatal(err)
+	}
+	return &e
+}
+
+func TestMatch(t *testing.T) {
+	e := testMap(t)
+	for _, tc := range []struct {
+		expr  string
+		match bool
+	}{
+		{"EventID == 4624", true},
+		{"EventID = 4625", false},
+		{"/Event/System/EventID == 4624", true},
+		{"Event/System/EventID/Qualifiers == 16384", true},
+		{"EventID in (4624, 4625) and EventData/LogonType == 10", true},
+		{"EventID in (4625,4634)", false},
+		{"EventID not in (4625, 4634)", true},
+		{"Missing not in (4625)", false},
+		{"LogonType == 10", true},
+		{"LogonType != 10", false},
+		{"LogonType != 3", true},
+		{"LogonType == 10.0", true},
+		{"LogonType >= 3 && LogonType < 11", true},
+		{"LogonType > 10", false},
+		{"Level <= 4 and Version > 1", true},
+		{"Keywords == 0x8020000000000000", true},
+		{"Keywords >= 9232379236109516800", true},
+		{"Keywords > 9232379236109516800", false},
+		{"Keywords == 9232379236109516801", false},
+		{"Channel == security", true},
+		{"Channel == 'Security' or Channel == System", true},
+		{"Channel != Security", false},
+		{"Provider/Name =~ 'Security-Auditing$'", true},
+		{"CommandLine =~ '(?i)POWERSHELL.+-enc'", true},
+		{"CommandLine =~ 'POWERSHELL'", false},
+		{"CommandLine !~ 'cmd\\.exe'", true},
+		{"TargetUserName == \"administrator\"", true},
+		{"TargetUserName > Adam and TargetUserName < b", true},
+		{"Names == two", true},
+		{"Names == four", false},
+		{"Names != four", true},
+		{"Ports in (22, 443)", true},
+		{"exists EventData/IpAddress", true},
+		{"exists IpAddress and not exists SubjectUserSid", true},
+		{"exists Empty", true},
+		{"Empty == null", false},
+		{"Missing == 1 or Missing != 1", false},
+		{"not Missing == 1", true},
+		{"exists System", true},
+		{"System == Security", false},
+		{"TimeCreated >= 2024-02-29 and TimeCreated < 2024-03-01", true},
+		{"TimeCreated > 2024-02-29T12:34:57.1234567Z", false},
+		{"TimeCreated == 2024-02-29T12:34:57.1234567Z", true},
+		{"/Event/System/TimeCreated/SystemTime > '2024-02-29 13:00:00+02:00'", true},
+		{"TimeCreated < 2024-02-29T12:00:00", false},
+		{"not (EventID == 4624 and Channel == System)", true},
+		{"EventID == 1 or EventID == 2 or Channel == Security and Level == 0", true},
+		{"(EventID == 1 or EventID == 2 or Channel == Security) and Level == 1", false},
+		{"! ! exists Channel", true},
+	} {
+		f, err := Compile(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if f.Match(e) != tc.match {
+			t.Errorf("%s: expected %t", tc.expr, tc.match)
+		}
+		if f.String() != tc.expr {
+			t.Errorf("%s: unexpected string %s", tc.expr, f)
+		}
+	}
+
+	if MustCompile("EventID == 4624").Match(nil) {
+		t.Error("nil event matched")
+	}
+}
+
+func TestMatchTypedValues(t *testing.T) {
+	// values are not all strings in the events parsed from files
+	created := time.Date(2024, 2, 29, 12, 34, 57, 123456700, time.UTC)
+	e := &evtx.GoEvtxMap{"Event": evtx.GoEvtxMap{
+		"EventData": evtx.GoEvtxMap{
+			"AnsiString":  []byte("ansi string"),
+			"UInt16Array": []uint16{1, 2, 3},
+			"FileTime":    created,
+		},
+		"System": evtx.GoEvtxMap{
+			"EventID":     "4624",
+			"TimeCreated": evtx.GoEvtxMap{"SystemTime": evtx.UTCTime(created)},
+		},
+	}}
+	for _, tc := range []struct {
+		expr  string
+		match bool
+	}{
+		{"TimeCreated >= 2024-02-29 and TimeCreated < 2024-03-01", true},
+		{"TimeCreated == '2024-02-29T13:34:57.1234567+01:00'", true},
+		{"TimeCreated =~ '^2024-02-29T12:34:57'", true},
+		{"FileTime > 2024-02-29T12:34:58Z", false},
+		{"AnsiString == 'ANSI string'", true},
+		{"UInt16Array in (3, 4)", true},
+		{"UInt16Array > 3", false},
+	} {
+		if MustCompile(tc.expr).Match(e) != tc.match {
+			t.Errorf("%s: expected %t", tc.expr, tc.match)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	e := testMap(t)
+	for _, tc := range []struct {
+		path   string
+		values string
+		ok     bool
+	}{
+		{"EventID", "4624", true},
+		{"Names", "one,two,three", true},
+		{"Ports", "80,443", true},
+		{"TimeCreated", "2024-02-29T12:34:57.1234567Z", true},
+		{"Empty", "", true},
+		{"/Event/System/Provider", "", true},
+		{"Missing", "", false},
+	} {
+		values, ok := NewField(tc.path).Values(e)
+		if ok != tc.ok || strings.Join(values, ",") != tc.values {
+			t.Errorf("%s: unexpected values %q %t", tc.path, values, ok)
+		}
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		pos  int
+	}{
+		{"", 0},
+		{"   ", 3},
+		{"EventID", 7},
+		{"EventID ==", 10},
+		{"EventID == 4624 and", 19},
+		{"EventID == 4624 4625", 16},
+		{"EventID in 4624", 11},
+		{"EventID in (4624,", 17},
+		{"EventID in (4624 4625)", 17},
+		{"(EventID == 4624", 16},
+		{"EventID == 4624)", 15},
+		{"EventID && 4624", 8},
+		{"EventID == 'open", 11},
+		{"EventID =~ '('", 11},
+		{"EventID # 1", 8},
+		{"exists", 6},
+		{"exists ==", 7},
+		{"/ == 1", 0},
+	} {
+		_, err := Compile(tc.expr)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("%q: expected a syntax error, got %v", tc.expr, err)
+			continue
+		}
+		if se.Pos != tc.pos {
+			t.Errorf("%q: expected error at %d, got %v", tc.expr, tc.pos, err)
+		}
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		c    int
+	}{
+		{"1", "2", -1},
+		{"-1", "1", -1},
+		{"-0", "0", 0},
+		{"-2", "-1", -1},
+		{"18446744073709551615", "18446744073709551614", 1},
+		{"0xff", "255", 0},
+		{"-0x10", "-16", 0},
+		{"1.5", "1", 1},
+		{"-1.5", "-1", -1},
+		{".5", "0.5", 0},
+	} {
+		a, aok := parseNumber(tc.a)
+		b, bok := parseNumber(tc.b)
+		if !aok || !bok {
+			t.Errorf("%s or %s not a number", tc.a, tc.b)
+			continue
+		}
+		if c := a.compare(b); c != tc.c {
+			t.Errorf("%s vs %s: expected %d, got %d", tc.a, tc.b, tc.c, c)
+		}
+	}
+	for _, s := range []string{"", "-", "Inf", "NaN", "0x1p-2", "0xzz", "1.2.3", "2024-02-29", "S-1-5-18"} {
+		if _, ok := parseNumber(s); ok {
+			t.Errorf("%q parsed as a number", s)
+		}
+	}
+}
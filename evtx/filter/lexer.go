@@ -0,0 +1,123 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	// paths, keywords and unquoted values
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// is returns true if the token is the keyword kw, keywords are not case
+// sensitive
+func (t token) is(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "=", "!"}
+
+func isWordByte(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '(', ')', ',', '"', '\'', '=', '!', '<', '>', '~', '&', '|':
+		return false
+	}
+	return true
+}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case c == '"' || c == '\'':
+			s, n, err := lexString(expr[i:])
+			if err != nil {
+				return nil, &SyntaxError{Expr: expr, Pos: i, Msg: err.Error()}
+			}
+			tokens = append(tokens, token{tokString, s, i})
+			i += n
+		case !isWordByte(c):
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				r, _ := utf8.DecodeRuneInString(expr[i:])
+				return nil, &SyntaxError{Expr: expr, Pos: i, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, token{tokOp, op, i})
+			i += len(op)
+		default:
+			start := i
+			for i < len(expr) && isWordByte(expr[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, expr[start:i], start})
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(expr)}), nil
+}
+
+// lexString reads a quoted string, the quote is escaped by doubling it or
+// with a backslash, other backslashes are kept so that regexps read as
+// written
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\'):
+			b.WriteByte(s[i+1])
+			i++
+		case c == quote && i+1 < len(s) && s[i+1] == quote:
+			b.WriteByte(quote)
+			i++
+		case c == quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
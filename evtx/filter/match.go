@@ -0,0 +1,416 @@
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"rawsec-evtx/evtx"
+)
+
+type node interface {
+	match(e *evtx.GoEvtxMap) bool
+}
+
+type cmpOp int
+
+const (
+	opEq cmpOp = iota
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+)
+
+var cmpOps = map[string]cmpOp{
+	"==": opEq,
+	"=":  opEq,
+	"!=": opNe,
+	"<":  opLt,
+	"<=": opLe,
+	">":  opGt,
+	">=": opGe,
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) match(e *evtx.GoEvtxMap) bool {
+	return n.left.match(e) && n.right.match(e)
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) match(e *evtx.GoEvtxMap) bool {
+	return n.left.match(e) || n.right.match(e)
+}
+
+type notNode struct {
+	n node
+}
+
+func (n *notNode) match(e *evtx.GoEvtxMap) bool {
+	return !n.n.match(e)
+}
+
+type existsNode struct {
+	path *path
+}
+
+func (n *existsNode) match(e *evtx.GoEvtxMap) bool {
+	_, ok := n.path.get(e)
+	return ok
+}
+
+type cmpNode struct {
+	path *path
+	op   cmpOp
+	lit  literal
+}
+
+func (n *cmpNode) match(e *evtx.GoEvtxMap) bool {
+	v, ok := n.path.get(e)
+	if !ok {
+		return false
+	}
+	if n.op == opNe {
+		return !anyValue(v, n.lit.equal)
+	}
+	return anyValue(v, func(s string) bool {
+		c, ok := n.lit.compare(s)
+		if !ok {
+			return false
+		}
+		switch n.op {
+		case opEq:
+			return c == 0
+		case opLt:
+			return c < 0
+		case opLe:
+			return c <= 0
+		case opGt:
+			return c > 0
+		case opGe:
+			return c >= 0
+		}
+		return false
+	})
+}
+
+type inNode struct {
+	path *path
+	lits []literal
+}
+
+func (n *inNode) match(e *evtx.GoEvtxMap) bool {
+	v, ok := n.path.get(e)
+	if !ok {
+		return false
+	}
+	return anyValue(v, func(s string) bool {
+		for _, lit := range n.lits {
+			if lit.equal(s) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+type regexpNode struct {
+	path   *path
+	re     *regexp.Regexp
+	negate bool
+}
+
+func (n *regexpNode) match(e *evtx.GoEvtxMap) bool {
+	v, ok := n.path.get(e)
+	if !ok {
+		return false
+	}
+	return anyValue(v, n.re.MatchString) != n.negate
+}
+
+// path is an element path with the absolute paths it may designate
+type path struct {
+	text       string
+	candidates []evtx.GoEvtxPath
+}
+
+func newPath(text string) *path {
+	p := &path{text: text}
+	rel := evtx.Path(text)
+	if strings.HasPrefix(text, evtx.PathSeparator) {
+		p.candidates = []evtx.GoEvtxPath{rel}
+		return p
+	}
+	if rel[0] == "Event" {
+		p.candidates = append(p.candidates, rel)
+	}
+	for _, root := range []string{"Event", "Event/System", "Event/EventData"} {
+		p.candidates = append(p.candidates, append(evtx.Path(root), rel...))
+	}
+	return p
+}
+
+func (p *path) String() string {
+	return p.text
+}
+
+// get returns the first element found at one of the candidate paths
+func (p *path) get(e *evtx.GoEvtxMap) (interface{}, bool) {
+	for k := range p.candidates {
+		if elt, err := e.Get(&p.candidates[k]); err == nil {
+			return unwrap(*elt), true
+		}
+	}
+	return nil, false
+}
+
+// Field is an element path resolved like those of the filter expressions, it
+// gives other matching engines the same view of the events
+type Field struct {
+	path *path
+}
+
+func NewField(text string) Field {
+	return Field{newPath(text)}
+}
+
+func (f Field) String() string {
+	return f.path.text
+}
+
+// Values returns the values of the element formatted as strings, the items of
+// arrays one by one. It returns false if the element does not exist.
+func (f Field) Values(e *evtx.GoEvtxMap) ([]string, bool) {
+	v, ok := f.path.get(e)
+	if !ok {
+		return nil, false
+	}
+	var values []string
+	anyValue(v, func(s string) bool {
+		values = append(values, s)
+		return false
+	})
+	return values, true
+}
+
+// unwrap returns the value of the elements carrying attributes
+func unwrap(v interface{}) interface{} {
+	var m map[string]interface{}
+	switch v.(type) {
+	case evtx.GoEvtxMap:
+		m = v.(evtx.GoEvtxMap)
+	case map[string]interface{}:
+		m = v.(map[string]interface{})
+	default:
+		return v
+	}
+	for _, key := range []string{"Value", "SystemTime"} {
+		if value, ok := m[key]; ok {
+			return value
+		}
+	}
+	return v
+}
+
+// anyValue returns true if fn returns true for v or any of its items if v is
+// a slice
+func anyValue(v interface{}, fn func(string) bool) bool {
+	switch v.(type) {
+	case nil:
+		return false
+	case string:
+		return fn(v.(string))
+	case []byte:
+		// ANSI strings
+		return fn(string(v.([]byte)))
+	case []string:
+		for _, s := range v.([]string) {
+			if fn(s) {
+				return true
+			}
+		}
+		return false
+	case evtx.GoEvtxMap, map[string]interface{}:
+		// elements are not values
+		return false
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		for i := 0; i < rv.Len(); i++ {
+			if anyValue(rv.Index(i).Interface(), fn) {
+				return true
+			}
+		}
+		return false
+	}
+	return fn(valueString(v))
+}
+
+// valueString formats the values of the events, timestamps like in JSON
+func valueString(v interface{}) string {
+	switch v.(type) {
+	case evtx.UTCTime:
+		return time.Time(v.(evtx.UTCTime)).UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		return v.(time.Time).UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.(fmt.Stringer).String()
+	}
+	return fmt.Sprint(v)
+}
+
+type literal struct {
+	text   string
+	num    number
+	isNum  bool
+	time   time.Time
+	isTime bool
+}
+
+func newLiteral(text string) literal {
+	lit := literal{text: text}
+	lit.num, lit.isNum = parseNumber(text)
+	if !lit.isNum {
+		lit.time, lit.isTime = parseTime(text)
+	}
+	return lit
+}
+
+func (l *literal) equal(s string) bool {
+	c, ok := l.compare(s)
+	if !ok {
+		return strings.EqualFold(s, l.text)
+	}
+	return c == 0
+}
+
+// compare compares s to the literal, it returns false if s is not of the type
+// of the literal
+func (l *literal) compare(s string) (int, bool) {
+	switch {
+	case l.isNum:
+		n, ok := parseNumber(s)
+		if !ok {
+			return 0, false
+		}
+		return n.compare(l.num), true
+	case l.isTime:
+		t, ok := parseTime(s)
+		if !ok {
+			return 0, false
+		}
+		return t.Compare(l.time), true
+	}
+	return strings.Compare(strings.ToLower(s), strings.ToLower(l.text)), true
+}
+
+// number keeps integers exact, large unsigned ones like keywords included
+type number struct {
+	integer bool
+	neg     bool
+	abs     uint64
+	f       float64
+}
+
+func parseNumber(s string) (n number, ok bool) {
+	if s == "" {
+		return
+	}
+	digits := s
+	if digits[0] == '-' || digits[0] == '+' {
+		n.neg = digits[0] == '-'
+		digits = digits[1:]
+	}
+	if len(digits) > 2 && digits[0] == '0' && (digits[1] == 'x' || digits[1] == 'X') {
+		abs, err := strconv.ParseUint(digits[2:], 16, 64)
+		if err != nil {
+			return n, false
+		}
+		n.integer, n.abs = true, abs
+	} else if abs, err := strconv.ParseUint(digits, 10, 64); err == nil {
+		n.integer, n.abs = true, abs
+	} else {
+		// no Inf, NaN or hexadecimal floats
+		if digits == "" || (digits[0] != '.' && (digits[0] < '0' || digits[0] > '9')) || strings.ContainsAny(digits, "xXpP") {
+			return n, false
+		}
+		f, err := strconv.ParseFloat(digits, 64)
+		if err != nil {
+			return n, false
+		}
+		if n.neg {
+			f = -f
+		}
+		n.f = f
+		return n, true
+	}
+	if n.abs == 0 {
+		n.neg = false
+	}
+	n.f = float64(n.abs)
+	if n.neg {
+		n.f = -n.f
+	}
+	return n, true
+}
+
+func (n number) compare(o number) int {
+	if !n.integer || !o.integer {
+		switch {
+		case n.f < o.f:
+			return -1
+		case n.f > o.f:
+			return 1
+		}
+		return 0
+	}
+	if n.neg != o.neg {
+		if n.neg {
+			return -1
+		}
+		return 1
+	}
+	c := 0
+	switch {
+	case n.abs < o.abs:
+		c = -1
+	case n.abs > o.abs:
+		c = 1
+	}
+	if n.neg {
+		return -c
+	}
+	return c
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseTime parses the timestamps of the events and the usual ways to write
+// them, those without a zone are UTC
+func parseTime(s string) (time.Time, bool) {
+	// cheap check before trying the layouts on every value
+	if len(s) < 10 || s[4] != '-' || s[7] != '-' {
+		return time.Time{}, false
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
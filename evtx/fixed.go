@@ -0,0 +1,78 @@
+package evtx
+
+import (
+	"rawsec-evtx/encoding"
+)
+
+// fixed size structures decoded from the chunk buffer without reflection,
+// see encoding.FixedUnmarshaler
+
+func (h *EventHeader) FixedSize() int {
+	return EventHeaderSize
+}
+
+func (h *EventHeader) UnmarshalFixed(b []byte, e encoding.Endianness) {
+	copy(h.Magic[:], b)
+	h.Size = int32(e.Uint32(b[4:]))
+	h.ID = int64(e.Uint64(b[8:]))
+	h.Timestamp.Nanoseconds = int64(e.Uint64(b[16:]))
+}
+
+func (fh *FragmentHeader) FixedSize() int {
+	return 4
+}
+
+func (fh *FragmentHeader) UnmarshalFixed(b []byte, e encoding.Endianness) {
+	fh.Token = int8(b[0])
+	fh.MajVersion = int8(b[1])
+	fh.MinVersion = int8(b[2])
+	fh.Flags = int8(b[3])
+}
+
+func (cer *CharEntityRef) FixedSize() int {
+	return 3
+}
+
+func (cer *CharEntityRef) UnmarshalFixed(b []byte, e encoding.Endianness) {
+	cer.Token = int8(b[0])
+	cer.Value = int16(e.Uint16(b[1:]))
+}
+
+func (tdh *TemplateDefinitionHeader) FixedSize() int {
+	return 9
+}
+
+func (tdh *TemplateDefinitionHeader) UnmarshalFixed(b []byte, e encoding.Endianness) {
+	tdh.Unknown1 = int8(b[0])
+	tdh.Unknown2 = int32(e.Uint32(b[1:]))
+	tdh.DataOffset = int32(e.Uint32(b[5:]))
+}
+
+func (v *ValueDescriptor) FixedSize() int {
+	return 4
+}
+
+func (v *ValueDescriptor) UnmarshalFixed(b []byte, e encoding.Endianness) {
+	v.Size = e.Uint16(b)
+	v.ValType = ValueType(b[2])
+	v.Unknown = int8(b[3])
+}
+
+func (ch *ChunkHeader) FixedSize() int {
+	return ChunkHeaderSize
+}
+
+func (ch *ChunkHeader) UnmarshalFixed(b []byte, e encoding.Endianness) {
+	copy(ch.Magic[:], b)
+	ch.NumFirstRecLog = int64(e.Uint64(b[8:]))
+	ch.NumLastRecLog = int64(e.Uint64(b[16:]))
+	ch.FirstEventRecID = int64(e.Uint64(b[24:]))
+	ch.LastEventRecID = int64(e.Uint64(b[32:]))
+	ch.SizeHeader = int32(e.Uint32(b[40:]))
+	ch.OffsetLastRec = int32(e.Uint32(b[44:]))
+	ch.Freespace = int32(e.Uint32(b[48:]))
+	ch.CheckSum = e.Uint32(b[52:])
+	copy(ch.Unknown[:], b[56:120])
+	ch.Flags = e.Uint32(b[120:])
+	ch.HeaderCheckSum = e.Uint32(b[124:])
+}
@@ -0,0 +1,60 @@
+package evtx
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"rawsec-evtx/encoding"
+)
+
+// the slice reader decodes like the reflection over a bytes.Reader, partial
+// structures and errors included
+func TestSliceReaderUnmarshal(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, newValue := range []func() interface{}{
+		func() interface{} { return new(EventHeader) },
+		func() interface{} { return new(ChunkHeader) },
+		func() interface{} { return new(FragmentHeader) },
+		func() interface{} { return new(CharEntityRef) },
+		func() interface{} { return new(TemplateDefinitionHeader) },
+		func() interface{} { return new(ValueDescriptor) },
+		func() interface{} { return new(ValueType) },
+		func() interface{} { return new(int16) },
+		func() interface{} { return new(uint64) },
+		func() interface{} { return new(GUID) },
+		func() interface{} { return &UTF16String{0, 0, 0} },
+		func() interface{} { return &[]uint8{0, 0, 0, 0, 0} },
+	} {
+		size := 0
+		if fu, ok := newValue().(encoding.FixedUnmarshaler); ok {
+			size = fu.FixedSize()
+		}
+		for n := 0; n <= size+16; n++ {
+			data := make([]byte, n)
+			rnd.Read(data)
+
+			want, got := newValue(), newValue()
+			br, sr := bytes.NewReader(data), encoding.NewSliceReader(data)
+			wantErr, gotErr := unmarshal(br, want), unmarshal(sr, got)
+			if !reflect.DeepEqual(want, got) || wantErr != gotErr {
+				t.Errorf("%T from %d bytes: got %+v (%v), want %+v (%v)", want, n, got, gotErr, want, wantErr)
+			}
+			wantOff, _ := br.Seek(0, io.SeekCurrent)
+			gotOff, _ := sr.Seek(0, io.SeekCurrent)
+			if wantOff != gotOff {
+				t.Errorf("%T from %d bytes: read %d bytes instead of %d", want, n, gotOff, wantOff)
+			}
+		}
+	}
+}
+
+func unmarshal(reader io.Reader, data interface{}) error {
+	switch data.(type) {
+	case *UTF16String, *[]uint8:
+		return encoding.UnmarshaInitSlice(reader, data, Endianness)
+	}
+	return encoding.Unmarshal(reader, data, Endianness)
+}
@@ -0,0 +1,426 @@
+package evtx
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"rawsec-evtx/encoding"
+)
+
+// fixtures are built token by token on top of the chunkBuilder used by the
+// Writer so that they can hold constructs the Writer never emits
+
+type fxItem interface {
+	encode(cb *chunkBuilder)
+}
+
+type fxText string
+
+func (t fxText) encode(cb *chunkBuilder) {
+	u := utf16.Encode([]rune(string(t)))
+	cb.put(TokenValue1, StringType)
+	cb.putUint16(uint16(len(u)))
+	for _, c := range u {
+		cb.putUint16(c)
+	}
+}
+
+type fxSub struct {
+	id       uint16
+	typ      ValueType
+	optional bool
+}
+
+func (s fxSub) encode(cb *chunkBuilder) {
+	token := byte(TokenNormalSubstitution)
+	if s.optional {
+		token = TokenOptionalSubstitution
+	}
+	cb.put(token)
+	cb.putUint16(s.id)
+	cb.put(uint8(s.typ))
+}
+
+type fxEntity string
+
+func (e fxEntity) encode(cb *chunkBuilder) {
+	cb.put(TokenEntityRef1)
+	cb.name(string(e))
+}
+
+type fxCharRef uint16
+
+func (r fxCharRef) encode(cb *chunkBuilder) {
+	cb.put(TokenCharRef1)
+	cb.putUint16(uint16(r))
+}
+
+type fxAttr struct {
+	name  string
+	value fxItem
+}
+
+type fxElement struct {
+	name    string
+	attrs   []fxAttr
+	content []fxItem
+}
+
+func (e *fxElement) encode(cb *chunkBuilder) {
+	token := byte(TokenOpenStartElementTag1)
+	if len(e.attrs) > 0 {
+		token = TokenOpenStartElementTag2
+	}
+	cb.put(token)
+	cb.putUint16(0xffff)
+	sizePos := cb.reserve()
+	cb.name(e.name)
+
+	if len(e.attrs) > 0 {
+		listPos := cb.reserve()
+		start := cb.pos
+		for i, attr := range e.attrs {
+			if i == len(e.attrs)-1 {
+				cb.put(TokenAttribute1)
+			} else {
+				cb.put(TokenAttribute2)
+			}
+			cb.name(attr.name)
+			attr.value.encode(cb)
+		}
+		cb.patch(listPos, uint32(cb.pos-start))
+	}
+
+	if len(e.content) == 0 {
+		cb.put(TokenCloseEmptyElementTag)
+	} else {
+		cb.put(TokenCloseStartElementTag)
+		for _, c := range e.content {
+			c.encode(cb)
+		}
+		cb.put(TokenEndElementTag)
+	}
+	cb.patch(sizePos, uint32(cb.pos-sizePos-4))
+}
+
+type fxTemplate struct {
+	guid GUID
+	root *fxElement
+}
+
+type fxValue struct {
+	name   string
+	typ    ValueType
+	data   []byte
+	nested *fxInstance
+}
+
+func (v fxValue) encode(cb *chunkBuilder) {
+	if v.nested != nil {
+		cb.put(FragmentHeaderToken, 1, 1, 0)
+		v.nested.encode(cb, false)
+		return
+	}
+	cb.put(v.data...)
+}
+
+type fxInstance struct {
+	tmpl   *fxTemplate
+	values []fxValue
+}
+
+// encode writes the template instance, shared templates are defined once per
+// chunk and referenced from the template table afterwards
+func (i *fxInstance) encode(cb *chunkBuilder, shared bool) {
+	cb.put(TokenTemplateInstance, 1)
+	cb.put(i.tmpl.guid[:4]...)
+	if offset, ok := cb.templates[i.tmpl.guid]; shared && ok {
+		cb.putUint32(uint32(offset))
+	} else {
+		offset := int32(cb.pos + 4)
+		cb.putUint32(uint32(offset))
+		if shared {
+			cb.templates[i.tmpl.guid] = offset
+			cb.tmplList = append(cb.tmplList, offset)
+		}
+		cb.putUint32(0)
+		cb.put(i.tmpl.guid[:]...)
+		sizePos := cb.reserve()
+		start := cb.pos
+		cb.put(FragmentHeaderToken, 1, 1, 0)
+		i.tmpl.root.encode(cb)
+		cb.put(TokenEOF)
+		cb.patch(sizePos, uint32(cb.pos-start))
+	}
+	i.encodeData(cb)
+}
+
+func (i *fxInstance) encodeData(cb *chunkBuilder) {
+	cb.putUint32(uint32(len(i.values)))
+	descs := make([]int, len(i.values))
+	for j := range i.values {
+		descs[j] = cb.reserve()
+	}
+	for j, v := range i.values {
+		start := cb.pos
+		v.encode(cb)
+		cb.patch(descs[j], uint32(cb.pos-start)|uint32(v.typ)<<16)
+	}
+}
+
+type fxRecord struct {
+	id      int64
+	created time.Time
+	inst    *fxInstance
+}
+
+// fxFile lays out one chunk per slice of records behind a file header, header
+// is called before the header checksum is computed
+func fxFile(t testing.TB, header func(*FileHeader), chunks ...[]fxRecord) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	buf.Write(make([]byte, DefaultChunkOffset))
+	next := int64(1)
+	for _, records := range chunks {
+		cb := newChunkBuilder()
+		for _, r := range records {
+			inst := r.inst
+			if !cb.record(r.id, r.created, func() { inst.encode(cb, true) }) {
+				t.Fatalf("record %d does not fit in its chunk", r.id)
+			}
+			next = r.id + 1
+		}
+		data, err := cb.finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(data)
+	}
+
+	fh := FileHeader{
+		NextRecordID:    uint64(next),
+		HeaderSpace:     FileHeaderSize,
+		MinVersion:      1,
+		MajVersion:      3,
+		ChunkDataOffset: DefaultChunkOffset,
+		ChunkCount:      uint16(len(chunks)),
+		LastChunkNum:    uint64(len(chunks) - 1),
+	}
+	copy(fh.Magic[:], "ElfFile\x00")
+	if header != nil {
+		header(&fh)
+	}
+	var err error
+	if fh.CheckSum, err = fh.ComputeCheckSum(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := encoding.Marshal(&fh, Endianness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	copy(data, b)
+	return data
+}
+
+func fxLE(t testing.TB, data interface{}) []byte {
+	b := new(bytes.Buffer)
+	if err := writeLE(b, data); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func fxGUID(t testing.TB, s string) []byte {
+	g, err := ParseGUID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g[:]
+}
+
+func fxSID(t testing.TB, s string) []byte {
+	b, err := encodeSID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func fxFileTime(t time.Time) int64 {
+	return t.UnixNano()/100 + fileTimeEpochOffset
+}
+
+var (
+	fxCreated     = time.Date(2024, 2, 29, 12, 34, 56, 123456700, time.UTC)
+	fxValuesGUID  = GUID{0x01, 0x02, 0x03, 0x04, 'v', 'a', 'l', 'u', 'e', 's'}
+	fxTextGUID    = GUID{0x11, 0x12, 0x13, 0x14, 't', 'e', 'x', 't'}
+	fxNestedGUID  = GUID{0x21, 0x22, 0x23, 0x24, 'n', 'e', 's', 't', 'e', 'd'}
+	fxProviderKey = "{54849625-5478-4994-A5BA-3E3B0328C30D}"
+)
+
+// fxTypedValues holds one value of every ValueType, arrays and nested BinXML
+// included
+func fxTypedValues(t testing.TB) []fxValue {
+	nested := &fxInstance{
+		tmpl: &fxTemplate{fxNestedGUID, &fxElement{
+			name:  "Nested",
+			attrs: []fxAttr{{"Id", fxSub{0, UInt32Type, true}}},
+			content: []fxItem{
+				&fxElement{name: "Inner", content: []fxItem{fxSub{1, StringType, true}}},
+			},
+		}},
+		values: []fxValue{
+			{typ: UInt32Type, data: fxLE(t, uint32(42))},
+			{typ: StringType, data: utf16LE("nested <value>")},
+		},
+	}
+	return []fxValue{
+		{"Null", NullType, nil, nil},
+		{"String", StringType, utf16LE("héllo wörld"), nil},
+		{"AnsiString", AnsiStringType, []byte("ansi string"), nil},
+		{"Int8", Int8Type, fxLE(t, int8(-8)), nil},
+		{"UInt8", UInt8Type, fxLE(t, uint8(200)), nil},
+		{"Int16", Int16Type, fxLE(t, int16(-1600)), nil},
+		{"UInt16", UInt16Type, fxLE(t, uint16(0xbeef)), nil},
+		{"Int32", Int32Type, fxLE(t, int32(-320000)), nil},
+		{"UInt32", UInt32Type, fxLE(t, uint32(4000000000)), nil},
+		{"Int64", Int64Type, fxLE(t, int64(-6400000000)), nil},
+		{"UInt64", UInt64Type, fxLE(t, uint64(18000000000000000000)), nil},
+		{"Real64", Real64Type, fxLE(t, math.Float64bits(3.25)), nil},
+		{"Bool", BoolType, fxLE(t, int32(1)), nil},
+		{"Binary", BinaryType, []byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x01}, nil},
+		{"Guid", GuidType, fxGUID(t, fxProviderKey), nil},
+		{"FileTime", FileTimeType, fxLE(t, fxFileTime(fxCreated)), nil},
+		{"SysTime", SysTimeType, fxLE(t, []int16{2024, 2, 4, 29, 12, 34, 56, 789}), nil},
+		{"Sid", SidType, fxSID(t, "S-1-5-21-1004336348-1177238915-682003330-512"), nil},
+		{"HexInt32", HexInt32Type, fxLE(t, uint32(0x1f4)), nil},
+		{"HexInt64", HexInt64Type, fxLE(t, uint64(0x8020000000000000)), nil},
+		{"StringArray", StringType | ArrayType, utf16LE("one\x00two\x00three\x00"), nil},
+		{"UInt16Array", UInt16Type | ArrayType, fxLE(t, []uint16{1, 2, 3}), nil},
+		{"UInt64Array", UInt64Type | ArrayType, fxLE(t, []uint64{10, 20}), nil},
+		{"BinXml", BinXmlType, nil, nested},
+	}
+}
+
+var fxXmlns = fxAttr{"xmlns", fxText(xmlnsEvent)}
+
+// fxValuesInstance renders the typed values as EventData, with nulls the
+// optional substitutions of System are dropped
+func fxValuesInstance(t testing.TB, id int64, nulls bool) *fxInstance {
+	system := []fxValue{
+		{typ: StringType, data: utf16LE("Fixture-Provider")},
+		{typ: GuidType, data: fxGUID(t, fxProviderKey)},
+		{typ: UInt16Type, data: fxLE(t, uint16(0x4000))},
+		{typ: UInt16Type, data: fxLE(t, uint16(4624))},
+		{typ: UInt8Type, data: fxLE(t, uint8(4))},
+		{typ: HexInt64Type, data: fxLE(t, uint64(0x8020000000000000))},
+		{typ: FileTimeType, data: fxLE(t, fxFileTime(fxCreated.Add(time.Duration(id)*time.Second)))},
+		{typ: UInt64Type, data: fxLE(t, uint64(id))},
+		{typ: StringType, data: utf16LE("Security")},
+		{typ: StringType, data: utf16LE("fixture.example.org")},
+		{typ: SidType, data: fxSID(t, "S-1-5-18")},
+	}
+	if nulls {
+		for _, i := range []int{1, 2, 10} {
+			system[i] = fxValue{typ: NullType}
+		}
+	}
+
+	values := fxTypedValues(t)
+	data := make([]fxItem, len(values))
+	for i, v := range values {
+		data[i] = &fxElement{
+			name:    "Data",
+			attrs:   []fxAttr{{"Name", fxText(v.name)}},
+			content: []fxItem{fxSub{uint16(len(system) + i), v.typ, true}},
+		}
+	}
+
+	root := &fxElement{
+		name:  "Event",
+		attrs: []fxAttr{fxXmlns},
+		content: []fxItem{
+			&fxElement{name: "System", content: []fxItem{
+				&fxElement{name: "Provider", attrs: []fxAttr{
+					{"Name", fxSub{0, StringType, true}},
+					{"Guid", fxSub{1, GuidType, true}},
+				}},
+				&fxElement{name: "EventID",
+					attrs:   []fxAttr{{"Qualifiers", fxSub{2, UInt16Type, true}}},
+					content: []fxItem{fxSub{3, UInt16Type, false}}},
+				&fxElement{name: "Level", content: []fxItem{fxSub{4, UInt8Type, false}}},
+				&fxElement{name: "Keywords", content: []fxItem{fxSub{5, HexInt64Type, false}}},
+				&fxElement{name: "TimeCreated", attrs: []fxAttr{{"SystemTime", fxSub{6, FileTimeType, false}}}},
+				&fxElement{name: "EventRecordID", content: []fxItem{fxSub{7, UInt64Type, false}}},
+				&fxElement{name: "Channel", content: []fxItem{fxSub{8, StringType, false}}},
+				&fxElement{name: "Computer", content: []fxItem{fxSub{9, StringType, false}}},
+				&fxElement{name: "Security", attrs: []fxAttr{{"UserID", fxSub{10, SidType, true}}}},
+			}},
+			&fxElement{name: "EventData", content: data},
+		},
+	}
+	return &fxInstance{&fxTemplate{fxValuesGUID, root}, append(system, values...)}
+}
+
+// fxTextInstance holds static text mixed with entity and character references
+func fxTextInstance(t testing.TB, id int64) *fxInstance {
+	root := &fxElement{
+		name:  "Event",
+		attrs: []fxAttr{fxXmlns},
+		content: []fxItem{
+			&fxElement{name: "System", content: []fxItem{
+				&fxElement{name: "Provider", attrs: []fxAttr{{"Name", fxText("Fixture-Text")}}},
+				&fxElement{name: "EventID", content: []fxItem{fxText("1")}},
+				&fxElement{name: "TimeCreated", attrs: []fxAttr{{"SystemTime", fxSub{0, FileTimeType, false}}}},
+				&fxElement{name: "EventRecordID", content: []fxItem{fxSub{1, UInt64Type, false}}},
+			}},
+			&fxElement{name: "EventData", content: []fxItem{
+				&fxElement{name: "Data", attrs: []fxAttr{{"Name", fxText("Text")}}, content: []fxItem{
+					fxText("a "), fxEntity("amp"), fxText(" b "), fxEntity("lt"), fxText("c"),
+					fxEntity("gt"), fxText(" "), fxCharRef('A'), fxEntity("quot"), fxEntity("apos"),
+				}},
+				&fxElement{name: "Data", attrs: []fxAttr{{"Name", fxText("Normal")}}, content: []fxItem{
+					fxSub{2, StringType, false},
+				}},
+			}},
+		},
+	}
+	return &fxInstance{&fxTemplate{fxTextGUID, root}, []fxValue{
+		{typ: FileTimeType, data: fxLE(t, fxFileTime(fxCreated.Add(time.Duration(id)*time.Second)))},
+		{typ: UInt64Type, data: fxLE(t, uint64(id))},
+		{typ: StringType, data: utf16LE("normal substitution")},
+	}}
+}
+
+// fxChunks spans two chunks so that the values template is defined in both
+func fxChunks(t testing.TB) [][]fxRecord {
+	return [][]fxRecord{
+		{
+			{1, fxCreated.Add(1 * time.Second), fxValuesInstance(t, 1, false)},
+			{2, fxCreated.Add(2 * time.Second), fxValuesInstance(t, 2, true)},
+			{3, fxCreated.Add(3 * time.Second), fxTextInstance(t, 3)},
+		},
+		{
+			{4, fxCreated.Add(4 * time.Second), fxValuesInstance(t, 4, false)},
+		},
+	}
+}
+
+func fxCleanFile(t testing.TB) []byte {
+	return fxFile(t, nil, fxChunks(t)...)
+}
+
+// fxDirtyFile was not closed properly, the header is flagged dirty and does
+// not account for the last chunk
+func fxDirtyFile(t testing.TB) []byte {
+	return fxFile(t, func(fh *FileHeader) {
+		fh.Flags = 1
+		fh.ChunkCount = 1
+		fh.LastChunkNum = 0
+		fh.NextRecordID = 4
+	}, fxChunks(t)...)
+}
@@ -0,0 +1,85 @@
+package evtx
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+var (
+	DefaultFollowInterval = time.Second
+)
+
+func (ef *File) SetMonitorExisting(monitor bool) {
+	ef.monitorExisting = monitor
+}
+
+func (ef *File) liveChunkOffset() int64 {
+	return int64(ef.Header.ChunkDataOffset) + int64(ChunkSize)*int64(ef.Header.LastChunkNum)
+}
+
+func (ef *File) refreshHeader() error {
+	if err := ef.ParseFileHeader(); err != nil {
+		return err
+	}
+
+	ef.Lock()
+	size, err := ef.file.Seek(0, io.SeekEnd)
+	ef.Unlock()
+	if err != nil {
+		return err
+	}
+	// the header of a file being written is not always up to date
+	if count := (size - int64(ef.Header.ChunkDataOffset)) / ChunkSize; count > int64(ef.Header.ChunkCount) {
+		ef.Header.ChunkCount = uint16(count)
+	}
+	return nil
+}
+
+func (ef *File) Follow(ctx context.Context) (cgem chan *GoEvtxMap) {
+	return eventsOf(ctx, ef.FollowRecords(ctx))
+}
+
+func (ef *File) FollowRecords(ctx context.Context) (cr chan *Record) {
+	cr = make(chan *Record, 42)
+	go func() {
+		defer close(cr)
+		emit := func(r *Record) bool {
+			select {
+			case cr <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		interval := ef.FollowInterval
+		if interval <= 0 {
+			interval = DefaultFollowInterval
+		}
+
+		last := int64(-1)
+		if err := ef.refreshHeader(); err != nil {
+			emit(&Record{ID: -1, Err: err})
+			return
+		}
+		if !ef.monitorExisting {
+			last = ef.orderedRecords(ctx, last, true, func(*Record) bool { return true })
+		}
+
+		for {
+			last = ef.orderedRecords(ctx, last, true, emit)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			// the file may be in the middle of being rewritten
+			if err := ef.refreshHeader(); err != nil && !emit(&Record{ID: -1, Err: err}) {
+				return
+			}
+		}
+	}()
+	return
+}
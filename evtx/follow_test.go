@@ -0,0 +1,137 @@
+package evtx
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// followedFile signals every refresh of the header of the file followed, the
+// end of a pass over the chunks
+type followedFile struct {
+	*os.File
+	refreshed chan struct{}
+}
+
+func (f *followedFile) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		select {
+		case f.refreshed <- struct{}{}:
+		default:
+		}
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func fxSequence(t *testing.T, first, last int64) (records []fxRecord) {
+	for id := first; id <= last; id++ {
+		records = append(records, fxRecord{id, fxCreated.Add(time.Duration(id) * time.Second), fxTextInstance(t, id)})
+	}
+	return
+}
+
+func followFile(t *testing.T, monitorExisting bool, data []byte) (*File, *followedFile) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "follow.evtx")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	ff := &followedFile{f, make(chan struct{}, 1)}
+	ef, err := New(ff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef.FollowInterval = 5 * time.Millisecond
+	ef.SetMonitorExisting(monitorExisting)
+	return &ef, ff
+}
+
+// rewrite writes data over the file being followed, as the event log service
+// does it never shrinks
+func (f *followedFile) rewrite(t *testing.T, data []byte) {
+	t.Helper()
+	if _, err := f.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitPass waits for the follower to be done with the file as it is
+func (f *followedFile) waitPass(t *testing.T) {
+	t.Helper()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-f.refreshed:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for the follower")
+		}
+	}
+}
+
+func expectRecords(t *testing.T, records chan *Record, first, last int64) {
+	t.Helper()
+	for id := first; id <= last; id++ {
+		select {
+		case r, ok := <-records:
+			switch {
+			case !ok:
+				t.Fatalf("records closed, expected record %d", id)
+			case r.Err != nil:
+				t.Fatalf("expected record %d, got %v", id, r.Err)
+			case r.ID != id:
+				t.Fatalf("record %d, expected %d", r.ID, id)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for record %d", id)
+		}
+	}
+}
+
+func TestFollowRecords(t *testing.T) {
+	ef, ff := followFile(t, true, fxFile(t, nil, fxSequence(t, 1, 3)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records := ef.FollowRecords(ctx)
+	expectRecords(t, records, 1, 3)
+
+	// appended to the chunk being written
+	ff.rewrite(t, fxFile(t, nil, fxSequence(t, 1, 5)))
+	expectRecords(t, records, 4, 5)
+
+	// in a new chunk
+	ff.rewrite(t, fxFile(t, nil, fxSequence(t, 1, 5), fxSequence(t, 6, 8)))
+	expectRecords(t, records, 6, 8)
+
+	// the log wraps, the first chunk is written again
+	ff.rewrite(t, fxFile(t, func(fh *FileHeader) {
+		fh.FirstChunkNum = 1
+		fh.LastChunkNum = 0
+	}, fxSequence(t, 9, 11), fxSequence(t, 6, 8)))
+	expectRecords(t, records, 9, 11)
+
+	cancel()
+	for range records {
+	}
+}
+
+func TestFollowNewRecords(t *testing.T) {
+	ef, ff := followFile(t, false, fxFile(t, nil, fxSequence(t, 1, 3)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records := ef.FollowRecords(ctx)
+	ff.waitPass(t)
+
+	ff.rewrite(t, fxFile(t, nil, fxSequence(t, 1, 3), fxSequence(t, 4, 6)))
+	expectRecords(t, records, 4, 6)
+
+	cancel()
+	for range records {
+	}
+}
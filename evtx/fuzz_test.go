@@ -0,0 +1,206 @@
+package evtx
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// The inputs found interesting are minimized for up to a minute by default,
+// which stalls the targets seeded with chunks early on. Run them with a
+// shorter minimization, go test -fuzz FuzzParse -fuzzminimizetime 1s for
+// instance.
+
+// fuzzLimits keep the inputs mutated from spending the time of the fuzzer
+// in allocations and deep recursions
+var fuzzLimits = Limits{
+	MaxDepth:      16,
+	MaxElements:   1024,
+	MaxValues:     256,
+	MaxChunkAlloc: 1 << 20,
+}
+
+// fuzzChunk reads data the way chunks of a file are read, limits enforced
+func fuzzChunk(data []byte) (*Chunk, *budgetReader) {
+	c := NewChunk()
+	c.Data = data
+	c.Limits = fuzzLimits
+	// chunks which are not mapped are always readable
+	reader, _ := c.reader()
+	return &c, reader
+}
+
+// fuzzChunks returns the chunks of the synthetic fixtures, the seed corpus of
+// every target, their data stops at their free space since the fuzzer
+// spends its time minimizing large inputs
+func fuzzChunks(f *testing.F) (chunks []Chunk) {
+	data := fxCleanFile(f)
+	for offset := DefaultChunkOffset; offset+ChunkSize <= len(data); offset += ChunkSize {
+		c, err := ParseChunk(data[offset:offset+ChunkSize], int64(offset), CheckSumStrict)
+		if err != nil {
+			f.Fatal(err)
+		}
+		c.Data = c.Data[:c.Header.Freespace]
+		chunks = append(chunks, c)
+	}
+	return
+}
+
+// fuzzFile lays the records out in a single chunk cut at its free space
+func fuzzFile(f *testing.F, header func(*FileHeader)) []byte {
+	data := fxFile(f, header, fxChunks(f)[0])
+	c, err := ParseChunk(data[DefaultChunkOffset:], DefaultChunkOffset, CheckSumStrict)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return data[:DefaultChunkOffset+int(c.Header.Freespace)]
+}
+
+func FuzzParse(f *testing.F) {
+	for _, c := range fuzzChunks(f) {
+		for _, eo := range c.EventOffsets {
+			if eo <= c.Header.OffsetLastRec {
+				f.Add(c.Data, uint16(eo+EventHeaderSize), false)
+			}
+		}
+		for offset := range c.TemplateTable {
+			// template definitions are parsed from their fragment header
+			f.Add(c.Data, uint16(offset+24), true)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte, offset uint16, tiFlag bool) {
+		c, reader := fuzzChunk(data)
+		defer reader.release()
+		GoToSeeker(reader, int64(offset))
+		elt, err := Parse(reader, c, tiFlag)
+		if err != nil {
+			return
+		}
+		switch elt.(type) {
+		case *Fragment:
+			_, _ = elt.(*Fragment).GoEvtxMap()
+			_, _ = elt.(*Fragment).XML()
+			_, _ = elt.(*Fragment).System()
+		}
+	})
+}
+
+func FuzzParseValueReader(f *testing.F) {
+	for _, v := range fxTypedValues(f) {
+		cb := newChunkBuilder()
+		start := cb.pos
+		v.encode(cb)
+		f.Add(cb.data[:cb.pos], uint16(start), uint8(v.typ), uint16(cb.pos-start))
+	}
+	f.Fuzz(func(t *testing.T, data []byte, offset uint16, typ uint8, size uint16) {
+		reader := newBudgetReader(data, fuzzLimits)
+		defer reader.release()
+		GoToSeeker(reader, int64(offset))
+		elt, err := ParseValueReader(ValueDescriptor{Size: size, ValType: ValueType(typ)}, reader)
+		if err != nil {
+			return
+		}
+		if v, ok := elt.(Value); ok {
+			_ = v.String()
+			_ = v.Repr()
+			_ = ToJSON(v.Repr())
+		}
+	})
+}
+
+func FuzzChunkStringTable(f *testing.F) {
+	for _, c := range fuzzChunks(f) {
+		f.Add(c.Data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, reader := fuzzChunk(data)
+		defer reader.release()
+		GoToSeeker(reader, ChunkHeaderSize)
+		_ = c.ParseStringTable(reader)
+	})
+}
+
+func FuzzChunkTemplateTable(f *testing.F) {
+	for _, c := range fuzzChunks(f) {
+		f.Add(c.Data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, reader := fuzzChunk(data)
+		defer reader.release()
+		GoToSeeker(reader, ChunkHeaderSize+sizeStringBucket*4)
+		_ = c.ParseTemplateTable(reader)
+	})
+}
+
+func FuzzTemplateInstanceData(f *testing.F) {
+	for _, inst := range []*fxInstance{fxValuesInstance(f, 1, false), fxValuesInstance(f, 2, true), fxTextInstance(f, 3)} {
+		cb := newChunkBuilder()
+		start := cb.pos
+		inst.encodeData(cb)
+		f.Add(cb.data[:cb.pos], uint16(start))
+	}
+	f.Fuzz(func(t *testing.T, data []byte, offset uint16) {
+		reader := newBudgetReader(data, fuzzLimits)
+		defer reader.release()
+		GoToSeeker(reader, int64(offset))
+		tid := TemplateInstanceData{}
+		_ = tid.Parse(reader)
+	})
+}
+
+// FuzzFile goes through the whole pipeline, from the file header down to the
+// rendering of the events
+func FuzzFile(f *testing.F) {
+	f.Add(fuzzFile(f, nil))
+	f.Add(fuzzFile(f, func(fh *FileHeader) {
+		fh.Flags = 1
+		fh.NextRecordID = 2
+	}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ef, err := NewWithOptions(bytes.NewReader(data), Options{Workers: 1, CheckSumMode: CheckSumLenient, Limits: fuzzLimits})
+		if err != nil {
+			return
+		}
+		for r := range ef.Records() {
+			if r.Err == nil {
+				_, _ = r.XML()
+				_ = r.GoEvtxMap()
+			}
+		}
+		for range ef.UnorderedEventsContext(context.Background()) {
+		}
+	})
+}
+
+func FuzzCarveRecord(f *testing.F) {
+	for _, c := range fuzzChunks(f) {
+		for _, eo := range c.EventOffsets {
+			if eo <= c.Header.OffsetLastRec {
+				size := Endianness.Uint32(c.Data[eo+4:])
+				f.Add(c.Data[eo : uint32(eo)+size])
+			}
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ce, err := NewCarver(bytes.NewReader(data), int64(len(data))).CarveRecord(0)
+		if err == nil && ce.Event != nil {
+			_ = ToJSON(ce.Event)
+		}
+	})
+}
+
+func FuzzRecoveredEvents(f *testing.F) {
+	for _, c := range fuzzChunks(f) {
+		f.Add(c.Data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := NewChunk()
+		c.Data = data
+		c.Limits = fuzzLimits
+		for _, ce := range c.RecoveredEvents() {
+			if ce.Event != nil {
+				_ = ToJSON(ce.Event)
+			}
+		}
+	})
+}
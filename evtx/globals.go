@@ -8,9 +8,10 @@ import (
 )
 
 var (
-	ErrInvalidEvent = errors.New("invalid Event")
-	MaxJobs         = int(math.Floor(float64(runtime.NumCPU()) / 2))
-	Endianness      = binary.LittleEndian
+	ErrInvalidEvent  = errors.New("invalid Event")
+	ErrBadChunkMagic = errors.New("bad chunk magic")
+	MaxJobs          = int(math.Floor(float64(runtime.NumCPU()) / 2))
+	Endianness       = binary.LittleEndian
 )
 
 const (
@@ -22,6 +23,7 @@ const (
 	sizeTemplateBucket = 0x20
 	DefaultNameOffset  = -1
 	EventMagic         = "\x2a\x2a\x00\x00"
+	RecordMetadataKey  = "EvtxRecord"
 	MaxSliceSize       = ChunkSize
 )
 
@@ -24,6 +24,14 @@ func (e *ErrEvtxEltNotFound) Error() string {
 	return fmt.Sprintf("Element at path %v not found", e.path)
 }
 
+type ErrDuplicatedKey struct {
+	key string
+}
+
+func (e *ErrDuplicatedKey) Error() string {
+	return fmt.Sprintf("Duplicated key %s", e.key)
+}
+
 func Path(s string) GoEvtxPath {
 	return strings.Split(strings.Trim(s, PathSeparator), PathSeparator)
 }
@@ -37,13 +45,14 @@ func (pg *GoEvtxMap) HasKeys(keys ...string) bool {
 	return true
 }
 
-func (pg *GoEvtxMap) Add(other GoEvtxMap) {
+func (pg *GoEvtxMap) Add(other GoEvtxMap) error {
 	for k, v := range other {
 		if _, ok := (*pg)[k]; ok {
-			panic("Duplicated key")
+			return &ErrDuplicatedKey{k}
 		}
 		(*pg)[k] = v
 	}
+	return nil
 }
 
 func (pg *GoEvtxMap) GetMap(path *GoEvtxPath) (*GoEvtxMap, error) {
@@ -0,0 +1,255 @@
+package evtx
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, check the diff and run go test -update if it is expected\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
+
+func fxOpen(t *testing.T, data []byte) *File {
+	t.Helper()
+	ef, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef.CheckSumMode = CheckSumStrict
+	return &ef
+}
+
+func TestFileGolden(t *testing.T) {
+	ef := fxOpen(t, fxCleanFile(t))
+	if err := ef.Header.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ef.Header.VerifyCheckSum(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := new(bytes.Buffer)
+	fmt.Fprint(w, ef.Header)
+	for r := range ef.Records() {
+		if r.Err != nil {
+			t.Errorf("record %d: %s", r.ID, r.Err)
+			continue
+		}
+		x, err := r.XML()
+		if err != nil {
+			t.Errorf("record %d: %s", r.ID, err)
+		}
+		fmt.Fprintf(w, "\n%s\n%s\n%s\n", ToJSON(r.Metadata()), ToJSON(r.Event), x)
+	}
+	checkGolden(t, "file", w.Bytes())
+}
+
+func TestFileDirty(t *testing.T) {
+	data := fxDirtyFile(t)
+	path := filepath.Join(t.TempDir(), "dirty.evtx")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ef, err := Open(path)
+	if err != ErrDirtyFile {
+		t.Fatalf("expected %v, got %v", ErrDirtyFile, err)
+	}
+	ef.Close()
+
+	// the stale header only covers the first chunk
+	if n := len(collectIDs(fxOpen(t, data).Records())); n != 3 {
+		t.Errorf("expected 3 records before repair, got %d", n)
+	}
+
+	repaired, err := OpenDirty(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repaired.Close()
+	if repaired.Header.ChunkCount != 2 || repaired.Header.Flags != 0 {
+		t.Errorf("header not repaired:\n%s", repaired.Header)
+	}
+	ids := collectIDs(repaired.Records())
+	if fmt.Sprint(ids) != "[1 2 3 4]" {
+		t.Errorf("unexpected records after repair: %v", ids)
+	}
+}
+
+func collectIDs(records chan *Record) (ids []int64) {
+	for r := range records {
+		if r.Err == nil {
+			ids = append(ids, r.ID)
+		}
+	}
+	return
+}
+
+func TestChunkGolden(t *testing.T) {
+	ef := fxOpen(t, fxCleanFile(t))
+	w := new(bytes.Buffer)
+	for _, offset := range ef.chunkOffsets() {
+		c, err := ef.FetchChunk(offset)
+		if err != nil {
+			t.Fatalf("chunk @ 0x%08x: %s", offset, err)
+		}
+		// keeps the NUL of the magic out of the golden file
+		header := strings.Replace(c.Header.String(), "\x00", `\x00`, -1)
+		fmt.Fprintf(w, "chunk %d @ 0x%08x\n%s", c.Index, c.Offset, header)
+
+		strOffsets := make([]int, 0, len(c.StringTable))
+		for o := range c.StringTable {
+			strOffsets = append(strOffsets, int(o))
+		}
+		sort.Ints(strOffsets)
+		fmt.Fprintln(w, "strings:")
+		for _, o := range strOffsets {
+			cs := c.StringTable[int32(o)]
+			fmt.Fprintf(w, "\t0x%04x hash=0x%04x %s\n", o, cs.Hash, cs.String())
+		}
+
+		tmplOffsets := make([]int, 0, len(c.TemplateTable))
+		for o := range c.TemplateTable {
+			tmplOffsets = append(tmplOffsets, int(o))
+		}
+		sort.Ints(tmplOffsets)
+		fmt.Fprintln(w, "templates:")
+		for _, o := range tmplOffsets {
+			td := c.TemplateTable[int32(o)]
+			guid := GUID(td.ID)
+			fmt.Fprintf(w, "\t0x%04x {%s} size=%d elements=%d\n", o, guid.String(), td.Size, len(td.Elements))
+		}
+		fmt.Fprintf(w, "events: %v\n\n", c.EventOffsets)
+	}
+	checkGolden(t, "chunk", w.Bytes())
+}
+
+func TestParseGolden(t *testing.T) {
+	ef := fxOpen(t, fxCleanFile(t))
+	w := new(bytes.Buffer)
+	for _, offset := range ef.chunkOffsets() {
+		c, err := ef.FetchChunk(offset)
+		if err != nil {
+			t.Fatalf("chunk @ 0x%08x: %s", offset, err)
+		}
+		for _, eo := range c.EventOffsets {
+			if eo > c.Header.OffsetLastRec {
+				break
+			}
+			e, err := c.ParseEvent(int64(eo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := bytes.NewReader(c.Data)
+			GoToSeeker(reader, int64(eo)+EventHeaderSize)
+			elt, err := Parse(reader, &c, false)
+			if err != nil && err != io.EOF {
+				t.Errorf("event %d: %s", e.Header.ID, err)
+			}
+			fmt.Fprintf(w, "event %d @ 0x%04x\n", e.Header.ID, eo)
+			dumpElement(w, elt, 1)
+		}
+	}
+	checkGolden(t, "parse", w.Bytes())
+}
+
+func dumpElement(w io.Writer, elt Element, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch elt.(type) {
+	case *Fragment:
+		fmt.Fprintf(w, "%sFragment\n", indent)
+		dumpElement(w, elt.(*Fragment).BinXMLElement, depth+1)
+	case *TemplateInstance:
+		ti := elt.(*TemplateInstance)
+		guid := GUID(ti.Definition.Data.ID)
+		fmt.Fprintf(w, "%sTemplateInstance {%s} offset=0x%04x\n", indent, guid.String(), ti.Definition.Header.DataOffset)
+		for _, e := range ti.Definition.Data.Elements {
+			dumpElement(w, e, depth+1)
+		}
+		for i, v := range ti.Data.Values {
+			fmt.Fprintf(w, "%s  value %d: %s\n", indent, i, ti.Data.ValDescs[i])
+			dumpElement(w, v, depth+2)
+		}
+	case *ElementStart:
+		es := elt.(*ElementStart)
+		fmt.Fprintf(w, "%sElementStart %s size=%d\n", indent, es.Name.String(), es.Size)
+		for _, attr := range es.AttributeList.Attributes {
+			fmt.Fprintf(w, "%s  Attribute %s\n", indent, attr.Name.String())
+			dumpElement(w, attr.AttributeData, depth+2)
+		}
+	case *ValueText:
+		fmt.Fprintf(w, "%sValueText %q\n", indent, elt.(*ValueText).String())
+	case *OptionalSubstitution:
+		s := elt.(*OptionalSubstitution)
+		fmt.Fprintf(w, "%sOptionalSubstitution %d type=0x%02x\n", indent, s.SubID, uint8(s.ValType))
+	case *NormalSubstitution:
+		s := elt.(*NormalSubstitution)
+		fmt.Fprintf(w, "%sNormalSubstitution %d type=0x%02x\n", indent, s.SubID, uint8(s.ValType))
+	case *BinXMLEntityReference:
+		fmt.Fprintf(w, "%sEntityReference %s\n", indent, elt.(*BinXMLEntityReference).Name.String())
+	case *CharEntityRef:
+		fmt.Fprintf(w, "%sCharEntityRef %d\n", indent, elt.(*CharEntityRef).Value)
+	case Value:
+		fmt.Fprintf(w, "%s%T %s\n", indent, elt, ToJSON(elt.(Value).Repr()))
+	default:
+		fmt.Fprintf(w, "%s%T\n", indent, elt)
+	}
+}
+
+func TestParseValueReaderGolden(t *testing.T) {
+	w := new(bytes.Buffer)
+	for _, v := range fxTypedValues(t) {
+		cb := newChunkBuilder()
+		start := cb.pos
+		v.encode(cb)
+		size := cb.pos - start
+		reader := bytes.NewReader(cb.data)
+		GoToSeeker(reader, int64(start))
+
+		vd := ValueDescriptor{Size: uint16(size), ValType: v.typ}
+		elt, err := ParseValueReader(vd, reader)
+		if err != nil {
+			t.Errorf("%s: %s", v.name, err)
+		}
+		if read := BackupSeeker(reader) - int64(start); read != int64(size) {
+			t.Errorf("%s: read %d bytes out of %d", v.name, read, size)
+		}
+
+		fmt.Fprintf(w, "%s %s\n", v.name, vd)
+		dumpElement(w, elt, 1)
+		if f, ok := elt.(*Fragment); ok {
+			x, err := f.XML()
+			if err != nil {
+				t.Errorf("%s: %s", v.name, err)
+			}
+			fmt.Fprintf(w, "  %s\n", x)
+		}
+	}
+	checkGolden(t, "values", w.Bytes())
+}
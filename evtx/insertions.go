@@ -0,0 +1,157 @@
+package evtx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Insertions returns the insertion strings of the event, %1 to %n of its
+// message: the values of the elements under EventData or UserData in
+// document order
+func (r *Record) Insertions() ([]string, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.chunk == nil {
+		return nil, ErrInvalidEvent
+	}
+	event, err := r.chunk.ParseEvent(r.offset)
+	if err != nil {
+		return nil, err
+	}
+	fragment, err := event.Fragment(r.chunk)
+	if err != nil {
+		return nil, err
+	}
+	return fragment.Insertions()
+}
+
+func (f *Fragment) Insertions() ([]string, error) {
+	ti, ok := f.BinXMLElement.(*TemplateInstance)
+	if !ok {
+		return nil, fmt.Errorf("fragment does not contain a template instance: %T", f.BinXMLElement)
+	}
+	return ti.Insertions()
+}
+
+func (ti *TemplateInstance) Insertions() ([]string, error) {
+	root := ti.Root()
+	event := root.child("Event")
+	if event == nil {
+		return nil, nil
+	}
+	var err error
+	insertions := make([]string, 0)
+	for _, c := range event.Child {
+		switch c.Start.Name.String() {
+		case "EventData":
+			for _, data := range c.Child {
+				// binary data of the classic events is not part of the message
+				if data.Start.Name.String() == "Binary" {
+					continue
+				}
+				if insertions, err = ti.insertions(data, insertions); err != nil {
+					return nil, err
+				}
+			}
+			for _, e := range c.Element {
+				if insertions, err = ti.embedded(e, insertions); err != nil {
+					return nil, err
+				}
+			}
+		case "UserData":
+			for _, data := range c.Child {
+				if insertions, err = ti.insertions(data, insertions); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return insertions, nil
+}
+
+// insertions appends the values of the leaves of n
+func (ti *TemplateInstance) insertions(n *Node, insertions []string) ([]string, error) {
+	if len(n.Child) > 0 {
+		var err error
+		for _, c := range n.Child {
+			if insertions, err = ti.insertions(c, insertions); err != nil {
+				return nil, err
+			}
+		}
+		return insertions, nil
+	}
+	var text strings.Builder
+	for _, e := range n.Element {
+		s, err := ti.text(e)
+		if err != nil {
+			return nil, err
+		}
+		text.WriteString(s)
+	}
+	return append(insertions, text.String()), nil
+}
+
+// embedded appends the insertions of a template given as a value
+func (ti *TemplateInstance) embedded(elt Element, insertions []string) ([]string, error) {
+	elt, _, err := ti.substitution(elt)
+	if err != nil {
+		return nil, err
+	}
+	var temp *TemplateInstance
+	switch elt.(type) {
+	case *Fragment:
+		var ok bool
+		if temp, ok = elt.(*Fragment).BinXMLElement.(*TemplateInstance); !ok {
+			return nil, fmt.Errorf("fragment does not contain a template instance: %T", elt.(*Fragment).BinXMLElement)
+		}
+	case *TemplateInstance:
+		temp = elt.(*TemplateInstance)
+	default:
+		return insertions, nil
+	}
+	root := temp.Root()
+	for _, c := range root.Child {
+		// embedded EventData or UserData elements are transparent
+		nodes := []*Node{c}
+		switch c.Start.Name.String() {
+		case "EventData", "UserData":
+			nodes = c.Child
+		}
+		for _, n := range nodes {
+			if insertions, err = temp.insertions(n, insertions); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return insertions, nil
+}
+
+// text renders an element like its XML content but unescaped, nested
+// templates excepted
+func (ti *TemplateInstance) text(elt Element) (string, error) {
+	elt, _, err := ti.substitution(elt)
+	if err != nil {
+		return "", err
+	}
+	if items, ok := xmlArrayItems(elt); ok {
+		return strings.Join(items, " "), nil
+	}
+	switch elt.(type) {
+	case *ValueText:
+		return elt.(*ValueText).String(), nil
+	case *BinXMLEntityReference:
+		return elt.(*BinXMLEntityReference).String(), nil
+	case *CharEntityRef:
+		return string(rune(uint16(elt.(*CharEntityRef).Value))), nil
+	case *Fragment:
+		xml, err := elt.(*Fragment).XML()
+		return string(xml), err
+	case *TemplateInstance:
+		xml, err := elt.(*TemplateInstance).XML()
+		return string(xml), err
+	case Value:
+		return xmlValue(elt.(Value)), nil
+	}
+	return "", fmt.Errorf("don't know how to render: %T", elt)
+}
@@ -0,0 +1,34 @@
+package evtx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInsertions(t *testing.T) {
+	ef := fxOpen(t, fxCleanFile(t))
+	got := make(map[int64][]string)
+	for r := range ef.Records() {
+		if r.Err != nil {
+			t.Fatalf("record %d: %s", r.ID, r.Err)
+		}
+		insertions, err := r.Insertions()
+		if err != nil {
+			t.Fatalf("record %d: %s", r.ID, err)
+		}
+		got[r.ID] = insertions
+	}
+
+	// typed values rendered like in XML, arrays items space separated
+	typed := []string{"", "héllo wörld", "ansi string", "-8", "200", "-1600", "48879", "-320000", "4000000000",
+		"-6400000000", "18000000000000000000", "3.250000", "true", "DEADBEEF0001", fxProviderKey,
+		"2024-02-29T12:34:56.1234567Z", "2024-02-29T12:34:56.7890000Z", "S-1-5-21-1004336348-1177238915-682003330-512",
+		"0x1f4", "0x8020000000000000", "one two three", "1 2 3", "10 20",
+		"<Nested Id='42'><Inner>nested &lt;value&gt;</Inner></Nested>"}
+	if !reflect.DeepEqual(got[1], typed) {
+		t.Errorf("unexpected insertions of record 1: %q", got[1])
+	}
+	if !reflect.DeepEqual(got[3], []string{`a & b <c> A"'`, "normal substitution"}) {
+		t.Errorf("unexpected insertions of record 3: %q", got[3])
+	}
+}
@@ -0,0 +1,176 @@
+package evtx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"rawsec-evtx/encoding"
+)
+
+const (
+	LimitDepth      = "depth"
+	LimitElements   = "elements"
+	LimitValues     = "values"
+	LimitChunkAlloc = "chunk allocation"
+)
+
+// Limits bounds the resources spent parsing hostile input, a zero field
+// disables the corresponding limit
+type Limits struct {
+	// nesting of fragments, template instances, BinXML values and elements
+	MaxDepth int
+	// BinXML tokens per event, templates taken from the chunk included
+	MaxElements int
+	// substitution values per event
+	MaxValues int
+	// bytes allocated from sizes read in the chunk, by the parse of an event
+	// or of the chunk tables
+	MaxChunkAlloc int64
+}
+
+var (
+	DefaultLimits = Limits{
+		MaxDepth:      64,
+		MaxElements:   0x10000,
+		MaxValues:     0x10000,
+		MaxChunkAlloc: 64 << 20,
+	}
+)
+
+type ErrLimitExceeded struct {
+	Limit string
+	Max   int64
+}
+
+func (e ErrLimitExceeded) Error() string {
+	return fmt.Sprintf("%s limit exceeded (max %d)", e.Limit, e.Max)
+}
+
+func IsLimitError(err error) bool {
+	// errors.As would allocate for every io.EOF ending a fragment
+	for ; err != nil; err = errors.Unwrap(err) {
+		if _, ok := err.(ErrLimitExceeded); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// budget tracks what parsing an event used so far, it travels with the
+// reader so that nested parsers share it without changing their signature
+type budget struct {
+	limits   Limits
+	depth    int
+	elements int
+	values   int
+	alloc    int64
+}
+
+type budgetReader struct {
+	*encoding.SliceReader
+	budget    *budget
+	templates *TemplateCache
+	mapping   chunkSlicer
+}
+
+var budgetReaders = sync.Pool{
+	New: func() interface{} {
+		return &budgetReader{SliceReader: new(encoding.SliceReader), budget: new(budget)}
+	},
+}
+
+// newBudgetReader starts a parse with a whole budget, parsing the same data
+// again does not accumulate
+func newBudgetReader(data []byte, limits Limits) *budgetReader {
+	br := budgetReaders.Get().(*budgetReader)
+	br.Reset(data)
+	*br.budget = budget{limits: limits}
+	return br
+}
+
+// release gives the reader back once parsing is over, nothing parsed refers
+// to it
+func (br *budgetReader) release() {
+	br.Reset(nil)
+	br.templates = nil
+	if br.mapping != nil {
+		br.mapping.release()
+		br.mapping = nil
+	}
+	budgetReaders.Put(br)
+}
+
+// budgetOf returns nil for plain readers, the budget methods accept it
+func budgetOf(reader io.ReadSeeker) *budget {
+	if br, ok := reader.(*budgetReader); ok {
+		return br.budget
+	}
+	return nil
+}
+
+func (b *budget) enter() error {
+	if b == nil {
+		return nil
+	}
+	b.depth++
+	if b.limits.MaxDepth > 0 && b.depth > b.limits.MaxDepth {
+		return ErrLimitExceeded{LimitDepth, int64(b.limits.MaxDepth)}
+	}
+	return nil
+}
+
+func (b *budget) leave(n int) {
+	if b != nil {
+		b.depth -= n
+	}
+}
+
+// nest follows the element nesting of a token stream, open counts the
+// elements entered so far
+func (b *budget) nest(elt Element, open *int) error {
+	switch elt.(type) {
+	case *ElementStart:
+		*open++
+		return b.enter()
+	case *BinXMLEndElementTag, *BinXMLCloseEmptyElementTag:
+		if *open > 0 {
+			*open--
+			b.leave(1)
+		}
+	}
+	return nil
+}
+
+func (b *budget) element(n int) error {
+	if b == nil {
+		return nil
+	}
+	b.elements += n
+	if b.limits.MaxElements > 0 && b.elements > b.limits.MaxElements {
+		return ErrLimitExceeded{LimitElements, int64(b.limits.MaxElements)}
+	}
+	return nil
+}
+
+func (b *budget) value(n int) error {
+	if b == nil {
+		return nil
+	}
+	b.values += n
+	if b.limits.MaxValues > 0 && b.values > b.limits.MaxValues {
+		return ErrLimitExceeded{LimitValues, int64(b.limits.MaxValues)}
+	}
+	return nil
+}
+
+func (b *budget) allocate(n int64) error {
+	if b == nil {
+		return nil
+	}
+	if b.alloc += n; b.limits.MaxChunkAlloc > 0 && b.alloc > b.limits.MaxChunkAlloc {
+		return ErrLimitExceeded{LimitChunkAlloc, b.limits.MaxChunkAlloc}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package evtx
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fxSelfRef instantiates the template being defined, a cycle the parser can
+// only leave through its depth limit
+type fxSelfRef GUID
+
+func (r fxSelfRef) encode(cb *chunkBuilder) {
+	cb.put(TokenTemplateInstance, 1)
+	cb.put(r[:4]...)
+	cb.putUint32(uint32(cb.templates[GUID(r)]))
+	cb.putUint32(0)
+}
+
+// fxNestedInstance wraps a string in depth levels of BinXML values
+func fxNestedInstance(t testing.TB, depth int) *fxInstance {
+	inst := &fxInstance{
+		tmpl:   &fxTemplate{GUID{'l', 'e', 'a', 'f'}, &fxElement{name: "Leaf", content: []fxItem{fxSub{0, StringType, false}}}},
+		values: []fxValue{{typ: StringType, data: utf16LE("leaf")}},
+	}
+	for i := 0; i < depth; i++ {
+		inst = &fxInstance{
+			tmpl:   &fxTemplate{GUID{'n', 'o', 'd', 'e', byte(i)}, &fxElement{name: "Node", content: []fxItem{fxSub{0, BinXmlType, false}}}},
+			values: []fxValue{{typ: BinXmlType, nested: inst}},
+		}
+	}
+	return &fxInstance{
+		tmpl: &fxTemplate{GUID{'r', 'o', 'o', 't'}, &fxElement{
+			name:    "Event",
+			attrs:   []fxAttr{fxXmlns},
+			content: []fxItem{&fxElement{name: "EventData", content: []fxItem{fxSub{0, BinXmlType, false}}}},
+		}},
+		values: []fxValue{{typ: BinXmlType, nested: inst}},
+	}
+}
+
+func limitErrors(t *testing.T, data []byte, limits Limits) (ok int, errs []error) {
+	t.Helper()
+	ef, err := NewWithOptions(bytes.NewReader(data), Options{Limits: limits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range ef.Records() {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		} else {
+			ok++
+		}
+	}
+	return
+}
+
+func expectLimit(t *testing.T, errs []error, limit string) {
+	t.Helper()
+	if len(errs) == 0 {
+		t.Fatalf("expected %s limit error", limit)
+	}
+	for _, err := range errs {
+		var le ErrLimitExceeded
+		if !errors.As(err, &le) || le.Limit != limit || !IsLimitError(err) {
+			t.Errorf("expected %s limit error, got %v", limit, err)
+		}
+	}
+}
+
+func TestLimitsDepth(t *testing.T) {
+	data := fxFile(t, nil, []fxRecord{{1, fxCreated, fxNestedInstance(t, 40)}})
+
+	_, errs := limitErrors(t, data, DefaultLimits)
+	expectLimit(t, errs, LimitDepth)
+
+	limits := DefaultLimits
+	limits.MaxDepth = 1000
+	if ok, errs := limitErrors(t, data, limits); ok != 1 || len(errs) != 0 {
+		t.Errorf("expected the event to parse with a larger depth, got %v", errs)
+	}
+}
+
+func TestLimitsTemplateCycle(t *testing.T) {
+	guid := GUID{'c', 'y', 'c', 'l', 'e'}
+	inst := &fxInstance{tmpl: &fxTemplate{guid, &fxElement{
+		name:    "Event",
+		content: []fxItem{fxSelfRef(guid)},
+	}}}
+	data := fxFile(t, nil, []fxRecord{{1, fxCreated, inst}})
+
+	_, errs := limitErrors(t, data, DefaultLimits)
+	expectLimit(t, errs, LimitDepth)
+}
+
+func TestLimitsElementsValuesAlloc(t *testing.T) {
+	data := fxCleanFile(t)
+	for _, tc := range []struct {
+		limit  string
+		limits Limits
+	}{
+		{LimitElements, Limits{MaxElements: 50}},
+		{LimitValues, Limits{MaxValues: 10}},
+		{LimitChunkAlloc, Limits{MaxChunkAlloc: 1024}},
+	} {
+		t.Run(tc.limit, func(t *testing.T) {
+			_, errs := limitErrors(t, data, tc.limits)
+			expectLimit(t, errs, tc.limit)
+		})
+	}
+
+	if ok, errs := limitErrors(t, data, DefaultLimits); ok != 4 || len(errs) != 0 {
+		t.Errorf("default limits rejected the fixtures: %v", errs)
+	}
+}
+
+func TestLimitsRecordsKeepFlowing(t *testing.T) {
+	// a hostile event does not prevent the next ones from being parsed
+	data := fxFile(t, nil, []fxRecord{
+		{1, fxCreated, fxNestedInstance(t, 40)},
+		{2, fxCreated.Add(time.Second), fxTextInstance(t, 2)},
+	})
+	ok, errs := limitErrors(t, data, DefaultLimits)
+	if ok != 1 || len(errs) != 1 {
+		t.Errorf("expected one event and one error, got %d and %v", ok, errs)
+	}
+}
+
+func TestLimitsChunkAllocPerParse(t *testing.T) {
+	data := fxCleanFile(t)
+	// the smallest allocation limit every record parses with
+	limits := Limits{MaxChunkAlloc: 1}
+	for ok, _ := limitErrors(t, data, limits); ok != 4; ok, _ = limitErrors(t, data, limits) {
+		limits.MaxChunkAlloc *= 2
+	}
+
+	ef, err := NewWithOptions(bytes.NewReader(data), Options{Limits: limits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range ef.Records() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		// rendering a record again starts with a whole budget
+		for i := 0; i < 100; i++ {
+			if _, err := r.XML(); err != nil {
+				t.Fatalf("record %d, XML %d: %s", r.ID, i, err)
+			}
+			if _, err := r.Insertions(); err != nil {
+				t.Fatalf("record %d, insertions %d: %s", r.ID, i, err)
+			}
+		}
+	}
+}
+
+func TestLimitsDepthLeft(t *testing.T) {
+	data := fxFile(t, nil, []fxRecord{{1, fxCreated, fxNestedInstance(t, 4)}})
+	c, err := ParseChunk(data[DefaultChunkOffset:DefaultChunkOffset+ChunkSize], DefaultChunkOffset, CheckSumStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Limits = Limits{MaxDepth: 1}
+	reader, err := c.reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.release()
+	GoToSeeker(reader, int64(c.EventOffsets[0])+EventHeaderSize)
+	if _, err := Parse(reader, &c, false); !IsLimitError(err) {
+		t.Fatalf("expected a depth limit error, got %v", err)
+	}
+	if reader.budget.depth != 0 {
+		t.Errorf("depth %d left after the error", reader.budget.depth)
+	}
+}
@@ -0,0 +1,247 @@
+// Package message renders the messages of the events like Event Viewer does,
+// from the resources of the provider DLLs copied from a host. The
+// WEVT_TEMPLATE resource of a module defines the events of its providers and
+// the message identifiers of their text, found in the MESSAGETABLE resource of
+// the module or of its language file (en-US/module.dll.mui). Classic
+// providers have no manifest, their event IDs are message identifiers of their
+// EventMessageFile.
+//
+// The messages are gathered in a Catalog saved to disk as JSON and the
+// insertion strings of an event, the values of its EventData or UserData, are
+// inserted in place of %1 to %n as FormatMessage does.
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"rawsec-evtx/evtx"
+)
+
+var (
+	ErrBadResource = errors.New("bad resource")
+	ErrNoProvider  = errors.New("unknown provider")
+	ErrNoMessage   = errors.New("no message")
+)
+
+// Event is the message of an event of a manifest provider
+type Event struct {
+	ID      uint16
+	Version uint8
+	Message string
+}
+
+// Provider holds the messages of a provider, the events of its manifest or
+// the message table of a classic provider
+type Provider struct {
+	Name string `json:",omitempty"`
+	// {GUID} of the manifest providers
+	GUID string `json:",omitempty"`
+	// module the messages come from
+	Module   string
+	Events   []Event           `json:",omitempty"`
+	Messages map[uint32]string `json:",omitempty"`
+}
+
+// message returns the message of an event, the latest version is used when
+// the event version is unknown
+func (p *Provider) message(sys *evtx.EventSystem) (string, bool) {
+	var found *Event
+	for i := range p.Events {
+		e := &p.Events[i]
+		switch {
+		case e.ID != sys.EventID:
+		case e.Version == sys.Version:
+			return e.Message, true
+		case found == nil || e.Version > found.Version:
+			found = e
+		}
+	}
+	if found != nil {
+		return found.Message, true
+	}
+	// the qualifiers are the high bits of the message identifier
+	if text, ok := p.Messages[uint32(sys.Qualifiers)<<16|uint32(sys.EventID)]; ok {
+		return text, true
+	}
+	text, ok := p.Messages[uint32(sys.EventID)]
+	return text, ok
+}
+
+// Catalog holds the messages of providers, it is safe for concurrent use
+// once built
+type Catalog struct {
+	Providers []*Provider
+	// parameter messages replacing the %%n insertion strings
+	Parameters map[uint32]string `json:",omitempty"`
+
+	byGUID map[string]*Provider
+	byName map[string]*Provider
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{
+		Parameters: make(map[uint32]string),
+		byGUID:     make(map[string]*Provider),
+		byName:     make(map[string]*Provider),
+	}
+}
+
+// LoadCatalog loads a catalog saved with Save
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var saved Catalog
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c := NewCatalog()
+	for id, text := range saved.Parameters {
+		c.Parameters[id] = text
+	}
+	for _, p := range saved.Providers {
+		if err = c.Add(p); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *Catalog) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Add adds a provider, it replaces the provider of the same GUID or name
+func (c *Catalog) Add(p *Provider) error {
+	key := ""
+	if p.GUID != "" {
+		g, err := evtx.ParseGUID(p.GUID)
+		if err != nil {
+			return err
+		}
+		key = g.String()
+		p.GUID = "{" + key + "}"
+	}
+	name := strings.ToLower(p.Name)
+	old, replaced := c.byGUID[key]
+	if key == "" {
+		old, replaced = c.byName[name]
+	}
+	if replaced {
+		for i := range c.Providers {
+			if c.Providers[i] == old {
+				c.Providers[i] = p
+			}
+		}
+	} else {
+		c.Providers = append(c.Providers, p)
+	}
+	if key != "" {
+		c.byGUID[key] = p
+	}
+	if name != "" {
+		c.byName[name] = p
+	}
+	return nil
+}
+
+// AddModule adds the providers of the manifest of a module
+func (c *Catalog) AddModule(m *Module) {
+	for _, mp := range m.Providers {
+		p := &Provider{GUID: "{" + mp.GUID.String() + "}", Module: m.Name}
+		for _, e := range mp.Events {
+			if text, ok := m.Messages[e.MessageID]; ok && e.MessageID != noMessage {
+				p.Events = append(p.Events, Event{e.ID, e.Version, text})
+			}
+		}
+		sort.SliceStable(p.Events, func(i, j int) bool {
+			if p.Events[i].ID != p.Events[j].ID {
+				return p.Events[i].ID < p.Events[j].ID
+			}
+			return p.Events[i].Version < p.Events[j].Version
+		})
+		// the GUID comes from the manifest and always parses
+		_ = c.Add(p)
+	}
+}
+
+// AddClassic adds a classic provider whose event IDs are identifiers of the
+// message table of its EventMessageFile module
+func (c *Catalog) AddClassic(name string, m *Module) {
+	messages := make(map[uint32]string, len(m.Messages))
+	for id, text := range m.Messages {
+		messages[id] = text
+	}
+	_ = c.Add(&Provider{Name: name, Module: m.Name, Messages: messages})
+}
+
+// AddParameters adds the messages of a ParameterMessageFile module
+func (c *Catalog) AddParameters(m *Module) {
+	for id, text := range m.Messages {
+		c.Parameters[id] = text
+	}
+}
+
+// provider returns the provider of an event, by GUID then by name
+func (c *Catalog) provider(sys *evtx.EventSystem) (*Provider, bool) {
+	var zero evtx.GUID
+	if sys.Provider.Guid != zero {
+		if p, ok := c.byGUID[sys.Provider.Guid.String()]; ok {
+			return p, true
+		}
+	}
+	for _, name := range []string{sys.Provider.EventSourceName, sys.Provider.Name} {
+		if p, ok := c.byName[strings.ToLower(name)]; ok && name != "" {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Message returns the message of an event before insertion
+func (c *Catalog) Message(sys *evtx.EventSystem) (string, error) {
+	p, ok := c.provider(sys)
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrNoProvider, sys.Provider.Name)
+	}
+	text, ok := p.message(sys)
+	if !ok {
+		return "", fmt.Errorf("%w for event %d version %d of %s", ErrNoMessage, sys.EventID, sys.Version, sys.Provider.Name)
+	}
+	return text, nil
+}
+
+// Render returns the message of the event of a record
+func (c *Catalog) Render(r *evtx.Record) (string, error) {
+	if r.SystemErr != nil {
+		return "", r.SystemErr
+	}
+	if r.System == nil {
+		return "", evtx.ErrNoSystem
+	}
+	insertions, err := r.Insertions()
+	if err != nil {
+		return "", err
+	}
+	return c.RenderEvent(r.System, insertions)
+}
+
+// RenderEvent returns the message of an event given its insertion strings,
+// those of the legacy .evt records for instance
+func (c *Catalog) RenderEvent(sys *evtx.EventSystem, insertions []string) (string, error) {
+	text, err := c.Message(sys)
+	if err != nil {
+		return "", err
+	}
+	return c.Format(text, insertions), nil
+}
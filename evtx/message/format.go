@@ -0,0 +1,119 @@
+package message
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// parameter insertion strings, replaced by a parameter message
+	parameterRe = regexp.MustCompile(`%%(\d+)`)
+	// printf format of an insertion, %1!08x! for instance
+	printfRe = regexp.MustCompile(`^([-+ #0]*)(\d*)(\.\d+)?(hh|h|ll|l|I64|I32|I|w|z)?([a-zA-Z])$`)
+)
+
+// Format inserts the insertion strings in a message as FormatMessage does,
+// the %%n insertion strings are replaced by the parameter messages and the
+// line breaks ending the message are removed
+func (c *Catalog) Format(text string, insertions []string) string {
+	expanded := make([]string, len(insertions))
+	for i, s := range insertions {
+		expanded[i] = parameterRe.ReplaceAllStringFunc(s, func(p string) string {
+			id, err := strconv.ParseUint(p[2:], 10, 32)
+			if err != nil {
+				return p
+			}
+			if param, ok := c.Parameters[uint32(id)]; ok {
+				return strings.TrimRight(param, "\r\n")
+			}
+			return p
+		})
+	}
+	return Format(text, expanded)
+}
+
+// Format inserts the insertion strings in place of %1 to %99 of a message,
+// the insertions missing are left as is
+func Format(text string, insertions []string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); i++ {
+		if text[i] != '%' || i+1 == len(text) {
+			b.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case '0':
+			// ends the message without line break
+			return b.String()
+		case 'n':
+			b.WriteString("\r\n")
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '%', ' ', '.', '!':
+			b.WriteByte(text[i])
+		case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			start := i
+			if i+1 < len(text) && text[i+1] >= '0' && text[i+1] <= '9' {
+				i++
+			}
+			n, _ := strconv.Atoi(text[start : i+1])
+			spec := ""
+			if i+1 < len(text) && text[i+1] == '!' {
+				if end := strings.IndexByte(text[i+2:], '!'); end >= 0 {
+					spec = text[i+2 : i+2+end]
+					i += end + 2
+				}
+			}
+			if n > len(insertions) {
+				b.WriteString(text[start-1 : i+1])
+				continue
+			}
+			b.WriteString(printf(spec, insertions[n-1]))
+		default:
+			b.WriteByte('%')
+			b.WriteByte(text[i])
+		}
+	}
+	return strings.TrimRight(b.String(), "\r\n")
+}
+
+// printf formats an insertion string with the C printf format of its
+// insertion, numbers are parsed back from the string
+func printf(spec, s string) string {
+	m := printfRe.FindStringSubmatch(spec)
+	if m == nil {
+		return s
+	}
+	format := "%" + m[1] + m[2] + m[3]
+	switch m[5] {
+	case "d", "i":
+		if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return fmt.Sprintf(format+"d", n)
+		}
+	case "u", "x", "X", "o":
+		verb := m[5]
+		if verb == "u" {
+			verb = "d"
+		}
+		if n, err := strconv.ParseUint(s, 0, 64); err == nil {
+			return fmt.Sprintf(format+verb, n)
+		}
+		if n, err := strconv.ParseInt(s, 0, 64); err == nil {
+			// negative numbers are printed as their two's complement
+			return fmt.Sprintf(format+verb, uint32(n))
+		}
+	case "c", "C":
+		if s != "" {
+			return fmt.Sprintf(format+"c", []rune(s)[0])
+		}
+	case "s", "S":
+		return fmt.Sprintf(format+"s", s)
+	}
+	return s
+}
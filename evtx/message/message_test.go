@@ -0,0 +1,404 @@
+package message
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"rawsec-evtx/evtx"
+)
+
+const (
+	testGUID        = "{54849625-5478-4994-A5BA-3E3B0328C30D}"
+	rsrcAddress     = 0x1000
+	peFileAlignment = 0x200
+)
+
+func le(b []byte, values ...interface{}) []byte {
+	buf := bytes.NewBuffer(b)
+	for _, v := range values {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			panic(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func utf16LE(s string) []byte {
+	return le(nil, utf16.Encode([]rune(s)))
+}
+
+func pad(b []byte, align int) []byte {
+	for len(b)%align != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+type testResource struct {
+	typ  string
+	name uint32
+	lang uint32
+	data []byte
+}
+
+// rsrcSection lays out a resource directory at the start of a section
+// mapped at rsrcAddress
+func rsrcSection(resources []testResource) []byte {
+	var b []byte
+	dir := func(n int) uint32 {
+		pos := len(b)
+		b = append(b, make([]byte, resourceDirectorySize+resourceEntrySize*n)...)
+		binary.LittleEndian.PutUint16(b[pos+14:], uint16(n))
+		return uint32(pos)
+	}
+	entry := func(dir uint32, i int, name, target uint32) {
+		p := dir + resourceDirectorySize + uint32(resourceEntrySize*i)
+		binary.LittleEndian.PutUint32(b[p:], name)
+		binary.LittleEndian.PutUint32(b[p+4:], target)
+	}
+
+	var types []string
+	byType := make(map[string]map[uint32][]testResource)
+	for _, r := range resources {
+		if byType[r.typ] == nil {
+			types = append(types, r.typ)
+			byType[r.typ] = make(map[uint32][]testResource)
+		}
+		byType[r.typ][r.name] = append(byType[r.typ][r.name], r)
+	}
+	root := dir(len(types))
+	for i, typ := range types {
+		var names []uint32
+		for name := range byType[typ] {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+		td := dir(len(names))
+		var id uint32
+		if _, err := fmt.Sscanf(typ, "#%d", &id); err != nil {
+			id = resourceHighBit | uint32(len(b))
+			b = pad(le(b, uint16(len(utf16.Encode([]rune(typ)))), utf16LE(typ)), 4)
+		}
+		entry(root, i, id, resourceHighBit|td)
+		for j, name := range names {
+			langs := byType[typ][name]
+			nd := dir(len(langs))
+			entry(td, j, name, resourceHighBit|nd)
+			for k, r := range langs {
+				de := uint32(len(b))
+				b = append(b, make([]byte, resourceDataEntrySize)...)
+				entry(nd, k, r.lang, de)
+				binary.LittleEndian.PutUint32(b[de:], rsrcAddress+uint32(len(b)))
+				binary.LittleEndian.PutUint32(b[de+4:], uint32(len(r.data)))
+				b = pad(append(b, r.data...), 4)
+			}
+		}
+	}
+	return b
+}
+
+// peFile builds a PE32+ file with the resource section only
+func peFile(rsrc []byte) []byte {
+	const headers = peFileAlignment
+	b := make([]byte, 0x40)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], 0x40)
+	b = append(b, "PE\x00\x00"...)
+
+	var oh pe.OptionalHeader64
+	b = le(b, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     1,
+		SizeOfOptionalHeader: uint16(binary.Size(oh)),
+		Characteristics:      pe.IMAGE_FILE_DLL | pe.IMAGE_FILE_EXECUTABLE_IMAGE,
+	})
+	raw := uint32(len(pad(append([]byte{}, rsrc...), peFileAlignment)))
+	oh = pe.OptionalHeader64{
+		Magic:               0x20b,
+		SectionAlignment:    0x1000,
+		FileAlignment:       peFileAlignment,
+		SizeOfImage:         rsrcAddress + raw,
+		SizeOfHeaders:       headers,
+		NumberOfRvaAndSizes: 16,
+	}
+	oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE] = pe.DataDirectory{VirtualAddress: rsrcAddress, Size: uint32(len(rsrc))}
+	b = le(b, oh)
+	section := pe.SectionHeader32{
+		VirtualSize:      uint32(len(rsrc)),
+		VirtualAddress:   rsrcAddress,
+		SizeOfRawData:    raw,
+		PointerToRawData: headers,
+	}
+	copy(section.Name[:], ".rsrc")
+	b = pad(le(b, section), peFileAlignment)
+	return pad(append(b, rsrc...), peFileAlignment)
+}
+
+// messageTable builds a MESSAGETABLE resource with a block per range of
+// consecutive identifiers
+func messageTable(messages map[uint32]string, ansi bool) []byte {
+	var ids []uint32
+	for id := range messages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var blocks [][]uint32
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1]+1 {
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], id)
+		} else {
+			blocks = append(blocks, []uint32{id})
+		}
+	}
+
+	var entries []byte
+	offset := uint32(4 + 12*len(blocks))
+	b := le(nil, uint32(len(blocks)))
+	for _, block := range blocks {
+		b = le(b, block[0], block[len(block)-1], offset+uint32(len(entries)))
+		for _, id := range block {
+			text, flags := utf16LE(messages[id]+"\x00"), uint16(messageUnicode)
+			if ansi {
+				text, flags = []byte(messages[id]+"\x00"), 0
+			}
+			text = pad(text, 4)
+			entries = le(entries, uint16(4+len(text)), flags, text)
+		}
+	}
+	return append(b, entries...)
+}
+
+// wevtTemplate builds a WEVT_TEMPLATE resource of a provider whose events
+// follow a channel element
+func wevtTemplate(guid string, events []ManifestEvent) []byte {
+	g, err := evtx.ParseGUID(guid)
+	if err != nil {
+		panic(err)
+	}
+	const (
+		wevt = crimHeaderSize + crimProviderSize
+		chn  = wevt + wevtHeaderSize + 2*wevtDescriptorSize
+		evnt = chn + 12
+	)
+	size := evnt + evntHeaderSize + evntEventSize*len(events)
+	b := le(nil, []byte("CRIM"), uint32(size), uint16(3), uint16(1), uint32(1), g, uint32(wevt))
+	b = le(b, []byte("WEVT"), uint32(size-wevt), uint32(noMessage), uint32(2), uint32(0))
+	b = le(b, uint32(chn), uint32(0), uint32(evnt), uint32(0))
+	b = le(b, []byte("CHAN"), uint32(12), uint32(0))
+	b = le(b, []byte("EVNT"), uint32(size-evnt), uint32(len(events)), uint32(0))
+	for _, e := range events {
+		b = le(b, e.ID, e.Version, e.Channel, e.Level, e.Opcode, e.Task, e.Keywords, e.MessageID,
+			make([]byte, evntEventSize-20))
+	}
+	return b
+}
+
+var manifestEvents = []ManifestEvent{
+	{ID: 4624, Version: 0, Level: 4, Keywords: 0x8020000000000000, MessageID: 0xb0001210},
+	{ID: 4624, Version: 2, Level: 4, Keywords: 0x8020000000000000, MessageID: 0xb0001211},
+	{ID: 4625, Version: 0, Level: 4, MessageID: noMessage},
+}
+
+func providerModule(t *testing.T) *Module {
+	t.Helper()
+	data := peFile(rsrcSection([]testResource{
+		{typeWevtTemplate, 1, 0, wevtTemplate(testGUID, manifestEvents)},
+		{typeMessageTable, 1, 0x409, messageTable(map[uint32]string{
+			0xb0001210: "An account was logged on.%n%nAccount Name:%t%1%nLogon Type:%t%2\r\n",
+			0xb0001211: "An account was successfully logged on.%n%nAccount Name:%t%1%nLogon Type:%t%2%nElevated:%t%3\r\n",
+		}, false)},
+		// other languages come after English
+		{typeMessageTable, 1, 0x40c, messageTable(map[uint32]string{
+			0xb0001210: "Un compte s'est connecté.",
+			0xb0001212: "Message français",
+		}, false)},
+	}))
+	m, err := ReadModule(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Name = "provider.dll"
+	return m
+}
+
+func TestReadModule(t *testing.T) {
+	m := providerModule(t)
+	if len(m.Providers) != 1 {
+		t.Fatalf("%d providers, expected 1", len(m.Providers))
+	}
+	p := m.Providers[0]
+	if "{"+p.GUID.String()+"}" != testGUID || p.MessageID != noMessage {
+		t.Errorf("unexpected provider {%s} message 0x%x", p.GUID.String(), p.MessageID)
+	}
+	if !reflect.DeepEqual(p.Events, manifestEvents) {
+		t.Errorf("unexpected events %+v", p.Events)
+	}
+	if len(m.Messages) != 3 || !strings.HasPrefix(m.Messages[0xb0001210], "An account was logged on.") ||
+		m.Messages[0xb0001212] != "Message français" {
+		t.Errorf("unexpected messages %q", m.Messages)
+	}
+
+	ansi := peFile(rsrcSection([]testResource{{typeMessageTable, 1, 0, messageTable(map[uint32]string{1: "caf\xe9", 2: "two"}, true)}}))
+	if m, err := ReadModule(bytes.NewReader(ansi)); err != nil {
+		t.Error(err)
+	} else if len(m.Providers) != 0 || !reflect.DeepEqual(m.Messages, map[uint32]string{1: "café", 2: "two"}) {
+		t.Errorf("unexpected ANSI module %+v", m)
+	}
+
+	// truncated resources fail without panicking
+	table := messageTable(map[uint32]string{1: "one", 2: "two"}, false)
+	manifest := wevtTemplate(testGUID, manifestEvents)
+	for _, r := range []testResource{
+		{typeMessageTable, 1, 0, table[:len(table)-8]},
+		{typeMessageTable, 1, 0, table[:10]},
+		{typeWevtTemplate, 1, 0, manifest[:len(manifest)-8]},
+		{typeWevtTemplate, 1, 0, manifest[:40]},
+	} {
+		if _, err := ReadModule(bytes.NewReader(peFile(rsrcSection([]testResource{r})))); err == nil {
+			t.Errorf("no error reading a truncated %s", r.typ)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	insertions := []string{"alice", "42", "-1", "x"}
+	for _, tc := range []struct {
+		text, expected string
+	}{
+		{"User %1 logged on\r\n", "User alice logged on"},
+		{"%1%n%t%2%r", "alice\r\n\t42"},
+		{"100%% %. %! % end", "100% . !  end"},
+		{"%2!5d!|%2!-5d!|%2!04x!|%2!X!|%3!x!|%3!d!|%1!s!|%1!S!|%1!ws!", "   42|42   |002a|2A|ffffffff|-1|alice|alice|alice"},
+		{"%4!d! %1!c! %2!I64u!", "x a 42"},
+		{"missing %5 and %12", "missing %5 and %12"},
+		{"%1%0 ignored", "alice"},
+		{"%10 is not %1 then 0", "%10 is not alice then 0"},
+		{"unknown %z escape, trailing %", "unknown %z escape, trailing %"},
+	} {
+		if got := Format(tc.text, insertions); got != tc.expected {
+			t.Errorf("Format(%q) = %q, expected %q", tc.text, got, tc.expected)
+		}
+	}
+
+	c := NewCatalog()
+	c.Parameters[1833] = "Yes\r\n"
+	c.Parameters[1842] = "No\r\n"
+	if got := c.Format("Elevated: %1, %2", []string{"%%1833", "%%1842 %%9999"}); got != "Elevated: Yes, No %%9999" {
+		t.Errorf("unexpected parameters expansion %q", got)
+	}
+}
+
+func eventXML(provider, guid string, id, qualifiers, version int, data string) string {
+	var attrs string
+	if guid != "" {
+		attrs = fmt.Sprintf(` Guid="%s"`, guid)
+	}
+	var q string
+	if qualifiers != 0 {
+		q = fmt.Sprintf(` Qualifiers="%d"`, qualifiers)
+	}
+	return fmt.Sprintf(`<Event><System><Provider Name="%s"%s/><EventID%s>%d</EventID><Version>%d</Version>`+
+		`<TimeCreated SystemTime="2020-09-13T12:00:00Z"/><Channel>Test</Channel><Computer>HOST</Computer></System>%s</Event>`,
+		provider, attrs, q, id, version, data)
+}
+
+func TestCatalog(t *testing.T) {
+	c := NewCatalog()
+	c.AddModule(providerModule(t))
+	classic := peFile(rsrcSection([]testResource{{typeMessageTable, 1, 0, messageTable(map[uint32]string{
+		0xc0001b58: "The %1 service failed to start due to the following error: %n%2\r\n",
+		7036:       "The %1 service entered the %2 state.\r\n",
+	}, false)}}))
+	m, err := ReadModule(bytes.NewReader(classic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AddClassic("Service Control Manager", m)
+	c.AddParameters(&Module{Messages: map[uint32]string{1842: "No\r\n", 1843: "Yes\r\n"}})
+
+	path := filepath.Join(t.TempDir(), "catalog.json")
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = LoadCatalog(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Providers) != 2 || len(c.Providers[0].Events) != 2 {
+		t.Fatalf("unexpected catalog %+v", c.Providers)
+	}
+
+	logon := `<EventData><Data Name="TargetUserName">alice</Data><Data Name="LogonType">10</Data><Data Name="ElevatedToken">%%1843</Data></EventData>`
+	events := []string{
+		eventXML("Microsoft-Windows-Security-Auditing", testGUID, 4624, 0, 2, logon),
+		// unknown version, the latest is used
+		eventXML("Microsoft-Windows-Security-Auditing", testGUID, 4624, 0, 3, logon),
+		eventXML("Microsoft-Windows-Security-Auditing", testGUID, 4624, 0, 0, logon),
+		eventXML("Service Control Manager", "", 7000, 0xc000, 0,
+			`<EventData><Data Name="param1">Spooler</Data><Data Name="param2">Access is denied.</Data><Binary>00</Binary></EventData>`),
+		eventXML("Service Control Manager", "", 7036, 0x4000, 0,
+			`<EventData><Data Name="param1">Windows Update</Data><Data Name="param2">running</Data></EventData>`),
+		eventXML("Microsoft-Windows-Security-Auditing", testGUID, 4625, 0, 0, logon),
+		eventXML("Unknown", "", 1, 0, 0, ""),
+	}
+	expected := []string{
+		"An account was successfully logged on.\r\n\r\nAccount Name:\talice\r\nLogon Type:\t10\r\nElevated:\tYes",
+		"An account was successfully logged on.\r\n\r\nAccount Name:\talice\r\nLogon Type:\t10\r\nElevated:\tYes",
+		"An account was logged on.\r\n\r\nAccount Name:\talice\r\nLogon Type:\t10",
+		"The Spooler service failed to start due to the following error: \r\nAccess is denied.",
+		"The Windows Update service entered the running state.",
+		ErrNoMessage.Error(),
+		ErrNoProvider.Error(),
+	}
+
+	evtxPath := filepath.Join(t.TempDir(), "test.evtx")
+	w, err := evtx.Create(evtxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteXML(strings.NewReader(strings.Join(events, "\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ef, err := evtx.Open(evtxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	n := 0
+	for r := range ef.Records() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		i := int(r.ID - 1)
+		got, err := c.Render(r)
+		if err != nil {
+			got = err.Error()
+			if strings.HasPrefix(got, expected[i]) {
+				got = expected[i]
+			}
+		}
+		if got != expected[i] {
+			t.Errorf("record %d message %q, expected %q", r.ID, got, expected[i])
+		}
+		n++
+	}
+	if n != len(events) {
+		t.Errorf("%d records, expected %d", n, len(events))
+	}
+
+	// legacy .evt records come with their insertion strings
+	sys := &evtx.EventSystem{Provider: evtx.EventProvider{Name: "Service Control Manager"}, EventID: 7036, Qualifiers: 0x4000}
+	if got, err := c.RenderEvent(sys, []string{"Spooler", "stopped"}); err != nil || got != "The Spooler service entered the stopped state." {
+		t.Errorf("unexpected message %q, %v", got, err)
+	}
+}
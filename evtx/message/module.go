@@ -0,0 +1,273 @@
+package message
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rawsec-evtx/evtx"
+)
+
+const (
+	// message table entries holding UTF-16 text
+	messageUnicode = 0x0001
+	// message identifier of the manifest items without message
+	noMessage = 0xffffffff
+
+	crimHeaderSize     = 16
+	crimProviderSize   = 20
+	wevtHeaderSize     = 20
+	wevtDescriptorSize = 8
+	evntHeaderSize     = 16
+	evntEventSize      = 48
+)
+
+// Module holds the event resources of a DLL, EXE or MUI file
+type Module struct {
+	// base name of the file
+	Name string
+	// providers of the WEVT_TEMPLATE resource
+	Providers []ManifestProvider
+	// MESSAGETABLE resource, by message identifier
+	Messages map[uint32]string
+}
+
+// ManifestProvider is a provider described by a WEVT_TEMPLATE resource
+type ManifestProvider struct {
+	GUID      evtx.GUID
+	MessageID uint32
+	Events    []ManifestEvent
+}
+
+// ManifestEvent is the definition of an event of a manifest, its message
+// is in the message table of the module of its provider
+type ManifestEvent struct {
+	ID        uint16
+	Version   uint8
+	Channel   uint8
+	Level     uint8
+	Opcode    uint8
+	Task      uint16
+	Keywords  uint64
+	MessageID uint32
+}
+
+// OpenModule reads the resources of a PE file
+func OpenModule(path string) (*Module, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := ReadModule(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m.Name = strings.TrimSuffix(strings.ToLower(filepath.Base(path)), ".mui")
+	return m, nil
+}
+
+func ReadModule(r io.ReaderAt) (*Module, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &Module{Messages: make(map[uint32]string)}
+	rs, err := openResources(f)
+	if err != nil || rs == nil {
+		return m, err
+	}
+	tables, err := rs.resources(typeMessageTable)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if err = parseMessageTable(table, m.Messages); err != nil {
+			return nil, err
+		}
+	}
+	manifests, err := rs.resources(typeWevtTemplate)
+	if err != nil {
+		return nil, err
+	}
+	for _, manifest := range manifests {
+		providers, err := parseManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
+		m.Providers = append(m.Providers, providers...)
+	}
+	return m, nil
+}
+
+// Merge adds the resources of other, the language file of the module, to
+// those of m, the messages of m win
+func (m *Module) Merge(other *Module) {
+	for id, text := range other.Messages {
+		if _, ok := m.Messages[id]; !ok {
+			m.Messages[id] = text
+		}
+	}
+	m.Providers = append(m.Providers, other.Providers...)
+}
+
+// parseMessageTable parses a MESSAGETABLE resource, messages already known
+// are kept
+func parseMessageTable(data []byte, messages map[uint32]string) error {
+	if len(data) < 4 {
+		return fmt.Errorf("%w: message table too small", ErrBadResource)
+	}
+	n := binary.LittleEndian.Uint32(data)
+	if uint64(n)*12 > uint64(len(data)-4) {
+		return fmt.Errorf("%w: %d message blocks out of the message table", ErrBadResource, n)
+	}
+	for i := uint32(0); i < n; i++ {
+		block := data[4+12*i:]
+		low, high := binary.LittleEndian.Uint32(block), binary.LittleEndian.Uint32(block[4:])
+		offset := uint64(binary.LittleEndian.Uint32(block[8:]))
+		for id := uint64(low); id <= uint64(high); id++ {
+			if offset+4 > uint64(len(data)) {
+				return fmt.Errorf("%w: message 0x%x out of the message table", ErrBadResource, id)
+			}
+			size := uint64(binary.LittleEndian.Uint16(data[offset:]))
+			flags := binary.LittleEndian.Uint16(data[offset+2:])
+			if size < 4 || offset+size > uint64(len(data)) {
+				return fmt.Errorf("%w: message 0x%x has a bad size", ErrBadResource, id)
+			}
+			text := data[offset+4 : offset+size]
+			if _, ok := messages[uint32(id)]; !ok {
+				if flags&messageUnicode != 0 {
+					messages[uint32(id)] = decodeUTF16(text)
+				} else {
+					messages[uint32(id)] = decodeLatin1(text)
+				}
+			}
+			offset += size
+		}
+	}
+	return nil
+}
+
+// decodeLatin1 decodes the ANSI messages up to their first null character
+func decodeLatin1(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// manifest reads the binary manifest of a WEVT_TEMPLATE resource, offsets
+// are relative to its start
+type manifest []byte
+
+func (m manifest) uint32(offset uint32) (uint32, error) {
+	if uint64(offset)+4 > uint64(len(m)) {
+		return 0, fmt.Errorf("%w: manifest offset 0x%x out of the resource", ErrBadResource, offset)
+	}
+	return binary.LittleEndian.Uint32(m[offset:]), nil
+}
+
+func (m manifest) signature(offset uint32, sig string) error {
+	if uint64(offset)+4 > uint64(len(m)) || string(m[offset:offset+4]) != sig {
+		return fmt.Errorf("%w: no %s signature @ 0x%x", ErrBadResource, sig, offset)
+	}
+	return nil
+}
+
+// slice returns the n items of size bytes at offset
+func (m manifest) slice(offset, n, size uint32) ([]byte, error) {
+	end := uint64(offset) + uint64(n)*uint64(size)
+	if end > uint64(len(m)) {
+		return nil, fmt.Errorf("%w: manifest offset 0x%x out of the resource", ErrBadResource, offset)
+	}
+	return m[offset:end], nil
+}
+
+// parseManifest parses the providers of a WEVT_TEMPLATE resource, only their
+// event definitions are kept
+func parseManifest(data []byte) ([]ManifestProvider, error) {
+	m := manifest(data)
+	if err := m.signature(0, "CRIM"); err != nil {
+		return nil, err
+	}
+	n, err := m.uint32(12)
+	if err != nil {
+		return nil, err
+	}
+	descs, err := m.slice(crimHeaderSize, n, crimProviderSize)
+	if err != nil {
+		return nil, err
+	}
+	providers := make([]ManifestProvider, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var p ManifestProvider
+		desc := descs[i*crimProviderSize:]
+		copy(p.GUID[:], desc[:16])
+		offset := binary.LittleEndian.Uint32(desc[16:])
+		if err = m.signature(offset, "WEVT"); err != nil {
+			return nil, err
+		}
+		if p.MessageID, err = m.uint32(offset + 8); err != nil {
+			return nil, err
+		}
+		count, err := m.uint32(offset + 12)
+		if err != nil {
+			return nil, err
+		}
+		elements, err := m.slice(offset+wevtHeaderSize, count, wevtDescriptorSize)
+		if err != nil {
+			return nil, err
+		}
+		for j := uint32(0); j < count; j++ {
+			element := binary.LittleEndian.Uint32(elements[j*wevtDescriptorSize:])
+			if m.signature(element, "EVNT") != nil {
+				continue
+			}
+			events, err := m.events(element)
+			if err != nil {
+				return nil, err
+			}
+			p.Events = append(p.Events, events...)
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// events parses the event definitions of the EVNT element at offset
+func (m manifest) events(offset uint32) ([]ManifestEvent, error) {
+	n, err := m.uint32(offset + 8)
+	if err != nil {
+		return nil, err
+	}
+	defs, err := m.slice(offset+evntHeaderSize, n, evntEventSize)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]ManifestEvent, n)
+	for i := range events {
+		def := defs[i*evntEventSize:]
+		events[i] = ManifestEvent{
+			ID:        binary.LittleEndian.Uint16(def),
+			Version:   def[2],
+			Channel:   def[3],
+			Level:     def[4],
+			Opcode:    def[5],
+			Task:      binary.LittleEndian.Uint16(def[6:]),
+			Keywords:  binary.LittleEndian.Uint64(def[8:]),
+			MessageID: binary.LittleEndian.Uint32(def[16:]),
+		}
+	}
+	return events, nil
+}
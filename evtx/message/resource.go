@@ -0,0 +1,194 @@
+package message
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// resource types
+	typeMessageTable = "#11"
+	typeWevtTemplate = "WEVT_TEMPLATE"
+
+	resourceDirectorySize = 16
+	resourceEntrySize     = 8
+	resourceDataEntrySize = 16
+	// high bit of the entries naming with a string or pointing to a directory
+	resourceHighBit = 0x80000000
+)
+
+// resourceSection is the section of a PE file holding its resource directory
+type resourceSection struct {
+	data []byte
+	// virtual address of the section and of the resource directory
+	address   uint32
+	directory uint32
+}
+
+func openResources(f *pe.File) (*resourceSection, error) {
+	var dirs []pe.DataDirectory
+	switch f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs = f.OptionalHeader.(*pe.OptionalHeader32).DataDirectory[:]
+	case *pe.OptionalHeader64:
+		dirs = f.OptionalHeader.(*pe.OptionalHeader64).DataDirectory[:]
+	default:
+		return nil, nil
+	}
+	if len(dirs) <= pe.IMAGE_DIRECTORY_ENTRY_RESOURCE || dirs[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE].Size == 0 {
+		return nil, nil
+	}
+	rva := dirs[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE].VirtualAddress
+	for _, s := range f.Sections {
+		size := s.VirtualSize
+		if s.Size > size {
+			size = s.Size
+		}
+		if rva < s.VirtualAddress || rva-s.VirtualAddress >= size {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		return &resourceSection{data: data, address: s.VirtualAddress, directory: rva}, nil
+	}
+	return nil, fmt.Errorf("%w: resource directory @ 0x%x out of the sections", ErrBadResource, rva)
+}
+
+// bytes returns the n bytes at the offset of the section
+func (rs *resourceSection) bytes(offset, n uint32) ([]byte, error) {
+	if uint64(offset)+uint64(n) > uint64(len(rs.data)) {
+		return nil, fmt.Errorf("%w: %d bytes @ 0x%x out of the section", ErrBadResource, n, offset)
+	}
+	return rs.data[offset : offset+n], nil
+}
+
+type resourceEntry struct {
+	// string of the named entries, #ID for the others
+	name   string
+	id     uint32
+	offset uint32
+}
+
+// entries returns the entries of the directory at offset of the resource
+// directory, those pointing to directories when dirs is true, to data
+// otherwise
+func (rs *resourceSection) entries(offset uint32, dirs bool) ([]resourceEntry, error) {
+	base := rs.directory - rs.address
+	b, err := rs.bytes(base+offset, resourceDirectorySize)
+	if err != nil {
+		return nil, err
+	}
+	n := uint32(binary.LittleEndian.Uint16(b[12:])) + uint32(binary.LittleEndian.Uint16(b[14:]))
+	table, err := rs.bytes(base+offset+resourceDirectorySize, n*resourceEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]resourceEntry, 0, n)
+	for i := uint32(0); i < n; i++ {
+		name := binary.LittleEndian.Uint32(table[i*resourceEntrySize:])
+		target := binary.LittleEndian.Uint32(table[i*resourceEntrySize+4:])
+		if (target&resourceHighBit != 0) != dirs {
+			continue
+		}
+		e := resourceEntry{id: name, offset: target &^ resourceHighBit}
+		if name&resourceHighBit != 0 {
+			if e.name, err = rs.name(base + (name &^ resourceHighBit)); err != nil {
+				return nil, err
+			}
+		} else {
+			e.name = fmt.Sprintf("#%d", name)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// name reads the length prefixed UTF-16 name of an entry
+func (rs *resourceSection) name(offset uint32) (string, error) {
+	b, err := rs.bytes(offset, 2)
+	if err != nil {
+		return "", err
+	}
+	if b, err = rs.bytes(offset+2, 2*uint32(binary.LittleEndian.Uint16(b))); err != nil {
+		return "", err
+	}
+	return decodeUTF16(b), nil
+}
+
+// resources returns the data of the resources of a type, of all their names
+// and languages, the neutral and English languages first
+func (rs *resourceSection) resources(typ string) ([][]byte, error) {
+	types, err := rs.entries(0, true)
+	if err != nil {
+		return nil, err
+	}
+	var resources [][]byte
+	for _, t := range types {
+		if !strings.EqualFold(t.name, typ) {
+			continue
+		}
+		names, err := rs.entries(t.offset, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			langs, err := rs.entries(n.offset, false)
+			if err != nil {
+				return nil, err
+			}
+			sort.SliceStable(langs, func(i, j int) bool {
+				return langRank(langs[i].id) < langRank(langs[j].id)
+			})
+			for _, l := range langs {
+				data, err := rs.dataEntry(rs.directory - rs.address + l.offset)
+				if err != nil {
+					return nil, err
+				}
+				resources = append(resources, data)
+			}
+		}
+	}
+	return resources, nil
+}
+
+func langRank(lang uint32) int {
+	switch lang {
+	case 0:
+		return 0
+	case 0x409:
+		return 1
+	}
+	return 2
+}
+
+// dataEntry returns the data pointed by the data entry at offset of the section
+func (rs *resourceSection) dataEntry(offset uint32) ([]byte, error) {
+	b, err := rs.bytes(offset, resourceDataEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	rva, size := binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])
+	if rva < rs.address {
+		return nil, fmt.Errorf("%w: resource data @ 0x%x out of the section", ErrBadResource, rva)
+	}
+	return rs.bytes(rva-rs.address, size)
+}
+
+// decodeUTF16 decodes UTF-16LE text up to its first null character
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,118 @@
+//go:build linux
+
+package evtx
+
+import (
+	"os"
+	"sync"
+	"syscall"
+
+	"rawsec-evtx/encoding"
+)
+
+// mmapFile is a read only mapping of a file, chunks are sliced out of it
+// without copy and concurrent reads do not need any lock
+type mmapFile struct {
+	*encoding.SliceReader
+	data []byte
+	// users of the mapping, it is not unmapped before they are done
+	sync.Mutex
+	users  sync.WaitGroup
+	closed bool
+}
+
+func mmap(f *os.File) (*mmapFile, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: f.Name(), Err: err}
+	}
+	return &mmapFile{SliceReader: encoding.NewSliceReader(data), data: data}, nil
+}
+
+func (m *mmapFile) acquire() bool {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return false
+	}
+	m.users.Add(1)
+	return true
+}
+
+func (m *mmapFile) release() {
+	m.users.Done()
+}
+
+func (m *mmapFile) Read(p []byte) (int, error) {
+	if !m.acquire() {
+		return 0, ErrFileClosed
+	}
+	defer m.release()
+	return m.SliceReader.Read(p)
+}
+
+func (m *mmapFile) ReadAt(p []byte, off int64) (int, error) {
+	if !m.acquire() {
+		return 0, ErrFileClosed
+	}
+	defer m.release()
+	return m.SliceReader.ReadAt(p, off)
+}
+
+func (m *mmapFile) slice(offset, n int64) ([]byte, bool) {
+	if offset < 0 || offset+n > int64(len(m.data)) {
+		return nil, false
+	}
+	return m.data[offset : offset+n : offset+n], true
+}
+
+// Close waits for the chunks being decoded before unmapping the file, they
+// fail with ErrFileClosed afterwards
+func (m *mmapFile) Close() error {
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.Unlock()
+	m.users.Wait()
+	data := m.data
+	m.data = nil
+	m.Reset(nil)
+	return syscall.Munmap(data)
+}
+
+// OpenMmap opens an EVTX file mapped in memory. The mapping does not grow with
+// the file, it is not meant to follow a log being written. Records and chunks
+// used once the file is closed fail with ErrFileClosed. Empty files, which
+// cannot be mapped, are opened as with Open.
+func OpenMmap(filepath string) (ef File, err error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	if stat, err := file.Stat(); err != nil || stat.Size() == 0 {
+		return Open(filepath)
+	}
+	m, err := mmap(file)
+	if err != nil {
+		return
+	}
+
+	ef, err = New(m)
+	if err != nil {
+		_ = m.Close()
+		return
+	}
+
+	err = ef.Header.Verify()
+
+	return
+}
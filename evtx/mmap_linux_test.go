@@ -0,0 +1,53 @@
+package evtx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenMmapCloseCancelled(t *testing.T) {
+	data, _ := fxBulkFile(t, 16)
+	path := filepath.Join(t.TempDir(), "bulk.evtx")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ef, err := OpenMmap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef.Workers = 4
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records := ef.RecordsContext(ctx)
+	first := <-records
+	if first == nil || first.Err != nil {
+		t.Fatalf("unexpected first record %+v", first)
+	}
+	cancel()
+	// the workers still decoding chunks are waited for
+	if err := ef.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for r := range records {
+		if r.Err != nil && !errors.Is(r.Err, ErrFileClosed) {
+			t.Errorf("record %d: %s", r.ID, r.Err)
+		}
+	}
+
+	if _, err := first.XML(); !errors.Is(err, ErrFileClosed) {
+		t.Errorf("expected XML to fail once the file is closed, got %v", err)
+	}
+	if _, err := first.Insertions(); !errors.Is(err, ErrFileClosed) {
+		t.Errorf("expected Insertions to fail once the file is closed, got %v", err)
+	}
+	if _, err := ef.FetchChunk(DefaultChunkOffset); !errors.Is(err, ErrFileClosed) {
+		t.Errorf("expected FetchChunk to fail once the file is closed, got %v", err)
+	}
+	if err := ef.Close(); err != nil {
+		t.Errorf("closing twice: %s", err)
+	}
+}
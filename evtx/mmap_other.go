@@ -0,0 +1,9 @@
+//go:build !linux
+
+package evtx
+
+// OpenMmap falls back to Open where memory mapping is not supported, the
+// file is still read concurrently through its ReadAt method
+func OpenMmap(filepath string) (File, error) {
+	return Open(filepath)
+}
@@ -0,0 +1,71 @@
+package evtx
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func dumpRecords(t *testing.T, ef *File) string {
+	t.Helper()
+	w := new(bytes.Buffer)
+	for r := range ef.Records() {
+		if r.Err != nil {
+			t.Errorf("record %d: %s", r.ID, r.Err)
+			continue
+		}
+		x, err := r.XML()
+		if err != nil {
+			t.Errorf("record %d: %s", r.ID, err)
+		}
+		fmt.Fprintf(w, "%s\n%s\n", ToJSON(r.Metadata()), x)
+	}
+	return w.String()
+}
+
+func TestFileAccess(t *testing.T) {
+	data, _ := fxBulkFile(t, 4)
+	path := filepath.Join(t.TempDir(), "bulk.evtx")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ef, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dumpRecords(t, &ef)
+
+	for _, tc := range []struct {
+		name string
+		open func() (File, error)
+	}{
+		{"Open", func() (File, error) { return Open(path) }},
+		{"OpenMmap", func() (File, error) { return OpenMmap(path) }},
+		{"NewReaderAt", func() (File, error) { return NewReaderAt(bytes.NewReader(data), int64(len(data))) }},
+		{"OpenWithOptions", func() (File, error) { return OpenWithOptions(path, Options{Mmap: true, Dirty: true, Workers: 4}) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ef, err := tc.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ef.Close()
+			ef.Workers = 4
+			if got := dumpRecords(t, &ef); got != want {
+				t.Errorf("records differ from the io.ReadSeeker ones")
+			}
+		})
+	}
+}
+
+func TestOpenMmapEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.evtx")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenMmap(path); err == nil {
+		t.Error("expected an error opening an empty file")
+	}
+}
@@ -0,0 +1,69 @@
+package evtx
+
+import (
+	"io"
+)
+
+type Options struct {
+	Workers       int
+	PreserveOrder bool
+	CheckSumMode  CheckSumMode
+	Dirty         bool
+	// maps the file in memory, see OpenMmap
+	Mmap bool
+	// zero keeps DefaultLimits
+	Limits Limits
+}
+
+func (ef *File) SetOptions(opts Options) {
+	ef.Workers = opts.Workers
+	ef.PreserveOrder = opts.PreserveOrder
+	ef.CheckSumMode = opts.CheckSumMode
+	if opts.Limits != (Limits{}) {
+		ef.Limits = opts.Limits
+	}
+}
+
+func NewWithOptions(r io.ReadSeeker, opts Options) (ef File, err error) {
+	ef, err = New(r)
+	ef.SetOptions(opts)
+	return
+}
+
+func OpenWithOptions(filepath string, opts Options) (ef File, err error) {
+	open := Open
+	if opts.Mmap {
+		open = OpenMmap
+	}
+	if ef, err = open(filepath); err == ErrDirtyFile && opts.Dirty {
+		err = ef.Header.Repair(ef.file)
+	}
+	ef.SetOptions(opts)
+	return
+}
+
+func (ef *File) workers() int {
+	switch {
+	case ef.Workers > 0:
+		return ef.Workers
+	case MaxJobs > 0:
+		return MaxJobs
+	}
+	return 1
+}
+
+func (ef *File) readAt(p []byte, offset int64) (n int, err error) {
+	if ra, ok := ef.file.(io.ReaderAt); ok {
+		n, err = ra.ReadAt(p, offset)
+	} else {
+		ef.Lock()
+		GoToSeeker(ef.file, offset)
+		n, err = io.ReadFull(ef.file, p)
+		ef.Unlock()
+	}
+	// a truncated chunk is still worth decoding
+	if n > 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,181 @@
+package evtx
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sort"
+)
+
+type recordHeap []*Record
+
+func (h recordHeap) Len() int {
+	return len(h)
+}
+
+func (h recordHeap) Less(i, j int) bool {
+	return h[i].ID < h[j].ID
+}
+
+func (h recordHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *recordHeap) Push(x interface{}) {
+	*h = append(*h, x.(*Record))
+}
+
+func (h *recordHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+func (ef *File) SortedChunks() (ChunkSorter, error) {
+	var firstErr error
+	offsets := ef.chunkOffsets()
+	chunks := make(ChunkSorter, 0, len(offsets))
+	for _, offsetChunk := range offsets {
+		c, err := ef.FetchRawChunk(offsetChunk)
+		switch {
+		case err == io.EOF:
+			continue
+		case err != nil:
+			if firstErr == nil {
+				firstErr = &ParseError{offsetChunk, -1, -1, err}
+			}
+			continue
+		}
+		if c.Header.Verify() != nil {
+			continue
+		}
+		chunks = append(chunks, c)
+	}
+	sort.Stable(chunks)
+	return chunks, firstErr
+}
+
+func (ef *File) Records() (cr chan *Record) {
+	return ef.RecordsContext(context.Background())
+}
+
+func (ef *File) RecordsContext(ctx context.Context) (cr chan *Record) {
+	cr = make(chan *Record, 42)
+	go func() {
+		defer close(cr)
+		ef.orderedRecords(ctx, -1, false, func(r *Record) bool {
+			select {
+			case cr <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return
+}
+
+func (ef *File) Events() (cgem chan *GoEvtxMap) {
+	return ef.EventsContext(context.Background())
+}
+
+func (ef *File) EventsContext(ctx context.Context) (cgem chan *GoEvtxMap) {
+	return eventsOf(ctx, ef.RecordsContext(ctx))
+}
+
+func (ef *File) Walk(ctx context.Context, walkFn func(*Record) error) error {
+	wctx, cancel := context.WithCancel(ctx)
+	records := ef.RecordsContext(wctx)
+	defer drainRecords(cancel, records)
+	for r := range records {
+		if err := walkFn(r); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (ef *File) orderedRecords(ctx context.Context, after int64, live bool, emit func(*Record) bool) int64 {
+	sorted, err := ef.SortedChunks()
+	if err != nil && !emit(&Record{ChunkOffset: err.(*ParseError).ChunkOffset, ID: -1, Err: err}) {
+		return after
+	}
+	chunks := make(ChunkSorter, 0, len(sorted))
+	isLive := func(i int, c Chunk) bool {
+		return live && (i == len(sorted)-1 || c.Offset == ef.liveChunkOffset())
+	}
+	for i, c := range sorted {
+		if c.Header.LastEventRecID > after || isLive(i, c) {
+			chunks = append(chunks, c)
+		}
+	}
+
+	// lowest first record ID of the chunks not yet decoded, needed
+	// when chunk headers do not agree on the ordering
+	nextFirstID := make([]int64, len(chunks)+1)
+	nextFirstID[len(chunks)] = -1
+	for i := len(chunks) - 1; i >= 0; i-- {
+		nextFirstID[i] = chunks[i].Header.FirstEventRecID
+		if i+1 < len(chunks) && nextFirstID[i+1] < nextFirstID[i] {
+			nextFirstID[i] = nextFirstID[i+1]
+		}
+	}
+
+	decode := func(offset int64) []*Record {
+		c, err := ef.FetchChunk(offset)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return c.recordsUntilError(err)
+		case live:
+			c.ParseAppendedEventOffsets()
+		}
+		return c.records()
+	}
+	offsets := make([]int64, len(chunks))
+	for i, c := range chunks {
+		offsets[i] = c.Offset
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	batches := ef.decodeChunks(ctx, offsets, true, decode)
+	// the workers decoding when stopped are waited for
+	defer drainBatches(cancel, batches)
+
+	last := after
+	pending := make(recordHeap, 0)
+	pop := func() bool {
+		r := heap.Pop(&pending).(*Record)
+		last = r.ID
+		return emit(r)
+	}
+	for i := range chunks {
+		for pending.Len() > 0 && pending[0].ID < nextFirstID[i] {
+			if !pop() {
+				return last
+			}
+		}
+		records, ok := <-batches
+		if !ok {
+			return last
+		}
+		for _, r := range records {
+			switch {
+			case r.Err != nil && r.ID < 0:
+				if !emit(r) {
+					return last
+				}
+			case r.ID > after:
+				heap.Push(&pending, r)
+			}
+		}
+	}
+	for pending.Len() > 0 {
+		if !pop() {
+			break
+		}
+	}
+	return last
+}
@@ -0,0 +1,51 @@
+package evtx
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fxWrappedFile is a log which wrapped around, its first chunk holds records
+// newer than those of the chunks following it
+func fxWrappedFile(t *testing.T) []byte {
+	t.Helper()
+	chunk := func(first, last int64) (records []fxRecord) {
+		for id := first; id <= last; id++ {
+			records = append(records, fxRecord{id, fxCreated.Add(time.Duration(id) * time.Second), fxTextInstance(t, id)})
+		}
+		return
+	}
+	return fxFile(t, func(fh *FileHeader) {
+		fh.FirstChunkNum = 2
+		fh.LastChunkNum = 1
+		fh.NextRecordID = 13
+	}, chunk(7, 9), chunk(10, 12), chunk(1, 3), chunk(4, 6))
+}
+
+func TestRecordsWrapped(t *testing.T) {
+	data := fxWrappedFile(t)
+	want := "[1 2 3 4 5 6 7 8 9 10 11 12]"
+	for _, workers := range []int{1, 4} {
+		ef := fxOpen(t, data)
+		ef.Workers = workers
+		if ids := collectIDs(ef.Records()); fmt.Sprint(ids) != want {
+			t.Errorf("%d workers: records %v, expected %s", workers, ids, want)
+		}
+		if ids := collectIDs(ef.RecordsContext(context.Background())); fmt.Sprint(ids) != want {
+			t.Errorf("%d workers: context records %v, expected %s", workers, ids, want)
+		}
+	}
+}
+
+func TestRecordsPreserveOrder(t *testing.T) {
+	ef := fxOpen(t, fxWrappedFile(t))
+	ef.Workers = 4
+	ef.PreserveOrder = true
+	// the order of the chunks in the file
+	want := "[7 8 9 10 11 12 1 2 3 4 5 6]"
+	if ids := collectIDs(ef.UnorderedRecords()); fmt.Sprint(ids) != want {
+		t.Errorf("records %v, expected %s", ids, want)
+	}
+}
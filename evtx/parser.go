@@ -7,7 +7,8 @@ import (
 )
 
 func checkParsingError(err error, e Element) {
-	if err != nil {
+	// limit errors are reported once, with the record
+	if err != nil && !IsLimitError(err) {
 		log.DontPanicf("%s: parsing %T", err, e)
 	}
 }
@@ -20,6 +21,15 @@ func (e ErrUnknownToken) Error() string {
 	return fmt.Sprintf("Unknown Token: 0x%02x", e.Token)
 }
 
+type ErrSubstitutionOutOfRange struct {
+	SubID     int
+	NumValues int
+}
+
+func (e ErrSubstitutionOutOfRange) Error() string {
+	return fmt.Sprintf("substitution %d out of range (%d values)", e.SubID, e.NumValues)
+}
+
 func Parse(reader io.ReadSeeker, c *Chunk, tiFlag bool) (Element, error) {
 	var token [1]byte
 	var err error
@@ -31,10 +41,21 @@ func Parse(reader io.ReadSeeker, c *Chunk, tiFlag bool) (Element, error) {
 	if err != nil {
 		return EmptyElement{}, err
 	}
+	b := budgetOf(reader)
+	if err = b.element(1); err != nil {
+		return EmptyElement{}, err
+	}
+	// the depth entered is left on error too
+	defer b.leave(1)
+	if err = b.enter(); err != nil {
+		return EmptyElement{}, err
+	}
 	switch token[0] {
 	case FragmentHeaderToken:
 		f := Fragment{}
-		err = f.Parse(reader)
+		if err = f.Parse(reader); err != nil {
+			return &f, err
+		}
 		f.BinXMLElement, err = Parse(reader, c, tiFlag)
 		if err != nil {
 			return &f, err
@@ -44,12 +65,19 @@ func Parse(reader io.ReadSeeker, c *Chunk, tiFlag bool) (Element, error) {
 			var ti TemplateInstance
 			ti.Definition.Data.Elements = make([]Element, 0)
 
+			open := 0
+			defer func() { b.leave(open) }()
 			ti.Definition.Data.Elements = append(ti.Definition.Data.Elements, f.BinXMLElement.(*ElementStart))
-			for e, err = Parse(reader, c, tiFlag); err == nil; e, err = Parse(reader, c, tiFlag) {
+			err = b.nest(f.BinXMLElement, &open)
+			for err == nil {
+				if e, err = Parse(reader, c, tiFlag); err != nil {
+					break
+				}
 				ti.Definition.Data.Elements = append(ti.Definition.Data.Elements, e)
 				if _, ok := e.(*BinXMLEOF); ok {
 					break
 				}
+				err = b.nest(e, &open)
 			}
 			f.BinXMLElement = &ti
 		}
@@ -76,12 +104,27 @@ func Parse(reader io.ReadSeeker, c *Chunk, tiFlag bool) (Element, error) {
 	case TokenTemplateInstance:
 		var offset int32
 		ti := TemplateInstance{}
-		if c != nil {
+		if c != nil || budgetOf(reader) != nil {
 			offset, err = ti.DataOffset(reader)
 			if err != nil {
 				return nil, err
 			}
-			if t, ok := c.TemplateTable[offset]; ok {
+			var t TemplateDefinitionData
+			ok := false
+			if c != nil {
+				t, ok = c.TemplateTable[offset]
+			}
+			if !ok {
+				// defined in another chunk or in a previous BinXML value, the
+				// inline definition is skipped
+				if t, ok = cachedTemplate(reader, offset); ok && c != nil {
+					c.TemplateTable[offset] = t
+				}
+			}
+			if ok {
+				if err = b.element(len(t.Elements)); err != nil {
+					return nil, err
+				}
 				err = ti.ParseTemplateDefinitionHeader(reader)
 				if err != nil {
 					return nil, err
@@ -98,6 +141,9 @@ func Parse(reader io.ReadSeeker, c *Chunk, tiFlag bool) (Element, error) {
 			}
 		}
 		err = ti.Parse(reader)
+		if err == nil {
+			ti.Definition.Data = cacheTemplate(reader, ti.Definition.Data)
+		}
 		if c != nil {
 			c.TemplateTable[ti.Definition.Header.DataOffset] = ti.Definition.Data
 		}
@@ -142,6 +188,9 @@ func Parse(reader io.ReadSeeker, c *Chunk, tiFlag bool) (Element, error) {
 func ParseValueReader(vd ValueDescriptor, reader io.ReadSeeker) (Element, error) {
 	var err error
 	t := vd.ValType
+	if err = budgetOf(reader).allocate(int64(vd.Size)); err != nil {
+		return &UnkVal{BackupSeeker(reader), t, vd}, err
+	}
 	switch {
 	case t.IsType(NullType):
 		n := ValueNull{Size: vd.Size}
@@ -226,7 +275,7 @@ func ParseValueReader(vd ValueDescriptor, reader io.ReadSeeker) (Element, error)
 	case t.IsType(BinXmlType):
 		var elt Element
 		elt, err = Parse(reader, nil, true)
-		if err != nil {
+		if err != nil && !IsLimitError(err) {
 			log.Error(err)
 		}
 		return elt, err
@@ -245,9 +294,6 @@ func ParseValueReader(vd ValueDescriptor, reader io.ReadSeeker) (Element, error)
 	default:
 		uv := UnkVal{BackupSeeker(reader), t, vd}
 		_, err = reader.Seek(int64(vd.Size), io.SeekCurrent)
-		if err != nil {
-			panic(err)
-		}
-		return &uv, nil
+		return &uv, err
 	}
 }
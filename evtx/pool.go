@@ -0,0 +1,101 @@
+package evtx
+
+import (
+	"context"
+	"sync"
+)
+
+type chunkJob struct {
+	offset  int64
+	records chan []*Record
+}
+
+// decodeChunks fetches and decodes the chunks at offsets with a pool of
+// workers, batches come out in offsets order only when ordered is set. The
+// channel returned is closed once every worker is done, cancelled or not, so
+// that nothing reads the file anymore when it is drained.
+func (ef *File) decodeChunks(ctx context.Context, offsets []int64, ordered bool, decode func(int64) []*Record) (batches chan []*Record) {
+	workers := ef.workers()
+	jobs := make(chan chunkJob)
+	results := make(chan []*Record, workers)
+	queue := make(chan chan []*Record, workers)
+
+	go func() {
+		defer close(jobs)
+		defer close(queue)
+		for _, offset := range offsets {
+			job := chunkJob{offset, results}
+			if ordered {
+				job.records = make(chan []*Record, 1)
+				select {
+				case queue <- job.records:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				select {
+				case job.records <- decode(job.offset):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	if !ordered {
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+		return results
+	}
+
+	batches = make(chan []*Record)
+	go func() {
+		defer func() {
+			wg.Wait()
+			close(batches)
+		}()
+		for records := range queue {
+			select {
+			case batch := <-records:
+				select {
+				case batches <- batch:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return
+}
+
+// drainBatches stops the decoding and waits for the workers to be done
+func drainBatches(cancel context.CancelFunc, batches chan []*Record) {
+	cancel()
+	for range batches {
+	}
+}
+
+// drainRecords stops a stream of records and waits for it to be closed
+func drainRecords(cancel context.CancelFunc, records chan *Record) {
+	cancel()
+	for range records {
+	}
+}
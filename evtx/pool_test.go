@@ -0,0 +1,140 @@
+package evtx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closedReader counts the chunks read while or after it is closed, reads are
+// slowed down for workers to be in flight when the iteration stops
+type closedReader struct {
+	*bytes.Reader
+	inflight int32
+	closed   int32
+	late     int32
+}
+
+func (r *closedReader) ReadAt(p []byte, off int64) (int, error) {
+	if atomic.LoadInt32(&r.closed) != 0 {
+		atomic.AddInt32(&r.late, 1)
+	}
+	atomic.AddInt32(&r.inflight, 1)
+	defer atomic.AddInt32(&r.inflight, -1)
+	time.Sleep(time.Millisecond)
+	return r.Reader.ReadAt(p, off)
+}
+
+func (r *closedReader) Close() error {
+	atomic.StoreInt32(&r.closed, 1)
+	if atomic.LoadInt32(&r.inflight) != 0 {
+		atomic.AddInt32(&r.late, 1)
+	}
+	return nil
+}
+
+func TestCancel(t *testing.T) {
+	data, _ := fxBulkFile(t, 16)
+	errStop := errors.New("stop")
+
+	for _, tc := range []struct {
+		name string
+		// iterate stops the iteration after n records and returns once the
+		// channels are drained
+		iterate func(ef *File, n int) error
+	}{
+		{"RecordsContext", func(ef *File, n int) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			records := ef.RecordsContext(ctx)
+			for range records {
+				if n--; n == 0 {
+					cancel()
+				}
+			}
+			return ctx.Err()
+		}},
+		{"UnorderedRecordsContext", func(ef *File, n int) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for range ef.UnorderedRecordsContext(ctx) {
+				if n--; n == 0 {
+					cancel()
+				}
+			}
+			return ctx.Err()
+		}},
+		{"EventsContext", func(ef *File, n int) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for range ef.EventsContext(ctx) {
+				if n--; n == 0 {
+					cancel()
+				}
+			}
+			return ctx.Err()
+		}},
+		{"Walk", func(ef *File, n int) error {
+			return ef.Walk(context.Background(), func(*Record) error {
+				if n--; n == 0 {
+					return errStop
+				}
+				return nil
+			})
+		}},
+		{"Walk cancelled", func(ef *File, n int) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			return ef.Walk(ctx, func(*Record) error {
+				if n--; n == 0 {
+					cancel()
+				}
+				return nil
+			})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &closedReader{Reader: bytes.NewReader(data)}
+			ef, err := New(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ef.Workers = 4
+			err = tc.iterate(&ef, 5)
+			if !errors.Is(err, errStop) && !errors.Is(err, context.Canceled) {
+				t.Errorf("unexpected error %v", err)
+			}
+			if err := ef.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// reads left running would still be going on
+			time.Sleep(10 * time.Millisecond)
+			if late := atomic.LoadInt32(&r.late); late != 0 {
+				t.Errorf("%d chunks read after the iteration stopped", late)
+			}
+		})
+	}
+}
+
+func TestWalk(t *testing.T) {
+	data, events := fxBulkFile(t, 4)
+	ef := fxOpen(t, data)
+	ef.Workers = 4
+	last := int64(0)
+	err := ef.Walk(context.Background(), func(r *Record) error {
+		if r.Err != nil {
+			return r.Err
+		}
+		if r.ID != last+1 {
+			t.Errorf("record %d walked after %d", r.ID, last)
+		}
+		last = r.ID
+		return nil
+	})
+	if err != nil || last != int64(events) {
+		t.Errorf("walked %d records out of %d: %v", last, events, err)
+	}
+}
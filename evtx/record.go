@@ -0,0 +1,111 @@
+package evtx
+
+import (
+	"context"
+	"fmt"
+
+	"rawsec-evtx/log"
+)
+
+type ParseError struct {
+	ChunkOffset int64
+	Offset      int64
+	RecordID    int64
+	Err         error
+}
+
+func (e *ParseError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("chunk @ 0x%08x: %s", e.ChunkOffset, e.Err)
+	}
+	return fmt.Sprintf("chunk @ 0x%08x, record %d @ 0x%04x: %s", e.ChunkOffset, e.RecordID, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+type RecordMetadata struct {
+	ID          int64
+	Timestamp   UTCTime
+	ChunkIndex  int
+	ChunkOffset int64
+	Offset      int64
+	Size        int32
+}
+
+type Record struct {
+	ChunkOffset int64
+	ChunkIndex  int
+	Offset      int64
+	Size        int32
+	ID          int64
+	Timestamp   UTCTime
+	Event       *GoEvtxMap
+	System      *EventSystem
+	SystemErr   error
+	Err         error
+	chunk       *Chunk
+	offset      int64
+}
+
+func (r *Record) Metadata() RecordMetadata {
+	return RecordMetadata{
+		ID:          r.ID,
+		Timestamp:   r.Timestamp,
+		ChunkIndex:  r.ChunkIndex,
+		ChunkOffset: r.ChunkOffset,
+		Offset:      r.Offset,
+		Size:        r.Size,
+	}
+}
+
+func (r *Record) GoEvtxMap() *GoEvtxMap {
+	if r.Event == nil {
+		return nil
+	}
+	gem := make(GoEvtxMap, len(*r.Event)+1)
+	for k, v := range *r.Event {
+		gem[k] = v
+	}
+	gem[RecordMetadataKey] = r.Metadata()
+	return &gem
+}
+
+func (r *Record) XML() ([]byte, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.chunk == nil {
+		return nil, ErrInvalidEvent
+	}
+	event, err := r.chunk.ParseEvent(r.offset)
+	if err != nil {
+		return nil, err
+	}
+	return event.XML(r.chunk)
+}
+
+func eventsOf(ctx context.Context, records chan *Record) (cgem chan *GoEvtxMap) {
+	cgem = make(chan *GoEvtxMap, 42)
+	go func() {
+		defer close(cgem)
+		// the records are produced until the context is done
+		defer func() {
+			for range records {
+			}
+		}()
+		for r := range records {
+			if r.Err != nil {
+				log.Error(r.Err)
+				continue
+			}
+			select {
+			case cgem <- r.Event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return
+}
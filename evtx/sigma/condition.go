@@ -0,0 +1,198 @@
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// compileCondition compiles the condition of a rule, a list of conditions
+// matches when one of them does
+func compileCondition(condition interface{}, searches map[string]node) (node, error) {
+	switch condition.(type) {
+	case nil:
+		return nil, fmt.Errorf("missing condition")
+	case string:
+		return parseCondition(condition.(string), searches)
+	case []interface{}:
+		var any anyOf
+		for _, c := range condition.([]interface{}) {
+			s, ok := c.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected condition of type %T", c)
+			}
+			n, err := parseCondition(s, searches)
+			if err != nil {
+				return nil, err
+			}
+			any = append(any, n)
+		}
+		return any, nil
+	}
+	return nil, fmt.Errorf("unexpected condition of type %T", condition)
+}
+
+type conditionParser struct {
+	condition string
+	tokens    []string
+	pos       int
+	searches  map[string]node
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *conditionParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("condition %q: %s", p.condition, fmt.Sprintf(format, args...))
+}
+
+func (p *conditionParser) unexpected() error {
+	if t := p.peek(); t != "" {
+		return p.errorf("unexpected %s", t)
+	}
+	return p.errorf("unexpected end")
+}
+
+func parseCondition(condition string, searches map[string]node) (node, error) {
+	if strings.Contains(condition, "|") {
+		return nil, fmt.Errorf("%w: aggregation in condition %q", ErrUnsupported, condition)
+	}
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(condition))
+	p := conditionParser{condition: condition, tokens: tokens, searches: searches}
+	if len(tokens) == 0 {
+		return nil, p.errorf("empty")
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, p.unexpected()
+	}
+	return n, nil
+}
+
+func (p *conditionParser) parseOr() (node, error) {
+	var any anyOf
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		any = append(any, n)
+		if !strings.EqualFold(p.peek(), "or") {
+			break
+		}
+		p.next()
+	}
+	if len(any) == 1 {
+		return any[0], nil
+	}
+	return any, nil
+}
+
+func (p *conditionParser) parseAnd() (node, error) {
+	var all allOf
+	for {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, n)
+		if !strings.EqualFold(p.peek(), "and") {
+			break
+		}
+		p.next()
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return all, nil
+}
+
+func (p *conditionParser) parseNot() (node, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *conditionParser) parsePrimary() (node, error) {
+	t := p.next()
+	switch strings.ToLower(t) {
+	case "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			p.pos--
+			return nil, p.unexpected()
+		}
+		return n, nil
+	case "1", "all", "any":
+		if !strings.EqualFold(p.peek(), "of") {
+			break
+		}
+		p.next()
+		return p.parseOf(strings.ToLower(t) == "all")
+	case "", ")", "and", "or", "of", "them":
+		p.pos--
+		return nil, p.unexpected()
+	}
+	if n, ok := p.searches[t]; ok {
+		return n, nil
+	}
+	return nil, p.errorf("unknown search identifier %s", t)
+}
+
+// parseOf parses the searches of "1 of" and "all of", a pattern or "them" for
+// all the searches but those starting with an underscore
+func (p *conditionParser) parseOf(all bool) (node, error) {
+	target := p.next()
+	var names []string
+	for name := range p.searches {
+		switch {
+		case target == "them":
+			if !strings.HasPrefix(name, "_") {
+				names = append(names, name)
+			}
+		default:
+			if ok, err := path.Match(target, name); err != nil {
+				return nil, p.errorf("invalid pattern %s", target)
+			} else if ok {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, p.errorf("no search identifier matching %s", target)
+	}
+	sort.Strings(names)
+	nodes := make([]node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, p.searches[name])
+	}
+	if all {
+		return allOf(nodes), nil
+	}
+	return anyOf(nodes), nil
+}
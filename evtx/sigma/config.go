@@ -0,0 +1,125 @@
+package sigma
+
+import (
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config tells how rules apply to the events
+type Config struct {
+	// element paths of Sigma fields, as understood by filter.NewField, fields
+	// not mapped are looked up by name
+	FieldMapping map[string]string `yaml:"fieldmappings"`
+	// filter expressions selecting the events of log source services and
+	// categories, rules of unknown log sources apply to all the events
+	Services   map[string]string `yaml:"services"`
+	Categories map[string]string `yaml:"categories"`
+}
+
+const (
+	sysmonChannel     = "Channel == 'Microsoft-Windows-Sysmon/Operational'"
+	powershellChannel = "Channel == 'Microsoft-Windows-PowerShell/Operational'"
+	classicChannel    = "Channel == 'Windows PowerShell'"
+)
+
+var DefaultConfig = Config{
+	FieldMapping: map[string]string{
+		"Provider_Name": "/Event/System/Provider/Name",
+		"ProviderName":  "/Event/System/Provider/Name",
+		"EventID":       "/Event/System/EventID",
+		"Channel":       "/Event/System/Channel",
+		"Computer":      "/Event/System/Computer",
+	},
+	Services: map[string]string{
+		"security":                             "Channel == Security",
+		"system":                               "Channel == System",
+		"application":                          "Channel == Application",
+		"sysmon":                               sysmonChannel,
+		"powershell":                           powershellChannel,
+		"powershell-classic":                   classicChannel,
+		"taskscheduler":                        "Channel == 'Microsoft-Windows-TaskScheduler/Operational'",
+		"wmi":                                  "Channel == 'Microsoft-Windows-WMI-Activity/Operational'",
+		"windefend":                            "Channel == 'Microsoft-Windows-Windows Defender/Operational'",
+		"bits-client":                          "Channel == 'Microsoft-Windows-Bits-Client/Operational'",
+		"dns-server":                           "Channel == 'DNS Server'",
+		"driver-framework":                     "Channel == 'Microsoft-Windows-DriverFrameworks-UserMode/Operational'",
+		"firewall-as":                          "Channel == 'Microsoft-Windows-Windows Firewall With Advanced Security/Firewall'",
+		"terminalservices-localsessionmanager": "Channel == 'Microsoft-Windows-TerminalServices-LocalSessionManager/Operational'",
+		"codeintegrity-operational":            "Channel == 'Microsoft-Windows-CodeIntegrity/Operational'",
+		"applocker": "Channel in ('Microsoft-Windows-AppLocker/MSI and Script', 'Microsoft-Windows-AppLocker/EXE and DLL'," +
+			" 'Microsoft-Windows-AppLocker/Packaged app-Deployment', 'Microsoft-Windows-AppLocker/Packaged app-Execution')",
+	},
+	Categories: map[string]string{
+		"process_creation":          sysmonChannel + " and EventID == 1",
+		"file_change":               sysmonChannel + " and EventID == 2",
+		"network_connection":        sysmonChannel + " and EventID == 3",
+		"sysmon_status":             sysmonChannel + " and EventID in (4, 16)",
+		"process_termination":       sysmonChannel + " and EventID == 5",
+		"driver_load":               sysmonChannel + " and EventID == 6",
+		"image_load":                sysmonChannel + " and EventID == 7",
+		"create_remote_thread":      sysmonChannel + " and EventID == 8",
+		"raw_access_thread":         sysmonChannel + " and EventID == 9",
+		"process_access":            sysmonChannel + " and EventID == 10",
+		"file_event":                sysmonChannel + " and EventID == 11",
+		"registry_add":              sysmonChannel + " and EventID == 12",
+		"registry_delete":           sysmonChannel + " and EventID == 12",
+		"registry_set":              sysmonChannel + " and EventID == 13",
+		"registry_rename":           sysmonChannel + " and EventID == 14",
+		"registry_event":            sysmonChannel + " and EventID in (12, 13, 14)",
+		"create_stream_hash":        sysmonChannel + " and EventID == 15",
+		"pipe_created":              sysmonChannel + " and EventID in (17, 18)",
+		"wmi_event":                 sysmonChannel + " and EventID in (19, 20, 21)",
+		"dns_query":                 sysmonChannel + " and EventID == 22",
+		"file_delete":               sysmonChannel + " and EventID in (23, 26)",
+		"clipboard_capture":         sysmonChannel + " and EventID == 24",
+		"process_tampering":         sysmonChannel + " and EventID == 25",
+		"file_delete_detected":      sysmonChannel + " and EventID == 26",
+		"file_block_executable":     sysmonChannel + " and EventID == 27",
+		"file_block_shredding":      sysmonChannel + " and EventID == 28",
+		"file_executable_detected":  sysmonChannel + " and EventID == 29",
+		"ps_module":                 powershellChannel + " and EventID == 4103",
+		"ps_script":                 powershellChannel + " and EventID == 4104",
+		"ps_classic_start":          classicChannel + " and EventID == 400",
+		"ps_classic_provider_start": classicChannel + " and EventID == 600",
+		"ps_classic_script":         classicChannel + " and EventID == 800",
+	},
+}
+
+// LoadConfig reads a YAML configuration, its entries are added to those of
+// DefaultConfig
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return DefaultConfig.Merge(&c), nil
+}
+
+// Merge returns a copy of the configuration overridden by other
+func (c *Config) Merge(other *Config) *Config {
+	return &Config{
+		FieldMapping: merge(false, c.FieldMapping, other.FieldMapping),
+		Services:     merge(true, c.Services, other.Services),
+		Categories:   merge(true, c.Categories, other.Categories),
+	}
+}
+
+// merge merges maps, log sources are not case sensitive
+func merge(lower bool, maps ...map[string]string) map[string]string {
+	out := make(map[string]string)
+	for _, m := range maps {
+		for k, v := range m {
+			if lower {
+				k = strings.ToLower(k)
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
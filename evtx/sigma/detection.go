@@ -0,0 +1,453 @@
+package sigma
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"rawsec-evtx/evtx"
+	"rawsec-evtx/evtx/filter"
+)
+
+type node interface {
+	match(e *evtx.GoEvtxMap) bool
+}
+
+type anyOf []node
+
+func (n anyOf) match(e *evtx.GoEvtxMap) bool {
+	for _, c := range n {
+		if c.match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+type allOf []node
+
+func (n allOf) match(e *evtx.GoEvtxMap) bool {
+	for _, c := range n {
+		if !c.match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+type notNode struct {
+	n node
+}
+
+func (n *notNode) match(e *evtx.GoEvtxMap) bool {
+	return !n.n.match(e)
+}
+
+// compileSearch compiles a search identifier, a map of fields all matching, a
+// list of such maps one of which matching, or a list of keywords
+func compileSearch(def interface{}, config *Config) (node, error) {
+	switch def.(type) {
+	case map[string]interface{}:
+		return compileFields(def.(map[string]interface{}), config)
+	case []interface{}:
+		list := def.([]interface{})
+		var keywords []interface{}
+		var any anyOf
+		for _, item := range list {
+			if m, ok := item.(map[string]interface{}); ok {
+				n, err := compileFields(m, config)
+				if err != nil {
+					return nil, err
+				}
+				any = append(any, n)
+				continue
+			}
+			keywords = append(keywords, item)
+		}
+		if len(keywords) > 0 {
+			if len(any) > 0 {
+				return nil, fmt.Errorf("mixed keywords and field maps")
+			}
+			return compileKeywords(keywords)
+		}
+		return any, nil
+	case string, int, float64:
+		return compileKeywords([]interface{}{def})
+	}
+	return nil, fmt.Errorf("unexpected search of type %T", def)
+}
+
+func compileFields(m map[string]interface{}, config *Config) (node, error) {
+	// sorted for the errors to be reproducible
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	all := make(allOf, 0, len(keys))
+	for _, k := range keys {
+		fm, err := compileField(k, m[k], config)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		all = append(all, fm)
+	}
+	return all, nil
+}
+
+type modifiers struct {
+	match     string
+	all       bool
+	wide      bool
+	base64    bool
+	offset    bool
+	windash   bool
+	reFlags   string
+	exists    bool
+	hasExists bool
+}
+
+func parseModifiers(mods []string) (m modifiers, err error) {
+	for _, mod := range mods {
+		switch mod = strings.ToLower(mod); mod {
+		case "contains", "startswith", "endswith", "re", "cidr", "lt", "lte", "gt", "gte":
+			if m.match != "" {
+				return m, fmt.Errorf("modifiers %s and %s", m.match, mod)
+			}
+			m.match = mod
+		case "exists":
+			m.hasExists = true
+		case "all":
+			m.all = true
+		case "wide", "utf16le":
+			m.wide = true
+		case "base64":
+			m.base64 = true
+		case "base64offset":
+			m.base64, m.offset = true, true
+		case "windash":
+			m.windash = true
+		case "i", "m", "s":
+			// regexp flags
+			m.reFlags += mod
+		case "expand", "fieldref":
+			return m, fmt.Errorf("%w: modifier %s", ErrUnsupported, mod)
+		default:
+			return m, fmt.Errorf("unknown modifier %s", mod)
+		}
+	}
+	if m.reFlags != "" && m.match != "re" {
+		return m, fmt.Errorf("regexp flags without re modifier")
+	}
+	return m, nil
+}
+
+type fieldMatcher struct {
+	field filter.Field
+	mods  modifiers
+	// null matches missing or empty fields
+	null   bool
+	values []func(string) bool
+}
+
+func compileField(key string, def interface{}, config *Config) (node, error) {
+	parts := strings.Split(key, "|")
+	name := parts[0]
+	mods, err := parseModifiers(parts[1:])
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		// keywords with modifiers
+		return nil, fmt.Errorf("%w: field without name", ErrUnsupported)
+	}
+	if path, ok := config.FieldMapping[name]; ok {
+		name = path
+	}
+	fm := &fieldMatcher{field: filter.NewField(name), mods: mods}
+
+	values, ok := def.([]interface{})
+	if !ok {
+		values = []interface{}{def}
+	}
+	if mods.hasExists {
+		if len(values) != 1 {
+			return nil, fmt.Errorf("exists expects a boolean")
+		}
+		b, ok := values[0].(bool)
+		if !ok {
+			return nil, fmt.Errorf("exists expects a boolean")
+		}
+		fm.mods.exists = b
+		return fm, nil
+	}
+	for _, v := range values {
+		if v == nil {
+			fm.null = true
+			continue
+		}
+		s, err := scalar(v)
+		if err != nil {
+			return nil, err
+		}
+		match, err := compileValue(s, mods)
+		if err != nil {
+			return nil, err
+		}
+		fm.values = append(fm.values, match)
+	}
+	return fm, nil
+}
+
+func (fm *fieldMatcher) match(e *evtx.GoEvtxMap) bool {
+	values, ok := fm.field.Values(e)
+	if fm.mods.hasExists {
+		return ok == fm.mods.exists
+	}
+	if fm.null && (!ok || empty(values)) {
+		return true
+	}
+	if !ok || len(fm.values) == 0 {
+		return false
+	}
+	for _, match := range fm.values {
+		found := false
+		for _, v := range values {
+			if match(v) {
+				found = true
+				break
+			}
+		}
+		if found != fm.mods.all {
+			return found
+		}
+	}
+	return fm.mods.all
+}
+
+func empty(values []string) bool {
+	for _, v := range values {
+		if v != "" {
+			return false
+		}
+	}
+	return true
+}
+
+type keywords []func(string) bool
+
+// compileKeywords compiles values searched in all the values of the events
+func compileKeywords(list []interface{}) (node, error) {
+	var kw keywords
+	for _, v := range list {
+		s, err := scalar(v)
+		if err != nil {
+			return nil, err
+		}
+		match, err := compileValue(s, modifiers{match: "contains"})
+		if err != nil {
+			return nil, err
+		}
+		kw = append(kw, match)
+	}
+	return kw, nil
+}
+
+func (kw keywords) match(e *evtx.GoEvtxMap) bool {
+	return walkValues(map[string]interface{}(*e), func(s string) bool {
+		for _, match := range kw {
+			if match(s) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// walkValues calls fn on the string values found under v until it returns
+// true
+func walkValues(v interface{}, fn func(string) bool) bool {
+	switch v.(type) {
+	case string:
+		return fn(v.(string))
+	case evtx.GoEvtxMap:
+		return walkValues(map[string]interface{}(v.(evtx.GoEvtxMap)), fn)
+	case map[string]interface{}:
+		for _, c := range v.(map[string]interface{}) {
+			if walkValues(c, fn) {
+				return true
+			}
+		}
+	case []string:
+		for _, s := range v.([]string) {
+			if fn(s) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, c := range v.([]interface{}) {
+			if walkValues(c, fn) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func scalar(v interface{}) (string, error) {
+	switch v.(type) {
+	case string:
+		return v.(string), nil
+	case int:
+		return strconv.Itoa(v.(int)), nil
+	case float64:
+		return strconv.FormatFloat(v.(float64), 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v.(bool)), nil
+	}
+	return "", fmt.Errorf("unexpected value of type %T", v)
+}
+
+// compileValue returns the function matching the values of a field
+func compileValue(value string, mods modifiers) (func(string) bool, error) {
+	switch mods.match {
+	case "re":
+		re, err := regexp.Compile(reFlags(mods.reFlags) + value)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	case "cidr":
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefix = prefix.Masked()
+		return func(s string) bool {
+			addr, err := netip.ParseAddr(s)
+			return err == nil && prefix.Contains(addr.Unmap())
+		}, nil
+	case "lt", "lte", "gt", "gte":
+		return compileNumeric(value, mods.match)
+	}
+
+	var patterns []pattern
+	switch {
+	case mods.base64:
+		for _, b := range encode(value, mods) {
+			patterns = append(patterns, literalPattern(b))
+		}
+	case mods.windash:
+		for _, v := range windash(value) {
+			patterns = append(patterns, parsePattern(v))
+		}
+	default:
+		patterns = []pattern{parsePattern(value)}
+	}
+	matchers := make([]func(string) bool, 0, len(patterns))
+	for _, p := range patterns {
+		switch mods.match {
+		case "contains":
+			p = p.anyPrefix().anySuffix()
+		case "startswith":
+			p = p.anySuffix()
+		case "endswith":
+			p = p.anyPrefix()
+		}
+		matchers = append(matchers, p.compile())
+	}
+	if len(matchers) == 1 {
+		return matchers[0], nil
+	}
+	return func(s string) bool {
+		for _, match := range matchers {
+			if match(s) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func reFlags(flags string) string {
+	if flags == "" {
+		return ""
+	}
+	return "(?" + flags + ")"
+}
+
+func compileNumeric(value, op string) (func(string) bool, error) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s expects a number", op)
+	}
+	return func(s string) bool {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return false
+		}
+		switch op {
+		case "lt":
+			return v < n
+		case "lte":
+			return v <= n
+		case "gt":
+			return v > n
+		}
+		return v >= n
+	}, nil
+}
+
+// encode returns the base64 forms of value, the three shifted ones for
+// base64offset
+func encode(value string, mods modifiers) []string {
+	data := []byte(value)
+	if mods.wide {
+		u := utf16.Encode([]rune(value))
+		data = make([]byte, 0, 2*len(u))
+		for _, c := range u {
+			data = append(data, byte(c), byte(c>>8))
+		}
+	}
+	if !mods.offset {
+		return []string{base64.StdEncoding.EncodeToString(data)}
+	}
+	// the characters depending on the bytes around the value are dropped
+	start := []int{0, 2, 3}
+	end := []int{0, 3, 2}
+	out := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		shifted := append(make([]byte, i), data...)
+		b := base64.StdEncoding.EncodeToString(shifted)
+		b = b[start[i]:]
+		if cut := end[(len(data)+i)%3]; cut > 0 {
+			b = b[:len(b)-cut]
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// windash returns the variants of a command line with its options introduced
+// by the dashes and slash accepted by Windows programs
+func windash(value string) []string {
+	dashes := []string{"-", "/", "–", "—", "―"}
+	out := make([]string, 0, len(dashes))
+	for _, d := range dashes {
+		var b strings.Builder
+		for i := 0; i < len(value); i++ {
+			if value[i] == '-' && (i == 0 || value[i-1] == ' ') {
+				b.WriteString(d)
+			} else {
+				b.WriteByte(value[i])
+			}
+		}
+		out = append(out, b.String())
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package sigma
+
+import (
+	"regexp"
+	"strings"
+)
+
+// patternItem is either literal text, lowercased as values match regardless
+// of the case, or one of the wildcards "*" and "?"
+type patternItem struct {
+	text     string
+	wildcard byte
+}
+
+var anyString = patternItem{wildcard: '*'}
+
+// pattern is a Sigma string value
+type pattern []patternItem
+
+// parsePattern splits a value on its wildcards, a backslash escapes a
+// wildcard or a backslash and is kept before any other character
+func parsePattern(value string) pattern {
+	var p pattern
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			p = append(p, patternItem{text: strings.ToLower(b.String())})
+			b.Reset()
+		}
+	}
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && i+1 < len(value) && strings.IndexByte(`*?\`, value[i+1]) >= 0:
+			b.WriteByte(value[i+1])
+			i++
+		case c == '*' || c == '?':
+			flush()
+			p = append(p, patternItem{wildcard: c})
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return p
+}
+
+// literalPattern is a pattern without wildcards
+func literalPattern(value string) pattern {
+	return pattern{{text: strings.ToLower(value)}}
+}
+
+func (p pattern) anyPrefix() pattern {
+	if len(p) > 0 && p[0] == anyString {
+		return p
+	}
+	return append(pattern{anyString}, p...)
+}
+
+func (p pattern) anySuffix() pattern {
+	if len(p) > 0 && p[len(p)-1] == anyString {
+		return p
+	}
+	return append(p[:len(p):len(p)], anyString)
+}
+
+// compile returns a function matching the pattern, the usual forms are
+// matched without regexp
+func (p pattern) compile() func(string) bool {
+	// no "?" nor consecutive "*"
+	simple := true
+	for i, item := range p {
+		if item.wildcard == '?' || (i > 0 && item.wildcard == p[i-1].wildcard) {
+			simple = false
+		}
+	}
+	switch {
+	case len(p) == 0:
+		return func(s string) bool { return s == "" }
+	case len(p) == 1 && p[0] == anyString:
+		return func(string) bool { return true }
+	case !simple || len(p) > 3:
+	case len(p) == 1:
+		return func(s string) bool { return strings.EqualFold(s, p[0].text) }
+	case len(p) == 3 && p[0] == anyString:
+		return func(s string) bool { return strings.Contains(strings.ToLower(s), p[1].text) }
+	case len(p) == 2 && p[0] == anyString:
+		return func(s string) bool { return strings.HasSuffix(strings.ToLower(s), p[1].text) }
+	case len(p) == 2:
+		return func(s string) bool { return strings.HasPrefix(strings.ToLower(s), p[0].text) }
+	}
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, item := range p {
+		switch item.wildcard {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(item.text))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String()).MatchString
+}
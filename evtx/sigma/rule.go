@@ -0,0 +1,299 @@
+// Package sigma evaluates Sigma detection rules against the events of the evtx
+// package. Fields are resolved like the paths of the filter package, under
+// /Event, /Event/System and /Event/EventData, unless the Config maps them to
+// another path. Log sources are turned into filter expressions selecting the
+// events of their channel.
+//
+// Aggregations, placeholders (expand) and field references (fieldref) are not
+// supported, rules using them fail to load with ErrUnsupported.
+package sigma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"rawsec-evtx/evtx"
+	"rawsec-evtx/evtx/filter"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported sigma feature")
+	ErrNoDetection = errors.New("missing detection")
+)
+
+// RuleError is returned for the rules which cannot be loaded
+type RuleError struct {
+	Path  string
+	Title string
+	Err   error
+}
+
+func (e *RuleError) Error() string {
+	name := e.Path
+	if e.Title != "" {
+		if name != "" {
+			name += ": "
+		}
+		name += e.Title
+	}
+	return fmt.Sprintf("sigma rule %s: %s", name, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+type LogSource struct {
+	Product  string `yaml:"product" json:",omitempty"`
+	Service  string `yaml:"service" json:",omitempty"`
+	Category string `yaml:"category" json:",omitempty"`
+}
+
+// Rule is a compiled Sigma rule, safe for concurrent use
+type Rule struct {
+	Title       string    `yaml:"title"`
+	ID          string    `yaml:"id"`
+	Status      string    `yaml:"status"`
+	Description string    `yaml:"description"`
+	Author      string    `yaml:"author"`
+	Level       string    `yaml:"level"`
+	Tags        []string  `yaml:"tags"`
+	LogSource   LogSource `yaml:"logsource"`
+	// file the rule was loaded from
+	Path string `yaml:"-"`
+
+	Detection map[string]interface{} `yaml:"detection"`
+
+	source    *filter.Filter
+	condition node
+}
+
+// Match returns true if the event is part of the log source of the rule and
+// satisfies its detection
+func (r *Rule) Match(e *evtx.GoEvtxMap) bool {
+	if e == nil || r.condition == nil {
+		return false
+	}
+	if r.source != nil && !r.source.Match(e) {
+		return false
+	}
+	return r.condition.match(e)
+}
+
+func (r *Rule) compile(config *Config) error {
+	if r.Detection == nil {
+		return ErrNoDetection
+	}
+	product := strings.ToLower(r.LogSource.Product)
+	if product != "" && product != "windows" {
+		return fmt.Errorf("%w: product %s", ErrUnsupported, r.LogSource.Product)
+	}
+	var sources []string
+	if expr, ok := config.Services[strings.ToLower(r.LogSource.Service)]; ok && r.LogSource.Service != "" {
+		sources = append(sources, "("+expr+")")
+	}
+	if expr, ok := config.Categories[strings.ToLower(r.LogSource.Category)]; ok && r.LogSource.Category != "" {
+		sources = append(sources, "("+expr+")")
+	}
+	if len(sources) > 0 {
+		f, err := filter.Compile(strings.Join(sources, " and "))
+		if err != nil {
+			return fmt.Errorf("log source: %w", err)
+		}
+		r.source = f
+	}
+
+	searches := make(map[string]node, len(r.Detection))
+	var condition interface{}
+	for name, def := range r.Detection {
+		switch name {
+		case "condition":
+			condition = def
+			continue
+		case "timeframe":
+			// only used by aggregations
+			continue
+		}
+		s, err := compileSearch(def, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		searches[name] = s
+	}
+	c, err := compileCondition(condition, searches)
+	if err != nil {
+		return err
+	}
+	r.condition = c
+	return nil
+}
+
+// ParseRules parses the rules of a YAML stream, the documents of rule
+// collections are merged with their global document
+func ParseRules(data []byte, config *Config) ([]*Rule, error) {
+	if config == nil {
+		config = &DefaultConfig
+	}
+	var rules []*Rule
+	var global, previous map[string]interface{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc map[string]interface{}
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return rules, err
+		}
+		switch doc["action"] {
+		case "global":
+			delete(doc, "action")
+			global = doc
+			continue
+		case "reset":
+			global = nil
+			continue
+		case "repeat":
+			delete(doc, "action")
+			doc = mergeDocuments(previous, doc)
+		}
+		previous = doc
+		r, err := decodeRule(mergeDocuments(global, doc))
+		if err == nil {
+			err = r.compile(config)
+		}
+		if err != nil {
+			title, _ := doc["title"].(string)
+			return rules, &RuleError{Title: title, Err: err}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func decodeRule(doc map[string]interface{}) (*Rule, error) {
+	b, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rule{}
+	return r, yaml.Unmarshal(b, r)
+}
+
+// mergeDocuments returns a copy of base overridden by doc, maps are merged
+func mergeDocuments(base, doc map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(doc))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range doc {
+		bm, ok := merged[k].(map[string]interface{})
+		if dm, dok := v.(map[string]interface{}); ok && dok {
+			v = mergeDocuments(bm, dm)
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// Ruleset is a set of rules evaluated together
+type Ruleset struct {
+	config *Config
+	rules  []*Rule
+}
+
+func NewRuleset(config *Config) *Ruleset {
+	if config == nil {
+		config = &DefaultConfig
+	}
+	return &Ruleset{config: config}
+}
+
+func (rs *Ruleset) Rules() []*Rule {
+	return rs.rules
+}
+
+func (rs *Ruleset) Len() int {
+	return len(rs.rules)
+}
+
+func (rs *Ruleset) Add(rules ...*Rule) {
+	rs.rules = append(rs.rules, rules...)
+}
+
+// LoadFile loads the rules of a YAML file
+func (rs *Ruleset) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules, err := ParseRules(data, rs.config)
+	for _, r := range rules {
+		r.Path = path
+	}
+	rs.Add(rules...)
+	var re *RuleError
+	switch {
+	case errors.As(err, &re):
+		re.Path = path
+	case err != nil:
+		err = &RuleError{Path: path, Err: err}
+	}
+	return err
+}
+
+// Load loads a rule file or the .yml and .yaml files found under a directory,
+// the rules failing to load are reported together in the error returned while
+// the others are kept
+func (rs *Ruleset) Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return rs.LoadFile(path)
+	}
+	var paths []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".yml", ".yaml":
+			if !d.IsDir() {
+				paths = append(paths, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	var errs []error
+	for _, p := range paths {
+		if err := rs.LoadFile(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Match returns the rules matching the event
+func (rs *Ruleset) Match(e *evtx.GoEvtxMap) []*Rule {
+	var matches []*Rule
+	for _, r := range rs.rules {
+		if r.Match(e) {
+			matches = append(matches, r)
+		}
+	}
+	return matches
+}
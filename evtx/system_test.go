@@ -0,0 +1,119 @@
+package evtx
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventSystem(t *testing.T) {
+	ef := fxOpen(t, fxCleanFile(t))
+	got := make(map[int64]*EventSystem)
+	for r := range ef.Records() {
+		if r.Err != nil || r.SystemErr != nil {
+			t.Fatalf("record %d: %v %v", r.ID, r.Err, r.SystemErr)
+		}
+		got[r.ID] = r.System
+	}
+
+	guid, err := ParseGUID(fxProviderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := EventSystem{
+		Provider:      EventProvider{Name: "Fixture-Provider", Guid: guid},
+		EventID:       4624,
+		Qualifiers:    0x4000,
+		Level:         4,
+		Keywords:      0x8020000000000000,
+		TimeCreated:   fxCreated.Add(time.Second),
+		EventRecordID: 1,
+		Channel:       "Security",
+		Computer:      "fixture.example.org",
+		Security:      EventSecurity{UserID: "S-1-5-18"},
+	}
+	// the optional substitutions are null
+	nulls := values
+	nulls.Provider.Guid = GUID{}
+	nulls.Qualifiers = 0
+	nulls.TimeCreated = fxCreated.Add(2 * time.Second)
+	nulls.EventRecordID = 2
+	nulls.Security.UserID = ""
+	// static text of the template
+	text := EventSystem{
+		Provider:      EventProvider{Name: "Fixture-Text"},
+		EventID:       1,
+		TimeCreated:   fxCreated.Add(3 * time.Second),
+		EventRecordID: 3,
+	}
+
+	for id, want := range map[int64]EventSystem{1: values, 2: nulls, 3: text} {
+		if got[id] == nil {
+			t.Errorf("record %d has no System", id)
+			continue
+		}
+		got[id].TimeCreated = got[id].TimeCreated.UTC()
+		if !reflect.DeepEqual(*got[id], want) {
+			t.Errorf("unexpected System of record %d:\n%+v\nexpected\n%+v", id, *got[id], want)
+		}
+	}
+}
+
+func TestEventSystemMissing(t *testing.T) {
+	inst := &fxInstance{&fxTemplate{GUID{'n', 'o', 's', 'y', 's'}, &fxElement{
+		name:  "Event",
+		attrs: []fxAttr{fxXmlns},
+		content: []fxItem{
+			&fxElement{name: "EventData", content: []fxItem{
+				&fxElement{name: "Data", content: []fxItem{fxSub{0, StringType, false}}},
+			}},
+		},
+	}}, []fxValue{{typ: StringType, data: utf16LE("value")}}}
+
+	ef := fxOpen(t, fxFile(t, nil, []fxRecord{{1, fxCreated, inst}}))
+	for r := range ef.Records() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		if r.Event == nil || r.System != nil || !errors.Is(r.SystemErr, ErrNoSystem) {
+			t.Errorf("unexpected record %v, System %v error %v", r.Event, r.System, r.SystemErr)
+		}
+	}
+}
+
+func TestEventSystemBadValues(t *testing.T) {
+	instance := func(guid byte, eventID, systemTime string) *fxInstance {
+		return &fxInstance{tmpl: &fxTemplate{GUID{'b', 'a', 'd', guid}, &fxElement{
+			name:  "Event",
+			attrs: []fxAttr{fxXmlns},
+			content: []fxItem{
+				&fxElement{name: "System", content: []fxItem{
+					&fxElement{name: "Provider", attrs: []fxAttr{{"Name", fxText("Fixture-Bad")}}},
+					&fxElement{name: "EventID", content: []fxItem{fxText(eventID)}},
+					&fxElement{name: "TimeCreated", attrs: []fxAttr{{"SystemTime", fxText(systemTime)}}},
+				}},
+			},
+		}}}
+	}
+
+	for _, tc := range []struct {
+		inst  *fxInstance
+		field string
+	}{
+		{instance(1, "4624x", "2024-02-29T12:34:56.1234567Z"), "EventID"},
+		{instance(2, "70000", "2024-02-29T12:34:56.1234567Z"), "EventID"},
+		{instance(3, "4624", "yesterday"), "TimeCreated/SystemTime"},
+	} {
+		ef := fxOpen(t, fxFile(t, nil, []fxRecord{{1, fxCreated, tc.inst}}))
+		for r := range ef.Records() {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			var se ErrSystemValue
+			if r.Event == nil || r.System != nil || !errors.As(r.SystemErr, &se) || se.Field != tc.field {
+				t.Errorf("expected a %s error, got System %v error %v", tc.field, r.System, r.SystemErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package evtx
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+type TemplateStat struct {
+	GUID GUID
+	Size int32
+	// chunks defining or borrowing the template
+	Chunks int
+	// events instantiating the template
+	Events int
+}
+
+// TemplateCache shares template definitions between the chunks of a file,
+// templates are identified by their GUID and size
+type TemplateCache struct {
+	sync.Mutex
+	templates map[GUID]TemplateDefinitionData
+	// usage of the templates by chunk offset, replaced each time a chunk is
+	// decoded so that following a file does not count events twice
+	usage map[int64]map[GUID]int
+}
+
+func NewTemplateCache() *TemplateCache {
+	return &TemplateCache{
+		templates: make(map[GUID]TemplateDefinitionData),
+		usage:     make(map[int64]map[GUID]int),
+	}
+}
+
+// add caches a template parsed from a chunk and returns the definition to use,
+// the one already known if any
+func (tc *TemplateCache) add(td TemplateDefinitionData) TemplateDefinitionData {
+	if tc == nil {
+		return td
+	}
+	tc.Lock()
+	defer tc.Unlock()
+	guid := GUID(td.ID)
+	if cached, ok := tc.templates[guid]; ok {
+		if cached.Size == td.Size {
+			return cached
+		}
+		// same GUID but another definition, not worth sharing
+		return td
+	}
+	root, _ := NodeTree(td.Elements, 0)
+	td.root = &root
+	tc.templates[guid] = td
+	return td
+}
+
+func (tc *TemplateCache) get(guid GUID, size int32) (td TemplateDefinitionData, ok bool) {
+	if tc == nil {
+		return
+	}
+	tc.Lock()
+	defer tc.Unlock()
+	td, ok = tc.templates[guid]
+	return td, ok && td.Size == size
+}
+
+func (tc *TemplateCache) setUsage(offset int64, usage map[GUID]int) {
+	if tc == nil {
+		return
+	}
+	tc.Lock()
+	defer tc.Unlock()
+	tc.usage[offset] = usage
+}
+
+func (tc *TemplateCache) Len() int {
+	tc.Lock()
+	defer tc.Unlock()
+	return len(tc.templates)
+}
+
+// Stats returns the templates known so far, the most used first
+func (tc *TemplateCache) Stats() []TemplateStat {
+	tc.Lock()
+	defer tc.Unlock()
+	stats := make(map[GUID]*TemplateStat, len(tc.templates))
+	for guid, td := range tc.templates {
+		stats[guid] = &TemplateStat{GUID: guid, Size: td.Size}
+	}
+	for _, usage := range tc.usage {
+		for guid, events := range usage {
+			if s, ok := stats[guid]; ok {
+				s.Chunks++
+				s.Events += events
+			}
+		}
+	}
+	out := make([]TemplateStat, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Events != out[j].Events {
+			return out[i].Events > out[j].Events
+		}
+		return out[i].GUID.String() < out[j].GUID.String()
+	})
+	return out
+}
+
+// cachedTemplate returns the template of the file cache having the GUID and
+// size found in the header of the definition at offset, this way a damaged
+// definition can be replaced by the one of another chunk
+func cachedTemplate(reader io.ReadSeeker, offset int32) (TemplateDefinitionData, bool) {
+	br, ok := reader.(*budgetReader)
+	if !ok || br.templates == nil {
+		return TemplateDefinitionData{}, false
+	}
+	data := br.Bytes()
+	if offset < 0 || int(offset)+24 > len(data) {
+		return TemplateDefinitionData{}, false
+	}
+	var guid GUID
+	copy(guid[:], data[offset+4:offset+20])
+	size := int32(Endianness.Uint32(data[offset+20 : offset+24]))
+	return br.templates.get(guid, size)
+}
+
+// cacheTemplate adds a template parsed with the reader to the file cache, if
+// any, and returns the definition to use
+func cacheTemplate(reader io.ReadSeeker, td TemplateDefinitionData) TemplateDefinitionData {
+	if br, ok := reader.(*budgetReader); ok {
+		return br.templates.add(td)
+	}
+	return td
+}
+
+// countTemplates counts the templates instantiated by a fragment, those of
+// its BinXML values included
+func countTemplates(fragment *Fragment, usage map[GUID]int) {
+	ti, ok := fragment.BinXMLElement.(*TemplateInstance)
+	if !ok {
+		return
+	}
+	usage[GUID(ti.Definition.Data.ID)]++
+	for _, v := range ti.Data.Values {
+		if nested, ok := v.(*Fragment); ok {
+			countTemplates(nested, usage)
+		}
+	}
+}
+
+// setTemplateUsage records the events of the chunk by template, templates
+// defined but not used included
+func (c *Chunk) setTemplateUsage(usage map[GUID]int) {
+	if c.templates == nil {
+		return
+	}
+	for _, td := range c.TemplateTable {
+		usage[GUID(td.ID)] += 0
+	}
+	c.templates.setUsage(c.Offset, usage)
+}
+
+func (ef *File) TemplateCache() *TemplateCache {
+	return ef.templates
+}
+
+func (ef *File) TemplateStats() []TemplateStat {
+	if ef.templates == nil {
+		return nil
+	}
+	return ef.templates.Stats()
+}
@@ -0,0 +1,82 @@
+package evtx
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTemplateStats(t *testing.T) {
+	ef := fxOpen(t, fxCleanFile(t))
+	if n := len(collectIDs(ef.Records())); n != 4 {
+		t.Fatalf("expected 4 records, got %d", n)
+	}
+	stats := ef.TemplateStats()
+	got := make([]string, 0, len(stats))
+	for _, s := range stats {
+		if s.Size <= 0 {
+			t.Errorf("template {%s} has size %d", s.GUID.String(), s.Size)
+		}
+		got = append(got, fmt.Sprintf("{%s} chunks=%d events=%d", s.GUID.String(), s.Chunks, s.Events))
+	}
+	want := []string{
+		fmt.Sprintf("{%s} chunks=2 events=3", fxValuesGUID.String()),
+		fmt.Sprintf("{%s} chunks=2 events=3", fxNestedGUID.String()),
+		fmt.Sprintf("{%s} chunks=1 events=1", fxTextGUID.String()),
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("unexpected stats\n got %v\nwant %v", got, want)
+	}
+
+	// going through the file again does not count the events twice
+	collectIDs(ef.Records())
+	if fmt.Sprint(ef.TemplateStats()) != fmt.Sprint(stats) {
+		t.Errorf("stats changed on second pass: %v", ef.TemplateStats())
+	}
+}
+
+func TestTemplateBorrowed(t *testing.T) {
+	data := fxCleanFile(t)
+	second := DefaultChunkOffset + ChunkSize
+	c, err := ParseChunk(data[second:second+ChunkSize], int64(second), CheckSumStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.TemplateTable) != 1 {
+		t.Fatalf("expected a single template in the second chunk, got %d", len(c.TemplateTable))
+	}
+	// breaks the first token of the definition, right after its fragment header
+	for offset := range c.TemplateTable {
+		data[second+int(offset)+24+4] = 0xff
+	}
+
+	for _, tc := range []struct {
+		name   string
+		chunks int
+		want   string
+	}{
+		{"alone", 1, "[]"},
+		{"borrowed", 2, "[4]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the first chunk only makes it to the cache when it is read
+			raw := append([]byte(nil), data...)
+			if tc.chunks == 1 {
+				copy(raw[DefaultChunkOffset:], make([]byte, ChunkSize))
+			}
+			ef, err := NewWithOptions(bytes.NewReader(raw), Options{Workers: 1, CheckSumMode: CheckSumIgnore})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for r := range ef.Records() {
+				if r.Err == nil && r.ID == 4 {
+					ids = append(ids, r.ID)
+				}
+			}
+			if fmt.Sprint(ids) != tc.want {
+				t.Errorf("expected records %s, got %v", tc.want, ids)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
 func ToJSON(data interface{}) []byte {
@@ -44,7 +45,19 @@ func (us *UTF16String) Len() int32 {
 }
 
 func (us UTF16String) ToString() string {
-	return strings.TrimRight(string(utf16.Decode(us)), "\u0000")
+	for len(us) > 0 && us[len(us)-1] == 0 {
+		us = us[:len(us)-1]
+	}
+	// most strings are ASCII, they do not need the rune conversion
+	var b strings.Builder
+	b.Grow(len(us))
+	for _, u := range us {
+		if u >= utf8.RuneSelf {
+			return string(utf16.Decode(us))
+		}
+		b.WriteByte(byte(u))
+	}
+	return b.String()
 }
 
 type UTCTime time.Time
@@ -202,7 +202,7 @@ func (i *ValueHexInt32) String() string {
 }
 
 func (i *ValueHexInt32) Value() interface{} {
-	return i.Value()
+	return i.value
 }
 
 func (i *ValueHexInt32) Repr() interface{} {
@@ -258,7 +258,7 @@ func (i *ValueHexInt64) String() string {
 }
 
 func (i *ValueHexInt64) Value() interface{} {
-	return i.Value()
+	return i.value
 }
 
 func (i *ValueHexInt64) Repr() interface{} {
@@ -342,7 +342,7 @@ func (st *ValueStringTable) Parse(reader io.ReadSeeker) error {
 	for i := 0; i < int(st.Size/2); i++ {
 		err := encoding.Unmarshal(reader, &cp, Endianness)
 		if err != nil {
-			panic(err)
+			return err
 		}
 		if cp == UTF16EndOfString {
 			if len(s) > 0 {
@@ -501,7 +501,7 @@ func (s *ValueSysTime) Time() UTCTime {
 		int(s.value.Hours),
 		int(s.value.Minutes),
 		int(s.value.Seconds),
-		int(s.value.Milliseconds)*1000,
+		int(s.value.Milliseconds)*int(time.Millisecond),
 		time.UTC))
 }
 
@@ -589,6 +589,10 @@ func (g *GUID) String() string {
 		g[14], g[15])
 }
 
+func (g GUID) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("\"{%s}\"", g.String())), nil
+}
+
 type ValueGUID struct {
 	value GUID
 }
@@ -0,0 +1,549 @@
+package evtx
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"rawsec-evtx/encoding"
+)
+
+const (
+	FileHeaderSize      = 0x80
+	DefaultChunkOffset  = 0x1000
+	fileTimeEpochOffset = 116444736000000000
+	xmlnsEvent          = "http://schemas.microsoft.com/win/2004/08/events/event"
+)
+
+var (
+	ErrEventTooBig   = errors.New("event does not fit in a chunk")
+	ErrTooManyChunks = errors.New("too many chunks")
+	ErrWriterClosed  = errors.New("writer is closed")
+
+	systemOrder = []string{
+		"Provider", "EventID", "Version", "Level", "Task", "Opcode", "Keywords",
+		"TimeCreated", "EventRecordID", "Correlation", "Execution", "Channel",
+		"Computer", "Security",
+	}
+	systemElementTypes = map[string]ValueType{
+		"EventID":       UInt16Type,
+		"Version":       UInt8Type,
+		"Level":         UInt8Type,
+		"Task":          UInt16Type,
+		"Opcode":        UInt8Type,
+		"Keywords":      HexInt64Type,
+		"EventRecordID": UInt64Type,
+	}
+	systemAttributeTypes = map[string]ValueType{
+		"Guid":              GuidType,
+		"Qualifiers":        UInt16Type,
+		"SystemTime":        FileTimeType,
+		"ActivityID":        GuidType,
+		"RelatedActivityID": GuidType,
+		"ProcessID":         UInt32Type,
+		"ThreadID":          UInt32Type,
+		"UserID":            SidType,
+	}
+)
+
+type Writer struct {
+	w       io.WriteSeeker
+	header  FileHeader
+	chunk   *chunkBuilder
+	chunks  int
+	nextID  int64
+	closed  bool
+	onClose func() error
+	timeNow func() time.Time
+}
+
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	ew := &Writer{w: w, nextID: 1, timeNow: time.Now}
+	copy(ew.header.Magic[:], "ElfFile\x00")
+	ew.header.HeaderSpace = FileHeaderSize
+	ew.header.MinVersion = 1
+	ew.header.MajVersion = 3
+	ew.header.ChunkDataOffset = DefaultChunkOffset
+	// header space is reserved and written on Close
+	if _, err := w.Write(make([]byte, DefaultChunkOffset)); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func Create(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ew, err := NewWriter(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	ew.onClose = f.Close
+	return ew, nil
+}
+
+func (ew *Writer) WriteEvent(e *GoEvtxMap) error {
+	root, err := goEvtxToNode(e)
+	if err != nil {
+		return err
+	}
+	return ew.writeNode(root)
+}
+
+func (ew *Writer) WriteXML(r io.Reader) error {
+	xd := NewXMLDecoder(r)
+	for {
+		n, err := xd.nextNode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = ew.writeNode(n); err != nil {
+			return err
+		}
+	}
+}
+
+func (ew *Writer) writeNode(root *xmlNode) error {
+	if ew.closed {
+		return ErrWriterClosed
+	}
+	if !root.hasAttr("xmlns") {
+		root.attrs = append([]xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: xmlnsEvent}}, root.attrs...)
+	}
+
+	id := ew.nextID
+	rid := root.path("System", "EventRecordID")
+	if n, err := strconv.ParseInt(rid.textOrEmpty(), 10, 64); err == nil && n >= id {
+		id = n
+	}
+	// records out of order get the next ID, the event must tell the same
+	if rid != nil {
+		rid.text = strconv.FormatInt(id, 10)
+	}
+	created := ew.timeNow()
+	if tc := root.path("System", "TimeCreated"); tc != nil {
+		if t, err := time.Parse(time.RFC3339Nano, tc.attr("SystemTime")); err == nil {
+			created = t
+		}
+	}
+
+	tmpl := newTemplate(root)
+	if ew.chunk == nil {
+		ew.chunk = newChunkBuilder()
+	}
+	if !ew.chunk.addRecord(id, created, tmpl) {
+		if ew.chunk.count == 0 {
+			return ErrEventTooBig
+		}
+		if err := ew.flushChunk(); err != nil {
+			return err
+		}
+		ew.chunk = newChunkBuilder()
+		if !ew.chunk.addRecord(id, created, tmpl) {
+			return ErrEventTooBig
+		}
+	}
+	ew.nextID = id + 1
+	return nil
+}
+
+func (ew *Writer) flushChunk() error {
+	if ew.chunk == nil || ew.chunk.count == 0 {
+		return nil
+	}
+	if ew.chunks >= 0xffff {
+		return ErrTooManyChunks
+	}
+	data, err := ew.chunk.finalize()
+	if err != nil {
+		return err
+	}
+	if _, err = ew.w.Write(data); err != nil {
+		return err
+	}
+	ew.chunks++
+	ew.chunk = nil
+	return nil
+}
+
+func (ew *Writer) Close() (err error) {
+	if ew.closed {
+		return ErrWriterClosed
+	}
+	ew.closed = true
+	defer func() {
+		if ew.onClose != nil {
+			if cerr := ew.onClose(); err == nil {
+				err = cerr
+			}
+		}
+	}()
+
+	if err = ew.flushChunk(); err != nil {
+		return err
+	}
+	ew.header.ChunkCount = uint16(ew.chunks)
+	if ew.chunks > 0 {
+		ew.header.LastChunkNum = uint64(ew.chunks - 1)
+	}
+	ew.header.NextRecordID = uint64(ew.nextID)
+	if ew.header.CheckSum, err = ew.header.ComputeCheckSum(); err != nil {
+		return err
+	}
+	b, err := encoding.Marshal(&ew.header, Endianness)
+	if err != nil {
+		return err
+	}
+	if _, err = ew.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = ew.w.Write(b)
+	return err
+}
+
+type templateValue struct {
+	typ  ValueType
+	data []byte
+}
+
+type template struct {
+	root   *xmlNode
+	guid   GUID
+	values []templateValue
+	// substitution index of node text and attributes
+	textSub map[*xmlNode]int
+	attrSub map[*xmlNode][]int
+}
+
+func newTemplate(root *xmlNode) *template {
+	t := &template{
+		root:    root,
+		textSub: make(map[*xmlNode]int),
+		attrSub: make(map[*xmlNode][]int),
+	}
+	key := new(strings.Builder)
+	t.walk(root, false, key)
+	t.guid = GUID(md5.Sum([]byte(key.String())))
+	return t
+}
+
+func (t *template) walk(n *xmlNode, inSystem bool, key *strings.Builder) {
+	key.WriteString("<" + n.name)
+	for _, attr := range n.attrs {
+		var typ ValueType = StringType
+		if inSystem {
+			typ = systemAttributeTypes[attr.Name.Local]
+		}
+		v := encodeValue(typ, attr.Value)
+		t.attrSub[n] = append(t.attrSub[n], len(t.values))
+		t.values = append(t.values, v)
+		fmt.Fprintf(key, " %s=%d", xmlAttrName(attr), v.typ)
+	}
+	key.WriteString(">")
+	if n.text != "" {
+		var typ ValueType = StringType
+		if inSystem {
+			typ = systemElementTypes[n.name]
+		}
+		v := encodeValue(typ, n.text)
+		t.textSub[n] = len(t.values)
+		t.values = append(t.values, v)
+		fmt.Fprintf(key, "%%%d", v.typ)
+	}
+	for _, c := range n.children {
+		t.walk(c, inSystem || n.name == "System", key)
+	}
+	key.WriteString("</>")
+}
+
+func encodeValue(typ ValueType, s string) templateValue {
+	b := new(bytes.Buffer)
+	var err error
+	switch typ {
+	case UInt8Type:
+		var u uint64
+		if u, err = strconv.ParseUint(s, 0, 8); err == nil {
+			b.WriteByte(uint8(u))
+		}
+	case UInt16Type:
+		var u uint64
+		if u, err = strconv.ParseUint(s, 0, 16); err == nil {
+			err = writeLE(b, uint16(u))
+		}
+	case UInt32Type:
+		var u uint64
+		if u, err = strconv.ParseUint(s, 0, 32); err == nil {
+			err = writeLE(b, uint32(u))
+		}
+	case UInt64Type, HexInt64Type:
+		var u uint64
+		if u, err = strconv.ParseUint(s, 0, 64); err == nil {
+			err = writeLE(b, u)
+		}
+	case GuidType:
+		var g GUID
+		if g, err = ParseGUID(s); err == nil {
+			b.Write(g[:])
+		}
+	case FileTimeType:
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, s); err == nil {
+			err = writeLE(b, t.UnixNano()/100+fileTimeEpochOffset)
+		}
+	case SidType:
+		var sid []byte
+		if sid, err = encodeSID(s); err == nil {
+			b.Write(sid)
+		}
+	default:
+		err = errors.New("string")
+	}
+	if err != nil {
+		return templateValue{StringType, utf16LE(s)}
+	}
+	return templateValue{typ, b.Bytes()}
+}
+
+func writeLE(w io.Writer, data interface{}) error {
+	return binary.Write(w, Endianness, data)
+}
+
+func utf16LE(s string) []byte {
+	b := new(bytes.Buffer)
+	_ = writeLE(b, utf16.Encode([]rune(s)))
+	return b.Bytes()
+}
+
+func encodeSID(s string) ([]byte, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 3 || parts[0] != "S" {
+		return nil, fmt.Errorf("bad SID: %s", s)
+	}
+	revision, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return nil, err
+	}
+	authority, err := strconv.ParseUint(parts[2], 10, 48)
+	if err != nil {
+		return nil, err
+	}
+	b := new(bytes.Buffer)
+	b.WriteByte(uint8(revision))
+	b.WriteByte(uint8(len(parts) - 3))
+	for i := 5; i >= 0; i-- {
+		b.WriteByte(uint8(authority >> (8 * uint(i))))
+	}
+	for _, p := range parts[3:] {
+		sub, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		_ = writeLE(b, uint32(sub))
+	}
+	return b.Bytes(), nil
+}
+
+func xmlAttrName(attr xml.Attr) string {
+	if attr.Name.Space == "xmlns" {
+		return "xmlns:" + attr.Name.Local
+	}
+	return attr.Name.Local
+}
+
+func (n *xmlNode) hasAttr(name string) bool {
+	for _, attr := range n.attrs {
+		if xmlAttrName(attr) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, attr := range n.attrs {
+		if xmlAttrName(attr) == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) path(names ...string) *xmlNode {
+	for _, name := range names {
+		if n == nil {
+			return nil
+		}
+		var next *xmlNode
+		for _, c := range n.children {
+			if c.name == name {
+				next = c
+				break
+			}
+		}
+		n = next
+	}
+	return n
+}
+
+func (n *xmlNode) textOrEmpty() string {
+	if n == nil {
+		return ""
+	}
+	return n.text
+}
+
+func goEvtxString(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v.(string)
+	case UTCTime:
+		return time.Time(v.(UTCTime)).UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		return v.(time.Time).UTC().Format(time.RFC3339Nano)
+	case []string:
+		return strings.Join(v.([]string), " ")
+	case []byte:
+		return string(v.([]byte))
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func goEvtxMapOf(v interface{}) (GoEvtxMap, bool) {
+	switch v.(type) {
+	case GoEvtxMap:
+		return v.(GoEvtxMap), true
+	case map[string]interface{}:
+		return GoEvtxMap(v.(map[string]interface{})), true
+	}
+	return nil, false
+}
+
+// goEvtxToNode inverts TemplateInstance.NodeToGoEvtx, the map does not
+// record which keys were attributes so string values of System children
+// are taken as attributes and EventData entries as Data elements
+func goEvtxToNode(e *GoEvtxMap) (*xmlNode, error) {
+	if e == nil {
+		return nil, fmt.Errorf("nil event")
+	}
+	event, ok := goEvtxMapOf((*e)["Event"])
+	if !ok {
+		return nil, fmt.Errorf("event has no Event element")
+	}
+	return mapToNode("", "Event", event), nil
+}
+
+func sortedKeys(m GoEvtxMap) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mapToNode(parent, name string, m GoEvtxMap) *xmlNode {
+	n := &xmlNode{name: name}
+	keys := sortedKeys(m)
+	switch name {
+	case "System":
+		ordered := make([]string, 0, len(keys))
+		seen := make(map[string]bool)
+		for _, k := range systemOrder {
+			if _, ok := m[k]; ok {
+				ordered = append(ordered, k)
+				seen[k] = true
+			}
+		}
+		for _, k := range keys {
+			if !seen[k] {
+				ordered = append(ordered, k)
+			}
+		}
+		for _, k := range ordered {
+			v := m[k]
+			if sub, ok := goEvtxMapOf(v); ok {
+				n.children = append(n.children, mapToNode(name, k, sub))
+				continue
+			}
+			s := goEvtxString(v)
+			// a Provider with a single Name attribute ends up as a key
+			if s == "" && !seen[k] {
+				n.children = append(n.children, &xmlNode{name: "Provider",
+					attrs: []xml.Attr{{Name: xml.Name{Local: "Name"}, Value: k}}})
+				continue
+			}
+			n.children = append(n.children, &xmlNode{name: k, text: s})
+		}
+		return n
+	case "EventData":
+		sort.SliceStable(keys, func(i, j int) bool {
+			return dataIndex(keys[i]) < dataIndex(keys[j])
+		})
+		for _, k := range keys {
+			v := m[k]
+			if sub, ok := goEvtxMapOf(v); ok {
+				n.children = append(n.children, mapToNode(name, k, sub))
+				continue
+			}
+			switch {
+			case k == "Binary":
+				n.children = append(n.children, &xmlNode{name: k, text: goEvtxString(v)})
+			case dataIndex(k) >= 0:
+				n.children = append(n.children, &xmlNode{name: "Data", text: goEvtxString(v)})
+			default:
+				n.children = append(n.children, &xmlNode{name: "Data", text: goEvtxString(v),
+					attrs: []xml.Attr{{Name: xml.Name{Local: "Name"}, Value: k}}})
+			}
+		}
+		return n
+	}
+
+	for _, k := range keys {
+		v := m[k]
+		switch {
+		case k == "Value":
+			n.text = goEvtxString(v)
+		default:
+			if sub, ok := goEvtxMapOf(v); ok {
+				n.children = append(n.children, mapToNode(name, k, sub))
+			} else if parent == "System" || parent == "EventData" {
+				n.attrs = append(n.attrs, xml.Attr{Name: xml.Name{Local: k}, Value: goEvtxString(v)})
+			} else {
+				n.children = append(n.children, &xmlNode{name: k, text: goEvtxString(v)})
+			}
+		}
+	}
+	return n
+}
+
+// dataIndex returns the index of unnamed Data elements renamed DataN by
+// NodeToGoEvtx, and -1 for named ones
+func dataIndex(k string) int {
+	if k == "Data" {
+		return 0
+	}
+	if strings.HasPrefix(k, "Data") {
+		if i, err := strconv.Atoi(k[4:]); err == nil {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,139 @@
+package evtx
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes the events with a Writer and reopens the file
+func writeTestFile(t *testing.T, write func(ew *Writer) error) *File {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.evtx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ew, err := NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = write(ew); err != nil {
+		t.Fatal(err)
+	}
+	if err = ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fxOpen(t, data)
+}
+
+var writerEvents = []string{
+	`<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System>` +
+		`<Provider Name="Microsoft-Windows-Security-Auditing" Guid="{54849625-5478-4994-A5BA-3E3B0328C30D}"/>` +
+		`<EventID>4624</EventID><Version>2</Version><Level>0</Level><Task>12544</Task><Opcode>0</Opcode>` +
+		`<Keywords>0x8020000000000000</Keywords><TimeCreated SystemTime="2020-09-13T12:00:01.1234567Z"/>` +
+		`<EventRecordID>1</EventRecordID><Correlation ActivityID="{6A1B2C3D-0001-0002-0003-000000000004}"/>` +
+		`<Execution ProcessID="636" ThreadID="2184"/><Channel>Security</Channel><Computer>HOST</Computer><Security/></System>` +
+		`<EventData><Data Name="TargetUserName">user</Data><Data Name="LogonType">10</Data>` +
+		`<Data Name="CommandLine">a &amp; b &lt;c&gt;</Data></EventData></Event>`,
+	`<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System>` +
+		`<Provider Name="Service Control Manager" EventSourceName="Service Control Manager"/>` +
+		`<EventID Qualifiers="16384">7036</EventID><Level>4</Level><TimeCreated SystemTime="2020-09-13T12:00:02Z"/>` +
+		`<EventRecordID>2</EventRecordID><Channel>System</Channel><Computer>HOST</Computer>` +
+		`<Security UserID="S-1-5-18"/></System><EventData><Data>Windows Update</Data><Data>running</Data></EventData></Event>`,
+	`<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System>` +
+		`<Provider Name="Microsoft-Windows-Eventlog"/><EventID>1102</EventID><TimeCreated SystemTime="2020-09-13T12:00:03Z"/>` +
+		`<EventRecordID>3</EventRecordID><Channel>Security</Channel><Computer>HOST</Computer></System>` +
+		`<UserData><LogFileCleared xmlns="http://manifests.microsoft.com/win/2004/08/windows/eventlog">` +
+		`<SubjectUserName>admin</SubjectUserName><SubjectDomainName>CORP</SubjectDomainName></LogFileCleared></UserData></Event>`,
+	`<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System>` +
+		`<Provider Name="PowerShell"/><EventID>4104</EventID><TimeCreated SystemTime="2020-09-13T12:00:04Z"/>` +
+		`<EventRecordID>4</EventRecordID><Channel>Microsoft-Windows-PowerShell/Operational</Channel><Computer>HOST</Computer></System>` +
+		`<EventData><Data Name="ScriptBlockText">Get-Process` + "\n" + `Get-Service</Data></EventData></Event>`,
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	var events []*GoEvtxMap
+	for _, x := range writerEvents {
+		e, err := ParseXMLEvent([]byte(x))
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+
+	// half of the events are written as GoEvtxMap, the others as XML
+	half := len(events) / 2
+	ef := writeTestFile(t, func(ew *Writer) error {
+		for _, e := range events[:half] {
+			if err := ew.WriteEvent(e); err != nil {
+				return err
+			}
+		}
+		return ew.WriteXML(strings.NewReader(strings.Join(writerEvents[half:], "\n")))
+	})
+	if err := ef.Header.VerifyCheckSum(); err != nil {
+		t.Fatal(err)
+	}
+
+	i := 0
+	for r := range ef.Records() {
+		if r.Err != nil {
+			t.Fatalf("record %d: %s", r.ID, r.Err)
+		}
+		if i >= len(events) {
+			t.Fatalf("unexpected record %d", r.ID)
+		}
+		// events are compared as XML which renders GUIDs as written
+		x, err := r.XML()
+		if err != nil {
+			t.Fatalf("record %d: %s", r.ID, err)
+		}
+		e, err := ParseXMLEvent(x)
+		if err != nil {
+			t.Fatalf("record %d: %s", r.ID, err)
+		}
+		if got, want := ToJSON(e), ToJSON(events[i]); !bytes.Equal(got, want) {
+			t.Errorf("record %d:\n%s\nexpected\n%s", r.ID, got, want)
+		}
+		i++
+	}
+	if i != len(events) {
+		t.Errorf("%d records, expected %d", i, len(events))
+	}
+}
+
+func TestWriterRecordIDs(t *testing.T) {
+	var events []string
+	for _, id := range []string{"5", "3", "3", "", "9"} {
+		events = append(events, `<Event><System><Provider Name="Test"/><EventID>1</EventID>`+
+			`<EventRecordID>`+id+`</EventRecordID></System></Event>`)
+	}
+	ef := writeTestFile(t, func(ew *Writer) error {
+		return ew.WriteXML(strings.NewReader(strings.Join(events, "\n")))
+	})
+
+	var ids []int64
+	for r := range ef.Records() {
+		if r.Err != nil || r.System == nil {
+			t.Fatalf("record %d: %v %v", r.ID, r.Err, r.SystemErr)
+		}
+		if int64(r.System.EventRecordID) != r.ID {
+			t.Errorf("record %d has EventRecordID %d", r.ID, r.System.EventRecordID)
+		}
+		ids = append(ids, r.ID)
+	}
+	if !reflect.DeepEqual(ids, []int64{5, 6, 7, 8, 9}) {
+		t.Errorf("unexpected record IDs %v", ids)
+	}
+	if ef.Header.NextRecordID != 10 {
+		t.Errorf("next record ID %d, expected 10", ef.Header.NextRecordID)
+	}
+}
@@ -0,0 +1,240 @@
+package evtx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	XMLTimeFormat = "2006-01-02T15:04:05.0000000Z"
+)
+
+var (
+	xmlEscaper = strings.NewReplacer(
+		"&", "&amp;",
+		"<", "&lt;",
+		">", "&gt;",
+		"\"", "&quot;",
+		"'", "&apos;",
+	)
+)
+
+func (e Event) XML(c *Chunk) ([]byte, error) {
+	fragment, err := e.Fragment(c)
+	if err != nil {
+		return nil, err
+	}
+	return fragment.XML()
+}
+
+func (f *Fragment) XML() ([]byte, error) {
+	w := new(bytes.Buffer)
+	if err := f.WriteXML(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (f *Fragment) WriteXML(w io.Writer) error {
+	ti, ok := f.BinXMLElement.(*TemplateInstance)
+	if !ok {
+		return fmt.Errorf("fragment does not contain a template instance: %T", f.BinXMLElement)
+	}
+	return ti.WriteXML(w)
+}
+
+func (ti *TemplateInstance) XML() ([]byte, error) {
+	w := new(bytes.Buffer)
+	if err := ti.WriteXML(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (ti *TemplateInstance) WriteXML(w io.Writer) error {
+	root := ti.Root()
+	xw := &xmlWriter{w: w}
+	ti.writeNode(xw, &root)
+	return xw.err
+}
+
+type xmlWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (xw *xmlWriter) WriteString(s string) {
+	if xw.err == nil {
+		_, xw.err = io.WriteString(xw.w, s)
+	}
+}
+
+func (xw *xmlWriter) fail(err error) {
+	if xw.err == nil {
+		xw.err = err
+	}
+}
+
+func (ti *TemplateInstance) substitution(elt Element) (Element, bool, error) {
+	var s *NormalSubstitution
+	optional := false
+	switch elt.(type) {
+	case *NormalSubstitution:
+		s = elt.(*NormalSubstitution)
+	case *OptionalSubstitution:
+		s = &elt.(*OptionalSubstitution).NormalSubstitution
+		optional = true
+	default:
+		return elt, false, nil
+	}
+	if s.SubID < 0 || int(s.SubID) >= len(ti.Data.Values) {
+		return nil, false, ErrSubstitutionOutOfRange{int(s.SubID), len(ti.Data.Values)}
+	}
+	return ti.Data.Values[int(s.SubID)], optional, nil
+}
+
+func (ti *TemplateInstance) writeNode(xw *xmlWriter, n *Node) {
+	if n.Start == nil {
+		for _, c := range n.Child {
+			ti.writeNode(xw, c)
+		}
+		return
+	}
+
+	// an element whose content is an array substitution is repeated for
+	// every item of the array
+	if len(n.Element) == 1 && len(n.Child) == 0 {
+		value, _, err := ti.substitution(n.Element[0])
+		if err != nil {
+			xw.fail(err)
+			return
+		}
+		if items, ok := xmlArrayItems(value); ok {
+			for _, item := range items {
+				ti.writeStart(xw, n)
+				xw.WriteString(">")
+				xw.WriteString(xmlEscaper.Replace(item))
+				ti.writeEnd(xw, n)
+			}
+			return
+		}
+	}
+
+	ti.writeStart(xw, n)
+	if n.Start.EOESToken == TokenCloseEmptyElementTag {
+		xw.WriteString("/>")
+		return
+	}
+	xw.WriteString(">")
+	for _, e := range n.Element {
+		ti.writeContent(xw, e)
+	}
+	for _, c := range n.Child {
+		ti.writeNode(xw, c)
+	}
+	ti.writeEnd(xw, n)
+}
+
+func (ti *TemplateInstance) writeStart(xw *xmlWriter, n *Node) {
+	xw.WriteString("<")
+	xw.WriteString(n.Start.Name.String())
+	for _, attr := range n.Start.AttributeList.Attributes {
+		value, optional, err := ti.substitution(attr.AttributeData)
+		if err != nil {
+			xw.fail(err)
+			return
+		}
+		if _, null := value.(*ValueNull); null && optional {
+			continue
+		}
+		xw.WriteString(" ")
+		xw.WriteString(attr.Name.String())
+		xw.WriteString("='")
+		ti.writeContent(xw, value)
+		xw.WriteString("'")
+	}
+}
+
+func (ti *TemplateInstance) writeEnd(xw *xmlWriter, n *Node) {
+	xw.WriteString("</")
+	xw.WriteString(n.Start.Name.String())
+	xw.WriteString(">")
+}
+
+func (ti *TemplateInstance) writeContent(xw *xmlWriter, elt Element) {
+	elt, _, err := ti.substitution(elt)
+	if err != nil {
+		xw.fail(err)
+		return
+	}
+	switch elt.(type) {
+	case *ValueText:
+		xw.WriteString(xmlEscaper.Replace(elt.(*ValueText).String()))
+	case *BinXMLEntityReference:
+		xw.WriteString("&" + elt.(*BinXMLEntityReference).Name.String() + ";")
+	case *CharEntityRef:
+		xw.WriteString(fmt.Sprintf("&#%d;", uint16(elt.(*CharEntityRef).Value)))
+	case *Fragment:
+		temp, ok := elt.(*Fragment).BinXMLElement.(*TemplateInstance)
+		if !ok {
+			xw.fail(fmt.Errorf("fragment does not contain a template instance: %T", elt.(*Fragment).BinXMLElement))
+			return
+		}
+		root := temp.Root()
+		temp.writeNode(xw, &root)
+	case *TemplateInstance:
+		temp := elt.(*TemplateInstance)
+		root := temp.Root()
+		temp.writeNode(xw, &root)
+	case Value:
+		xw.WriteString(xmlEscaper.Replace(xmlValue(elt.(Value))))
+	default:
+		xw.fail(fmt.Errorf("don't know how to render: %T", elt))
+	}
+}
+
+func xmlArrayItems(elt Element) ([]string, bool) {
+	var items []string
+	switch elt.(type) {
+	case *ValueStringTable:
+		for _, s := range elt.(*ValueStringTable).value {
+			items = append(items, s.ToString())
+		}
+	case *ValueArrayUInt16:
+		for _, u := range elt.(*ValueArrayUInt16).value {
+			items = append(items, fmt.Sprintf("%d", u))
+		}
+	case *ValueArrayUInt64:
+		for _, u := range elt.(*ValueArrayUInt64).value {
+			items = append(items, fmt.Sprintf("%d", u))
+		}
+	default:
+		return nil, false
+	}
+	return items, true
+}
+
+func xmlValue(v Value) string {
+	switch v.(type) {
+	case *ValueNull:
+		return ""
+	case *ValueGUID:
+		return "{" + v.String() + "}"
+	case *ValueHexInt32:
+		return fmt.Sprintf("0x%x", v.(*ValueHexInt32).value)
+	case *ValueHexInt64:
+		return fmt.Sprintf("0x%x", v.(*ValueHexInt64).value)
+	case *ValueFileTime:
+		sec, nsec := v.(*ValueFileTime).value.Convert()
+		return time.Unix(sec, nsec).UTC().Format(XMLTimeFormat)
+	case *ValueSysTime:
+		s := v.(*ValueSysTime).value
+		return time.Date(int(s.Year), time.Month(s.Month), int(s.DayOfMonth),
+			int(s.Hours), int(s.Minutes), int(s.Seconds),
+			int(s.Milliseconds)*int(time.Millisecond), time.UTC).Format(XMLTimeFormat)
+	}
+	return v.String()
+}
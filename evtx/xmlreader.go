@@ -0,0 +1,147 @@
+package evtx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	text     string
+	children []*xmlNode
+}
+
+type XMLDecoder struct {
+	d *xml.Decoder
+}
+
+func NewXMLDecoder(r io.Reader) *XMLDecoder {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	return &XMLDecoder{d}
+}
+
+func ParseXMLEvent(data []byte) (*GoEvtxMap, error) {
+	pgem, err := NewXMLDecoder(bytes.NewReader(data)).Next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("no Event element found")
+	}
+	return pgem, err
+}
+
+func (xd *XMLDecoder) Next() (*GoEvtxMap, error) {
+	n, err := xd.nextNode()
+	if err != nil {
+		return nil, err
+	}
+	gem := GoEvtxMap{n.name: n.goEvtx()}
+	gem.DelXmlns()
+	return &gem, nil
+}
+
+func (xd *XMLDecoder) nextNode() (*xmlNode, error) {
+	for {
+		tok, err := xd.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "Event" {
+			return xd.node(se)
+		}
+	}
+}
+
+func (xd *XMLDecoder) node(start xml.StartElement) (*xmlNode, error) {
+	n := &xmlNode{name: start.Name.Local, attrs: start.Attr}
+	text := new(strings.Builder)
+	for {
+		tok, err := xd.d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			c, err := xd.node(tok.(xml.StartElement))
+			if err != nil {
+				return nil, err
+			}
+			n.children = append(n.children, c)
+		case xml.CharData:
+			text.Write(tok.(xml.CharData))
+		case xml.EndElement:
+			// whitespace only text comes from indentation
+			if strings.TrimSpace(text.String()) != "" {
+				n.text = text.String()
+			}
+			return n, nil
+		}
+	}
+}
+
+func xmlAttrValue(attr xml.Attr) interface{} {
+	if attr.Name.Local == "SystemTime" {
+		if t, err := time.Parse(time.RFC3339Nano, attr.Value); err == nil {
+			return UTCTime(t)
+		}
+	}
+	return attr.Value
+}
+
+// goEvtx follows the layout of TemplateInstance.NodeToGoEvtx
+func (n *xmlNode) goEvtx() GoEvtxMap {
+	m := make(GoEvtxMap, len(n.children))
+	// names of the Data elements, repeated for the items of an array
+	var named map[string]bool
+	for i, c := range n.children {
+		node := c.goEvtx()
+		name, nameOk := node["Name"].(string)
+		switch {
+		case nameOk && (node.HasKeys("Name") && len(node) == 1 || node.HasKeys("Name", "Value") && len(node) == 2):
+			value, _ := node["Value"].(string)
+			if named[name] {
+				m[name] = appendItem(m[name], value)
+				continue
+			}
+			if named == nil {
+				named = make(map[string]bool)
+			}
+			named[name] = true
+			m[name] = value
+		default:
+			name := c.name
+			if _, ok := m[name]; ok {
+				name = fmt.Sprintf("%s%d", name, i)
+			}
+			if node.HasKeys("Value") && len(node) == 1 {
+				m[name] = node["Value"]
+			} else {
+				m[name] = node
+			}
+		}
+	}
+	if n.text != "" {
+		m["Value"] = n.text
+	}
+	for _, attr := range n.attrs {
+		m[attr.Name.Local] = xmlAttrValue(attr)
+	}
+	return m
+}
+
+func appendItem(items interface{}, item string) interface{} {
+	switch items.(type) {
+	case []string:
+		return append(items.([]string), item)
+	case string:
+		return []string{items.(string), item}
+	}
+	return item
+}
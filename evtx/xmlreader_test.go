@@ -0,0 +1,92 @@
+package evtx
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// flatten maps the path of every leaf of an event to its text, the typed
+// values of the binary events included
+func flatten(path string, v interface{}, leaves map[string]string) {
+	switch v.(type) {
+	case GoEvtxMap:
+		for k, c := range v.(GoEvtxMap) {
+			flatten(path+"/"+k, c, leaves)
+		}
+		return
+	case nil:
+		leaves[path] = ""
+		return
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(rv.Index(i).Interface())
+		}
+		leaves[path] = "[" + strings.Join(items, " ") + "]"
+		return
+	}
+	leaves[path] = strings.Trim(string(ToJSON(v)), `"`)
+}
+
+func TestParseXMLEvent(t *testing.T) {
+	// typed values whose XML rendering differs from the JSON one
+	rendered := map[string]bool{
+		"/Event/EventData/AnsiString": true,
+		"/Event/EventData/Guid":       true,
+		"/Event/EventData/HexInt32":   true,
+		"/Event/EventData/SysTime":    true,
+		"/Event/System/Provider/Guid": true,
+	}
+
+	ef := fxOpen(t, fxCleanFile(t))
+	for r := range ef.Records() {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		x, err := r.XML()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pgem, err := ParseXMLEvent(x)
+		if err != nil {
+			t.Fatalf("record %d: %s", r.ID, err)
+		}
+
+		want, got := make(map[string]string), make(map[string]string)
+		flatten("", *r.Event, want)
+		flatten("", *pgem, got)
+		for path, v := range want {
+			g, ok := got[path]
+			switch {
+			case !ok:
+				t.Errorf("record %d: %s missing", r.ID, path)
+			case g != v && !rendered[path]:
+				t.Errorf("record %d: %s is %q, expected %q", r.ID, path, g, v)
+			}
+		}
+		for path := range got {
+			if _, ok := want[path]; !ok {
+				t.Errorf("record %d: unexpected %s", r.ID, path)
+			}
+		}
+	}
+}
+
+func TestParseXMLEventMalformed(t *testing.T) {
+	for _, x := range []string{
+		"",
+		"<System><EventID>1</EventID></System>",
+		"<Event><System><EventID>1</EventID>",
+		"<Event><System><EventID>1</EventID></System",
+		"<Event><System><</System></Event>",
+		"<Event><1System/></Event>",
+		"<Event><!-- </Event>",
+	} {
+		if pgem, err := ParseXMLEvent([]byte(x)); err == nil {
+			t.Errorf("%q: expected an error, got %s", x, ToJSON(pgem))
+		}
+	}
+}
@@ -1,29 +1,105 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"rawsec-evtx/evtx"
+	"rawsec-evtx/evtx/filter"
+	"rawsec-evtx/evtx/message"
 	"rawsec-evtx/log"
+	"rawsec-evtx/output"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 const version = "1.0"
 
+var (
+	renderingInfoPath    = evtx.Path("/Event/RenderingInfo")
+	renderingMessagePath = evtx.Path("/Event/RenderingInfo/Message")
+)
+
 func main() {
-	var strEventIds string
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "carve":
+			carveMain(os.Args[2:])
+			return
+		case "hunt":
+			huntMain(os.Args[2:])
+			return
+		case "audit":
+			auditMain(os.Args[2:])
+			return
+		case "messages":
+			messagesMain(os.Args[2:])
+			return
+		}
+	}
+
+	var strEventIds, query, checkSumMode, format, out, compression, messages string
+	var ordered, follow, newOnly, recoverSlack, metadata bool
+	var workers int
 	flag.StringVar(&strEventIds, "e", "", "Comma seperated event IDs")
+	flag.StringVar(&query, "q", "", "Filter expression, ex: 'EventID in (4624,4625) and EventData/LogonType == 10'")
+	flag.StringVar(&checkSumMode, "c", "ignore", "Checksum verification mode (ignore|lenient|strict)")
+	flag.BoolVar(&ordered, "s", false, "Sort events by record ID")
+	flag.BoolVar(&recoverSlack, "r", false, "Recover deleted records from chunk slack space into a separate .recovered.json file")
+	flag.BoolVar(&follow, "f", false, "Follow the file and dump events as they are written (stop with Ctrl+C)")
+	flag.BoolVar(&newOnly, "n", false, "Only dump the events written after the start of -f")
+	flag.IntVar(&workers, "w", evtx.MaxJobs, "Number of chunks decoded in parallel")
+	flag.StringVar(&out, "o", "", "Output file receiving the events of all the input files, - for stdout (default: one file next to each input file)")
+	flag.StringVar(&format, "format", "json", "Output format (json|jsonl|xml)")
+	flag.StringVar(&compression, "z", "", "Output compression (gzip|zstd), guessed from the -o extension by default")
+	flag.StringVar(&messages, "M", "", "Message catalog built with the messages command, the messages rendered are added under Event/RenderingInfo/Message of the JSON events, XML inputs excepted")
+	flag.BoolVar(&metadata, "m", false, "Include record metadata (record ID, timestamp, chunk, offset, size) under the "+evtx.RecordMetadataKey+" key")
 
 	flag.Usage = func() {
-		fmt.Printf("%s\nUsage of %s: %[1]s [OPTIONS] FILES...\n", version, filepath.Base(os.Args[0]))
+		fmt.Printf("%s\nUsage of %s: %[2]s [OPTIONS] FILES...\n", version, filepath.Base(os.Args[0]))
+		fmt.Printf("       %s carve [OPTIONS] IMAGES...\n", filepath.Base(os.Args[0]))
+		fmt.Printf("       %s hunt -r RULES [OPTIONS] FILES...\n", filepath.Base(os.Args[0]))
+		fmt.Printf("       %s audit [OPTIONS] FILES...\n", filepath.Base(os.Args[0]))
+		fmt.Printf("       %s messages [OPTIONS] MODULES...\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
 
+	crcMode, err := parseCheckSumMode(checkSumMode)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	// -o used to be the output format, it still is unless -format is given
+	formatSet := false
+	flag.Visit(func(f *flag.Flag) { formatSet = formatSet || f.Name == "format" })
+	switch out {
+	case "json", "jsonl", "xml":
+		if !formatSet {
+			fmt.Fprintf(os.Stderr, "-o %s is deprecated, use -format %[1]s\n", out)
+			format, out = out, ""
+		}
+	}
+	oformat, err := output.ParseFormat(format)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	ocompression := output.CompressionOf(out)
+	if compression != "" {
+		if ocompression, err = output.ParseCompression(compression); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+	}
+
 	var eventIds []interface{}
 	for _, i := range strings.Split(strEventIds, ",") {
 		if _, err := strconv.ParseInt(i, 10, 64); err == nil {
@@ -31,50 +107,234 @@ func main() {
 		}
 	}
 
-	for _, evtxFile := range flag.Args() {
-		ef, err := evtx.OpenDirty(evtxFile)
-		if err != nil {
+	var f *filter.Filter
+	if query != "" {
+		if f, err = filter.Compile(query); err != nil {
 			log.Error(err)
-			continue
+			os.Exit(1)
 		}
+	}
 
-		name := strings.TrimSuffix(evtxFile, filepath.Ext(evtxFile)) + ".json"
-		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
+	var catalog *message.Catalog
+	if messages != "" {
+		if catalog, err = message.LoadCatalog(messages); err != nil {
 			log.Error(err)
-			continue
+			os.Exit(1)
 		}
+	}
 
-		_, err = f.WriteString("[")
-		if err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := dumper{
+		catalog:  catalog,
+		eventIds: eventIds,
+		filter:   f,
+		metadata: metadata,
+		opts: evtx.Options{
+			Workers:       workers,
+			PreserveOrder: true,
+			CheckSumMode:  crcMode,
+			Dirty:         true,
+			Mmap:          !follow,
+		},
+		ordered: ordered,
+		follow:  follow,
+		newOnly: newOnly,
+	}
+
+	var shared *output.Writer
+	if out != "" {
+		if shared, err = output.Create(out, oformat, ocompression); err != nil {
 			log.Error(err)
-			_ = f.Close()
-			continue
+			os.Exit(1)
 		}
+	}
 
-		for e := range ef.UnorderedEvents() {
-			if e == nil {
+	for _, evtxFile := range flag.Args() {
+		w := shared
+		if w == nil {
+			name := strings.TrimSuffix(evtxFile, filepath.Ext(evtxFile)) + output.Ext(oformat, ocompression)
+			if w, err = output.Create(name, oformat, ocompression); err != nil {
+				log.Error(err)
 				continue
 			}
+		}
 
-			if eventIds != nil && !e.IsEventID(eventIds...) {
-				continue
+		switch {
+		case isEvtFile(evtxFile):
+			err = d.dumpEvt(ctx, evtxFile, w)
+		case isXMLFile(evtxFile):
+			err = d.dumpXML(evtxFile, w)
+		default:
+			var ef *evtx.File
+			if ef, err = d.dumpEvtx(ctx, evtxFile, w); ef != nil && recoverSlack {
+				if err := dumpRecovered(ef, strings.TrimSuffix(evtxFile, filepath.Ext(evtxFile))+".recovered.json"); err != nil {
+					log.Error(err)
+				}
 			}
+			if ef != nil {
+				_ = ef.Close()
+			}
+		}
+		if err != nil {
+			log.Error(err)
+		}
+		if w != shared {
+			closeOutput(w, err, ctx.Err() != nil && !follow)
+		}
+	}
 
-			_, err = f.WriteString(string(evtx.ToJSON(e)) + ",")
-			if err != nil {
-				log.Error(err)
-				break
+	if shared != nil {
+		closeOutput(shared, nil, ctx.Err() != nil && !follow)
+	}
+}
+
+// closeOutput keeps an output only if it is complete, a following dump is
+// complete whenever it is stopped
+func closeOutput(w *output.Writer, err error, interrupted bool) {
+	if err != nil || interrupted {
+		if err := w.Abort(); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+	if err := w.Close(); err != nil {
+		log.Error(err)
+	}
+}
+
+type dumper struct {
+	catalog  *message.Catalog
+	eventIds []interface{}
+	filter   *filter.Filter
+	metadata bool
+	opts     evtx.Options
+	ordered  bool
+	follow   bool
+	// follows the records written after the start only
+	newOnly bool
+}
+
+func (d *dumper) dumpEvtx(ctx context.Context, path string, w *output.Writer) (*evtx.File, error) {
+	// the caller closes the file returned, the only one opened
+	ef := new(evtx.File)
+	var err error
+	if *ef, err = evtx.OpenWithOptions(path, d.opts); err != nil {
+		// headers failing the checks leave the file open
+		_ = ef.Close()
+		return nil, err
+	}
+
+	if d.opts.CheckSumMode != evtx.CheckSumIgnore {
+		if err = ef.Header.VerifyCheckSum(); err != nil {
+			if d.opts.CheckSumMode == evtx.CheckSumStrict {
+				_ = ef.Close()
+				return nil, fmt.Errorf("%s: %s", path, err)
 			}
+			log.Errorf("%s: %s", path, err)
+		}
+	}
+
+	var records chan *evtx.Record
+	switch {
+	case d.follow:
+		ef.SetMonitorExisting(!d.newOnly)
+		records = ef.FollowRecords(ctx)
+	case d.ordered:
+		records = ef.RecordsContext(ctx)
+	default:
+		records = ef.UnorderedRecordsContext(ctx)
+	}
+	// the workers are done with the file once the records are drained
+	defer func() {
+		for range records {
+		}
+	}()
+
+	for r := range records {
+		if r.Err != nil {
+			log.Error(r.Err)
+			continue
+		}
+
+		e := r.Event
+		if e == nil {
+			continue
+		}
+
+		if !d.keep(e) {
+			continue
 		}
 
-		if err == nil {
-			_, err = f.WriteString("null]")
+		if w.Format() == output.XML {
+			xml, err := r.XML()
 			if err != nil {
 				log.Error(err)
+				continue
 			}
+			if err = w.WriteRaw(xml); err != nil {
+				return ef, err
+			}
+			continue
+		}
+		if d.catalog != nil {
+			msg, err := d.catalog.Render(r)
+			addMessage(e, r.ID, msg, err)
 		}
+		if d.metadata {
+			e = r.GoEvtxMap()
+		}
+		if err = w.Encode(e); err != nil {
+			return ef, err
+		}
+	}
+	return ef, nil
+}
+
+// keep returns true if the event passes the -e and -q filters
+func (d *dumper) keep(e *evtx.GoEvtxMap) bool {
+	if d.eventIds != nil && !e.IsEventID(d.eventIds...) {
+		return false
+	}
+	return d.filter == nil || d.filter.Match(e)
+}
+
+// addMessage adds the message rendered of an event under
+// Event/RenderingInfo/Message, the RenderingInfo of forwarded events is kept
+func addMessage(e *evtx.GoEvtxMap, id int64, msg string, err error) {
+	switch {
+	case errors.Is(err, message.ErrNoProvider), errors.Is(err, message.ErrNoMessage):
+		return
+	case err != nil:
+		log.Errorf("record %d: %s", id, err)
+		return
+	}
+	if err = e.Set(&renderingMessagePath, msg); err != nil {
+		_ = e.Set(&renderingInfoPath, evtx.GoEvtxMap{"Message": msg})
+	}
+}
+
+func dumpRecovered(ef *evtx.File, name string) error {
+	out, err := output.Create(name, output.JSON, output.None)
+	if err != nil {
+		return err
+	}
+	if err = writeCarvedEvents(out, ef.RecoveredEvents(), false); err != nil {
+		_ = out.Abort()
+		return err
+	}
+	return out.Close()
+}
 
-		_ = f.Close()
+func parseCheckSumMode(mode string) (evtx.CheckSumMode, error) {
+	switch mode {
+	case "ignore":
+		return evtx.CheckSumIgnore, nil
+	case "lenient":
+		return evtx.CheckSumLenient, nil
+	case "strict":
+		return evtx.CheckSumStrict, nil
 	}
+	return evtx.CheckSumIgnore, fmt.Errorf("unknown checksum mode: %s", mode)
 }
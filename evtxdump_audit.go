@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"rawsec-evtx/evtx"
+	"rawsec-evtx/evtx/audit"
+	"rawsec-evtx/log"
+	"rawsec-evtx/output"
+	"syscall"
+	"time"
+)
+
+type auditReport struct {
+	File string
+	*audit.Report
+}
+
+func auditMain(args []string) {
+	var tolerance time.Duration
+	var jsonl bool
+	var workers int
+
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.DurationVar(&tolerance, "t", time.Second, "Backward jump of the record timestamps tolerated")
+	fs.BoolVar(&jsonl, "json", false, "Write one JSON report per file instead of text")
+	fs.IntVar(&workers, "w", evtx.MaxJobs, "Number of chunks decoded in parallel")
+	fs.Usage = func() {
+		fmt.Printf("%s\nUsage of %s audit: %[2]s audit [OPTIONS] FILES...\n", version, filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var w *output.Writer
+	var err error
+	if jsonl {
+		if w, err = output.NewWriter(os.Stdout, output.JSONL, output.None); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+	}
+	opts := audit.Options{TimeTolerance: tolerance}
+	for _, path := range fs.Args() {
+		var r *audit.Report
+		if r, err = auditFile(ctx, path, opts, workers); err != nil {
+			log.Errorf("%s: %s", path, err)
+			err = nil
+			continue
+		}
+		if w != nil {
+			err = w.Encode(auditReport{path, r})
+		} else {
+			printReport(path, r)
+		}
+		if ctx.Err() != nil || err != nil {
+			break
+		}
+	}
+	if w != nil {
+		closeOutput(w, err, ctx.Err() != nil)
+	}
+}
+
+func auditFile(ctx context.Context, path string, opts audit.Options, workers int) (*audit.Report, error) {
+	// the header is audited as it is on disk, a dirty file is reported
+	ef, err := evtx.OpenWithOptions(path, evtx.Options{Workers: workers, Mmap: true})
+	if err != nil && err != evtx.ErrDirtyFile {
+		return nil, err
+	}
+	r, err := audit.Run(ctx, &ef, opts)
+	if ctx.Err() == nil {
+		// workers interrupted may still be reading the mapping
+		ef.Close()
+	}
+	return r, err
+}
+
+func printReport(path string, r *audit.Report) {
+	fmt.Printf("%s: %d records %d-%d in %d chunks (%d in file header), next record ID %d, %d errors, %d findings\n",
+		path, r.Records, r.FirstRecordID, r.LastRecordID, r.Chunks, r.HeaderChunks, r.NextRecordID, r.Errors, len(r.Findings))
+	if r.Records > 0 {
+		fmt.Printf("  from %s to %s\n", r.FirstTimestamp.UTC().Format(time.RFC3339), r.LastTimestamp.UTC().Format(time.RFC3339))
+	}
+	for _, f := range r.Findings {
+		fmt.Printf("  %-18s %s\n", f.Kind, f.Message)
+	}
+}
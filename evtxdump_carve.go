@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rawsec-evtx/evtx"
+	"rawsec-evtx/log"
+	"rawsec-evtx/output"
+	"strings"
+)
+
+type carvedOutput struct {
+	Offset      int64
+	ChunkOffset int64
+	RecordID    int64
+	Timestamp   evtx.UTCTime
+	Recovered   bool            `json:",omitempty"`
+	Error       string          `json:",omitempty"`
+	Event       *evtx.GoEvtxMap `json:",omitempty"`
+}
+
+func carveMain(args []string) {
+	var checkSumMode string
+	var withBroken bool
+
+	fs := flag.NewFlagSet("carve", flag.ExitOnError)
+	fs.StringVar(&checkSumMode, "c", "lenient", "Checksum verification mode of carved chunks (ignore|lenient|strict)")
+	fs.BoolVar(&withBroken, "b", false, "Also dump records whose content could not be decoded")
+	fs.Usage = func() {
+		fmt.Printf("%s\nUsage of %s carve: %[2]s carve [OPTIONS] IMAGES...\n", version, filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	crcMode, err := parseCheckSumMode(checkSumMode)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	for _, image := range fs.Args() {
+		if err := carveImage(image, crcMode, withBroken); err != nil {
+			log.Errorf("%s: %s", image, err)
+		}
+	}
+}
+
+func carveImage(image string, crcMode evtx.CheckSumMode, withBroken bool) error {
+	in, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(image, filepath.Ext(image)) + ".carved.json"
+	out, err := output.Create(name, output.JSON, output.None)
+	if err != nil {
+		return err
+	}
+
+	carver := evtx.NewCarver(in, stat.Size())
+	carver.CheckSumMode = crcMode
+
+	if err = writeCarvedEvents(out, carver.Events(), withBroken); err != nil {
+		_ = out.Abort()
+		return err
+	}
+	return out.Close()
+}
+
+func writeCarvedEvents(out *output.Writer, events chan evtx.CarvedEvent, withBroken bool) (err error) {
+	for ce := range events {
+		if ce.Event == nil && !withBroken {
+			continue
+		}
+		co := carvedOutput{
+			Offset:      ce.Offset,
+			ChunkOffset: ce.ChunkOffset,
+			RecordID:    ce.Header.ID,
+			Timestamp:   ce.Header.Timestamp.Time(),
+			Recovered:   ce.Recovered,
+			Event:       ce.Event,
+		}
+		if ce.Err != nil {
+			co.Error = ce.Err.Error()
+		}
+		if err = out.Encode(co); err != nil {
+			return err
+		}
+	}
+	return nil
+}
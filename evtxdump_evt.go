@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"rawsec-evtx/evt"
+	"rawsec-evtx/evtx"
+	"rawsec-evtx/output"
+)
+
+func isEvtFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return evt.IsEvt(magic)
+}
+
+func (d *dumper) dumpEvt(ctx context.Context, path string, w *output.Writer) error {
+	if w.Format() == output.XML {
+		return fmt.Errorf("%s: %s output is not supported for .evt files", path, w.Format())
+	}
+
+	ef, err := evt.Open(path)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for r := range ef.RecordsContext(ctx) {
+		e := r.GoEvtxMap()
+		if !d.keep(e) {
+			continue
+		}
+		if d.catalog != nil {
+			msg, err := d.catalog.RenderEvent(r.System(), r.Strings)
+			addMessage(e, int64(r.Header.RecordNumber), msg, err)
+		}
+		if d.metadata {
+			(*e)[evtx.RecordMetadataKey] = r.Metadata()
+		}
+		if err = w.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
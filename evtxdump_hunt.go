@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"rawsec-evtx/evt"
+	"rawsec-evtx/evtx"
+	"rawsec-evtx/evtx/sigma"
+	"rawsec-evtx/log"
+	"rawsec-evtx/output"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+type huntRule struct {
+	Title string
+	ID    string   `json:",omitempty"`
+	Level string   `json:",omitempty"`
+	Tags  []string `json:",omitempty"`
+	Path  string
+}
+
+type huntMatch struct {
+	Rule  huntRule
+	File  string
+	Event *evtx.GoEvtxMap
+}
+
+// stringList is a flag which can be repeated
+type stringList []string
+
+func (p *stringList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *stringList) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+func huntMain(args []string) {
+	var rules stringList
+	var config, out, format, compression string
+	var workers int
+
+	fs := flag.NewFlagSet("hunt", flag.ExitOnError)
+	fs.Var(&rules, "r", "Sigma rule file or directory, can be repeated")
+	fs.StringVar(&config, "config", "", "YAML file with the fieldmappings, services and categories added to the default ones")
+	fs.StringVar(&out, "o", output.Stdout, "Output file receiving the matches")
+	fs.StringVar(&format, "format", "jsonl", "Output format (json|jsonl)")
+	fs.StringVar(&compression, "z", "", "Output compression (gzip|zstd), guessed from the -o extension by default")
+	fs.IntVar(&workers, "w", evtx.MaxJobs, "Number of chunks decoded and matched in parallel")
+	fs.Usage = func() {
+		fmt.Printf("%s\nUsage of %s hunt: %[2]s hunt -r RULES [OPTIONS] FILES...\n", version, filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if len(rules) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	c := &sigma.DefaultConfig
+	if config != "" {
+		var err error
+		if c, err = sigma.LoadConfig(config); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+	}
+	rs := sigma.NewRuleset(c)
+	for _, path := range rules {
+		// rules failing to load do not prevent using the others
+		if err := rs.Load(path); err != nil {
+			log.Error(err)
+		}
+	}
+	if rs.Len() == 0 {
+		log.Error("no rule loaded")
+		os.Exit(1)
+	}
+
+	oformat, err := output.ParseFormat(format)
+	if err == nil && oformat == output.XML {
+		err = fmt.Errorf("matches cannot be written as %s", oformat)
+	}
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	ocompression := output.CompressionOf(out)
+	if compression != "" {
+		if ocompression, err = output.ParseCompression(compression); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w, err := output.Create(out, oformat, ocompression)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	h := hunter{rules: rs, w: w, workers: workers}
+	for _, path := range fs.Args() {
+		if err := h.huntFile(ctx, path); err != nil {
+			log.Errorf("%s: %s", path, err)
+		}
+		if ctx.Err() != nil || h.err != nil {
+			break
+		}
+	}
+	closeOutput(w, h.err, ctx.Err() != nil)
+}
+
+type hunter struct {
+	rules   *sigma.Ruleset
+	workers int
+	sync.Mutex
+	w *output.Writer
+	// first write error, the hunt stops
+	err error
+}
+
+func (h *hunter) match(path string, e *evtx.GoEvtxMap, event func() *evtx.GoEvtxMap) {
+	matches := h.rules.Match(e)
+	if len(matches) == 0 {
+		return
+	}
+	// the event written carries the record metadata when there is some
+	e = event()
+	h.Lock()
+	defer h.Unlock()
+	for _, r := range matches {
+		if h.err != nil {
+			return
+		}
+		h.err = h.w.Encode(huntMatch{
+			Rule:  huntRule{Title: r.Title, ID: r.ID, Level: r.Level, Tags: r.Tags, Path: r.Path},
+			File:  path,
+			Event: e,
+		})
+	}
+}
+
+func (h *hunter) huntFile(ctx context.Context, path string) error {
+	switch {
+	case isEvtFile(path):
+		ef, err := evt.Open(path)
+		if err != nil {
+			return err
+		}
+		for r := range ef.Records() {
+			e := r.GoEvtxMap()
+			h.match(path, e, func() *evtx.GoEvtxMap { return e })
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil
+
+	case isXMLFile(path):
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		xd := evtx.NewXMLDecoder(bufio.NewReader(in))
+		for ctx.Err() == nil {
+			e, err := xd.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			h.match(path, e, func() *evtx.GoEvtxMap { return e })
+		}
+		return nil
+	}
+
+	ef, err := evtx.OpenWithOptions(path, evtx.Options{Workers: h.workers, Dirty: true, Mmap: true})
+	if err != nil {
+		return err
+	}
+	records := ef.UnorderedRecordsContext(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < h.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range records {
+				if r.Err != nil {
+					log.Error(r.Err)
+					continue
+				}
+				if r.Event != nil {
+					h.match(path, r.Event, r.GoEvtxMap)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() == nil {
+		// workers interrupted may still be reading the mapping
+		return ef.Close()
+	}
+	return nil
+}
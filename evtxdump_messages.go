@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rawsec-evtx/evtx/message"
+	"rawsec-evtx/log"
+	"strings"
+)
+
+func messagesMain(args []string) {
+	var classic, params stringList
+	var out, lang string
+
+	fs := flag.NewFlagSet("messages", flag.ExitOnError)
+	fs.StringVar(&out, "o", "messages.json", "Message catalog written")
+	fs.StringVar(&lang, "lang", "en-US", "Language of the MUI files looked for under the directory of the modules")
+	fs.Var(&classic, "classic", "Classic provider and its EventMessageFile, ex: 'Service Control Manager=netevent.dll', can be repeated")
+	fs.Var(&params, "params", "ParameterMessageFile whose messages replace the %%n insertion strings, ex: msobjs.dll, can be repeated")
+	fs.Usage = func() {
+		fmt.Printf("%s\nUsage of %s messages: %[2]s messages [OPTIONS] MODULES...\n", version, filepath.Base(os.Args[0]))
+		fmt.Println("Builds a message catalog from the WEVT_TEMPLATE and MESSAGETABLE resources of provider DLLs")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 && len(classic) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	c := message.NewCatalog()
+	for _, path := range fs.Args() {
+		if m, err := openModule(path, lang); err != nil {
+			log.Error(err)
+		} else {
+			c.AddModule(m)
+		}
+	}
+	for _, arg := range classic {
+		name, path, ok := strings.Cut(arg, "=")
+		if !ok {
+			log.Errorf("classic provider without EventMessageFile: %s", arg)
+			continue
+		}
+		if m, err := openModule(path, lang); err != nil {
+			log.Error(err)
+		} else {
+			c.AddClassic(name, m)
+		}
+	}
+	for _, path := range params {
+		if m, err := openModule(path, lang); err != nil {
+			log.Error(err)
+		} else {
+			c.AddParameters(m)
+		}
+	}
+
+	if err := c.Save(out); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+// openModule opens a module with the messages of its MUI file if any
+func openModule(path, lang string) (*message.Module, error) {
+	m, err := message.OpenModule(path)
+	if err != nil {
+		return nil, err
+	}
+	mui := filepath.Join(filepath.Dir(path), lang, filepath.Base(path)+".mui")
+	if _, err := os.Stat(mui); err == nil {
+		other, err := message.OpenModule(mui)
+		if err != nil {
+			return nil, err
+		}
+		m.Merge(other)
+	}
+	return m, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"rawsec-evtx/evtx"
+	"rawsec-evtx/log"
+	"rawsec-evtx/output"
+)
+
+func isXMLFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = bytes.TrimPrefix(head[:n], []byte("\xef\xbb\xbf"))
+	return bytes.HasPrefix(bytes.TrimSpace(head), []byte("<"))
+}
+
+func (d *dumper) dumpXML(path string, w *output.Writer) error {
+	if w.Format() == output.XML {
+		return fmt.Errorf("%s: XML files are converted to JSON", path)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// the order of the insertion strings is lost once the events are decoded
+	if d.catalog != nil {
+		log.Errorf("%s: messages are not rendered for XML files", path)
+	}
+
+	xd := evtx.NewXMLDecoder(bufio.NewReader(in))
+	for {
+		e, err := xd.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.keep(e) {
+			continue
+		}
+		if err = w.Encode(e); err != nil {
+			return err
+		}
+	}
+}
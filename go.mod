@@ -1,6 +1,8 @@
 module rawsec-evtx
 
-go 1.20
+go 1.22
 
 require (
+	github.com/klauspost/compress v1.18.0
+	gopkg.in/yaml.v3 v3.0.1
 )
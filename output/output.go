@@ -0,0 +1,246 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Format string
+
+const (
+	// JSON writes the events in a single JSON array
+	JSON Format = "json"
+	// JSONL writes one JSON event per line
+	JSONL Format = "jsonl"
+	// XML writes one XML event per line
+	XML Format = "xml"
+)
+
+type Compression string
+
+const (
+	None Compression = ""
+	Gzip Compression = "gzip"
+	Zstd Compression = "zstd"
+)
+
+// Stdout is the path writing to the standard output
+const Stdout = "-"
+
+var (
+	ErrNotJSON = errors.New("output format does not encode JSON")
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case JSON, JSONL, XML:
+		return f, nil
+	case "ndjson":
+		return JSONL, nil
+	}
+	return "", fmt.Errorf("unknown output format: %s", s)
+}
+
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(strings.ToLower(s)); c {
+	case None, Gzip, Zstd:
+		return c, nil
+	case "none":
+		return None, nil
+	case "gz":
+		return Gzip, nil
+	case "zst":
+		return Zstd, nil
+	}
+	return None, fmt.Errorf("unknown compression: %s", s)
+}
+
+// CompressionOf guesses the compression from the extension of path
+func CompressionOf(path string) Compression {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz":
+		return Gzip
+	case ".zst":
+		return Zstd
+	}
+	return None
+}
+
+// Ext returns the extension of the files written with format and compression
+func Ext(format Format, compression Compression) string {
+	ext := "." + string(format)
+	switch compression {
+	case Gzip:
+		ext += ".gz"
+	case Zstd:
+		ext += ".zst"
+	}
+	return ext
+}
+
+// Writer writes events one after the other, the output is complete and
+// well formed only once closed
+type Writer struct {
+	format     Format
+	buf        *bufio.Writer
+	compressor io.WriteCloser
+	// file being written, nil when writing to a caller's writer
+	file  *os.File
+	path  string
+	count int
+	err   error
+}
+
+func NewWriter(w io.Writer, format Format, compression Compression) (*Writer, error) {
+	ow := &Writer{format: format}
+	switch compression {
+	case None:
+	case Gzip:
+		ow.compressor = gzip.NewWriter(w)
+	case Zstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		ow.compressor = zw
+	default:
+		return nil, fmt.Errorf("unknown compression: %s", compression)
+	}
+	if ow.compressor != nil {
+		w = ow.compressor
+	}
+	ow.buf = bufio.NewWriterSize(w, 64*1024)
+	return ow, nil
+}
+
+// Create writes to path, or to the standard output for Stdout. Files are
+// written to a temporary file renamed to path on Close, a failed or
+// interrupted run never leaves a truncated output behind.
+func Create(path string, format Format, compression Compression) (*Writer, error) {
+	if path == Stdout {
+		return NewWriter(os.Stdout, format, compression)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	ow, err := NewWriter(file, format, compression)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	ow.file = file
+	ow.path = path
+	return ow, nil
+}
+
+func (w *Writer) Format() Format {
+	return w.format
+}
+
+// Count returns the number of events written so far
+func (w *Writer) Count() int {
+	return w.count
+}
+
+// Encode writes v as a JSON event
+func (w *Writer) Encode(v interface{}) error {
+	if w.format == XML {
+		return ErrNotJSON
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.WriteRaw(b)
+}
+
+// WriteRaw writes an event already encoded in the format of the writer, a
+// JSON event must not span several lines while the line breaks of an XML
+// event are escaped
+func (w *Writer) WriteRaw(event []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.format == XML {
+		event = xmlLine(event)
+	}
+	switch {
+	case w.format != JSON:
+	case w.count == 0:
+		w.buf.WriteByte('[')
+	default:
+		w.buf.WriteByte(',')
+	}
+	w.buf.Write(event)
+	if w.format != JSON {
+		w.buf.WriteByte('\n')
+	}
+	w.count++
+	// bufio keeps the first write error
+	_, w.err = w.buf.Write(nil)
+	return w.err
+}
+
+// Close completes the output, renaming the temporary file written by Create
+// to its final path
+func (w *Writer) Close() error {
+	if w.err == nil && w.format == JSON {
+		if w.count == 0 {
+			w.buf.WriteString("[]\n")
+		} else {
+			w.buf.WriteString("]\n")
+		}
+	}
+	err := w.buf.Flush()
+	if w.compressor != nil {
+		if cerr := w.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if w.err != nil {
+		err = w.err
+	}
+	if w.file == nil {
+		return err
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+	return os.Rename(w.file.Name(), w.path)
+}
+
+// Abort discards what was written, the file at path is left untouched. What
+// went to the standard output cannot be taken back, it is closed instead.
+func (w *Writer) Abort() error {
+	if w.file == nil {
+		return w.Close()
+	}
+	_ = w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+// xmlLine escapes the line breaks of an XML event as character references,
+// the events rendered have line breaks in their values only
+func xmlLine(event []byte) []byte {
+	if bytes.IndexAny(event, "\r\n") < 0 {
+		return event
+	}
+	event = bytes.ReplaceAll(event, []byte("\r"), []byte("&#13;"))
+	return bytes.ReplaceAll(event, []byte("\n"), []byte("&#10;"))
+}
@@ -0,0 +1,208 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"rawsec-evtx/evtx"
+)
+
+var events = []map[string]interface{}{
+	{"EventID": "4624", "Data": "a\nb"},
+	{"EventID": "4625"},
+}
+
+func encode(t *testing.T, w *Writer) {
+	t.Helper()
+	for _, e := range events {
+		if err := w.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for _, tc := range []struct {
+		format Format
+		n      int
+		want   string
+	}{
+		{JSON, 0, "[]\n"},
+		{JSON, 2, `[{"Data":"a\nb","EventID":"4624"},{"EventID":"4625"}]` + "\n"},
+		{JSONL, 0, ""},
+		{JSONL, 2, `{"Data":"a\nb","EventID":"4624"}` + "\n" + `{"EventID":"4625"}` + "\n"},
+	} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, tc.format, None)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events[:tc.n] {
+			if err := w.Encode(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.want {
+			t.Errorf("%s with %d events: got %q, want %q", tc.format, tc.n, buf, tc.want)
+		}
+	}
+
+	w, _ := NewWriter(io.Discard, XML, None)
+	if err := w.Encode(events[0]); err != ErrNotJSON {
+		t.Errorf("expected %v encoding JSON to XML output, got %v", ErrNotJSON, err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, tc := range []struct {
+		compression Compression
+		decompress  func(io.Reader) (io.Reader, error)
+	}{
+		{Gzip, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{Zstd, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(buf, JSON, tc.compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encode(t, w)
+		r, err := tc.decompress(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []map[string]interface{}
+		if err := json.NewDecoder(r).Decode(&got); err != nil {
+			t.Fatalf("%s: %s", tc.compression, err)
+		}
+		if len(got) != len(events) {
+			t.Errorf("%s: expected %d events, got %d", tc.compression, len(events), len(got))
+		}
+	}
+}
+
+func TestCreateAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.jsonl")
+	if err := os.WriteFile(path, []byte("previous\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// an aborted output leaves the previous one untouched
+	w, err := Create(path, JSONL, None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Encode(events[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "previous\n" {
+		t.Errorf("aborted output replaced the previous one: %q", b)
+	}
+
+	w, err = Create(path, JSONL, None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode(t, w)
+	if b, _ := os.ReadFile(path); bytes.Count(b, []byte("\n")) != len(events) {
+		t.Errorf("unexpected output: %q", b)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if f, err := ParseFormat("NDJSON"); f != JSONL || err != nil {
+		t.Errorf("ndjson parsed as %q, %v", f, err)
+	}
+	if _, err := ParseFormat("csv"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if c := CompressionOf("events.jsonl.zst"); c != Zstd {
+		t.Errorf("expected zstd, got %q", c)
+	}
+	if ext := Ext(JSONL, Gzip); ext != ".jsonl.gz" {
+		t.Errorf("unexpected extension %s", ext)
+	}
+}
+
+func TestXMLLines(t *testing.T) {
+	script := "Get-Process |\r\n  Where-Object { $_.CPU -gt 10 }\nexit"
+	path := filepath.Join(t.TempDir(), "multiline.evtx")
+	ew, err := evtx.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := evtx.GoEvtxMap{"Event": map[string]interface{}{
+		"System":    map[string]interface{}{"EventID": "4104", "EventRecordID": "1"},
+		"EventData": map[string]interface{}{"ScriptBlockText": script},
+	}}
+	if err = ew.WriteEvent(&e); err != nil {
+		t.Fatal(err)
+	}
+	if err = ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ef, err := evtx.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf, XML, None)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range ef.Records() {
+		x, err := r.XML()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.WriteRaw(x); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("event written on %d lines: %q", len(lines), buf)
+	}
+	var event struct {
+		Data string `xml:"EventData>Data"`
+	}
+	if err = xml.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Data != script {
+		t.Errorf("got %q, want %q", event.Data, script)
+	}
+}